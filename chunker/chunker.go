@@ -0,0 +1,340 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package chunker splits long documents into overlapping chunks
+// small enough to embed.
+//
+// Embedding models have a limited context, and text beyond it is
+// silently dropped, so a long document must be embedded in pieces.
+// [Split] breaks a document's text into chunks of at most a given
+// number of tokens, preferring to break between markdown sections
+// and paragraphs and never breaking inside a code fence
+// unless the fence alone exceeds the budget.
+// Consecutive chunks within a section overlap,
+// so that text near a break appears with context in some chunk.
+//
+// Each chunk is a contiguous slice of the document text,
+// identified by the document ID and the chunk's index,
+// as in "https://example.com/doc#chunk-3".
+// [ParseID] maps a chunk ID back to the document ID.
+package chunker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/superryanguo/ryai/docs"
+)
+
+// A Chunk is a contiguous piece of a document's text.
+type Chunk struct {
+	ID       string   // chunk ID: DocID#chunk-Index
+	DocID    string   // ID of document
+	Index    int      // index of chunk in document, starting at 0
+	Title    string   // title of document
+	Headings []string // enclosing markdown headings, outermost first
+	Text     string   // text of chunk, equal to document text[Start:End]
+	Start    int      // byte offset of chunk in document text
+	End      int      // byte offset of end of chunk in document text
+}
+
+// EmbedTitle returns a title for embedding the chunk,
+// made from the document title and the enclosing headings.
+func (c *Chunk) EmbedTitle() string {
+	return strings.Join(append([]string{c.Title}, c.Headings...), " › ")
+}
+
+// Options are options for [Split].
+// The zero value (or a nil *Options) selects the defaults.
+type Options struct {
+	// MaxTokens is the maximum number of tokens in a chunk.
+	// The default is 512.
+	MaxTokens int
+
+	// Overlap is the maximum number of tokens repeated
+	// from the end of a chunk at the start of the next chunk
+	// in the same section. The default is 64.
+	// Overlap is limited to MaxTokens/2.
+	// To disable overlap, set Overlap to a negative number.
+	Overlap int
+
+	// Tokens returns the number of tokens in text.
+	// The default is [EstimateTokens].
+	Tokens func(text string) int
+}
+
+// EstimateTokens returns an estimate of the number of tokens in text,
+// suitable for typical embedding model tokenizers:
+// one token per four bytes of text, but at least one token per word.
+func EstimateTokens(text string) int {
+	words := 0
+	space := true
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			space = true
+		} else if space {
+			words++
+			space = false
+		}
+	}
+	return max((len(text)+3)/4, words)
+}
+
+// ID returns the ID of the chunk of the document docID with the given index.
+func ID(docID string, index int) string {
+	return fmt.Sprintf("%s#chunk-%d", docID, index)
+}
+
+// ParseID returns the document ID and chunk index in the chunk ID id.
+// If id is not a chunk ID, ParseID returns "", 0, false.
+func ParseID(id string) (docID string, index int, ok bool) {
+	i := strings.LastIndex(id, "#chunk-")
+	if i < 0 {
+		return "", 0, false
+	}
+	n := id[i+len("#chunk-"):]
+	index, err := strconv.Atoi(n)
+	if err != nil || index < 0 || strconv.Itoa(index) != n {
+		return "", 0, false
+	}
+	return id[:i], index, true
+}
+
+// Split splits the text of d into chunks.
+// It returns no chunks if the text is empty or all white space.
+func Split(d *docs.Doc, opts *Options) []Chunk {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.MaxTokens <= 0 {
+		o.MaxTokens = 512
+	}
+	switch {
+	case o.Overlap == 0:
+		o.Overlap = 64
+	case o.Overlap < 0:
+		o.Overlap = 0
+	}
+	o.Overlap = min(o.Overlap, o.MaxTokens/2)
+	if o.Tokens == nil {
+		o.Tokens = EstimateTokens
+	}
+
+	var pieces []piece
+	for _, b := range blocks(d.Text) {
+		pieces = split(pieces, &o, d.Text, b)
+	}
+
+	var chunks []Chunk
+	emit := func(cur []piece) {
+		start, end := cur[0].start, cur[len(cur)-1].end
+		chunks = append(chunks, Chunk{
+			ID:       ID(d.ID, len(chunks)),
+			DocID:    d.ID,
+			Index:    len(chunks),
+			Title:    d.Title,
+			Headings: cur[0].headings,
+			Text:     d.Text[start:end],
+			Start:    start,
+			End:      end,
+		})
+	}
+
+	var cur []piece
+	for _, p := range pieces {
+		switch {
+		case p.section && len(cur) > 0:
+			// Start each section in a new chunk, without overlap.
+			emit(cur)
+			cur = nil
+		case len(cur) > 0 && o.Tokens(d.Text[cur[0].start:p.end]) > o.MaxTokens:
+			emit(cur)
+			// Keep the longest suffix of cur that fits in the overlap
+			// and leaves room for p.
+			keep, end := len(cur), cur[len(cur)-1].end
+			for keep > 0 &&
+				o.Tokens(d.Text[cur[keep-1].start:end]) <= o.Overlap &&
+				o.Tokens(d.Text[cur[keep-1].start:p.end]) <= o.MaxTokens {
+				keep--
+			}
+			cur = cur[keep:]
+		}
+		cur = append(cur, p)
+	}
+	if len(cur) > 0 {
+		emit(cur)
+	}
+	return chunks
+}
+
+// A block is a markdown heading, paragraph, or code fence
+// in a document's text.
+type block struct {
+	start, end int      // offsets in text
+	heading    bool     // block is a heading
+	headings   []string // enclosing headings, including the block itself
+}
+
+// blocks returns the blocks in text.
+// Blocks are separated by blank lines,
+// except within code fences, and each heading line is its own block.
+func blocks(text string) []block {
+	var (
+		bs       []block
+		headings []string // current heading path
+		levels   []int    // levels of headings
+		start    = -1     // start of current block, or -1
+		end      int      // end of last non-blank line in current block
+		fence    string   // opening fence, if in a code fence
+	)
+	flush := func() {
+		if start >= 0 {
+			bs = append(bs, block{start: start, end: end, headings: headings})
+			start = -1
+		}
+	}
+	for off := 0; off < len(text); {
+		line, next := text[off:], len(text)
+		if i := strings.IndexByte(line, '\n'); i >= 0 {
+			line, next = line[:i], off+i+1
+		}
+		trim := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		switch {
+		case fence != "":
+			// In a code fence; a closing fence ends the block.
+			if trim != "" {
+				end = off + len(strings.TrimRight(line, " \t\r"))
+			}
+			if indent < 4 && strings.HasPrefix(trim, fence) && strings.Trim(trim, fence[:1]) == "" {
+				fence = ""
+				flush()
+			}
+
+		case indent < 4 && (strings.HasPrefix(trim, "```") || strings.HasPrefix(trim, "~~~")):
+			flush()
+			fence = trim[:3]
+			for len(fence) < len(trim) && trim[len(fence)] == fence[0] {
+				fence = trim[:len(fence)+1]
+			}
+			start, end = off+indent, off+len(strings.TrimRight(line, " \t\r"))
+
+		case trim == "":
+			flush()
+
+		case indent < 4 && headingLevel(trim) > 0:
+			flush()
+			level := headingLevel(trim)
+			for len(levels) > 0 && levels[len(levels)-1] >= level {
+				levels = levels[:len(levels)-1]
+			}
+			headings = append(headings[:len(levels):len(levels)], strings.Trim(strings.TrimSpace(trim[level:]), "# "))
+			levels = append(levels, level)
+			bs = append(bs, block{start: off + indent, end: off + len(strings.TrimRight(line, " \t\r")), heading: true, headings: headings})
+
+		default:
+			if start < 0 {
+				start = off + indent
+			}
+			end = off + len(strings.TrimRight(line, " \t\r"))
+		}
+		off = next
+	}
+	flush()
+	return bs
+}
+
+// headingLevel returns the level of the markdown ATX heading line,
+// or 0 if line is not a heading.
+func headingLevel(line string) int {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || (n < len(line) && line[n] != ' ' && line[n] != '\t') {
+		return 0
+	}
+	return n
+}
+
+// A piece is a part of a block small enough to fit in a chunk.
+type piece struct {
+	start, end int
+	section    bool // piece starts a new section
+	headings   []string
+}
+
+// separators are the places to split a block that is too large,
+// in order of preference. The empty separator splits between runes.
+var separators = []string{"\n", " ", ""}
+
+// split appends to pieces the block b of text,
+// split into pieces if it does not fit in a chunk.
+// The pieces of a split block are no larger than the overlap,
+// so that consecutive chunks of the block can overlap.
+func split(pieces []piece, o *Options, text string, b block) []piece {
+	p := piece{start: b.start, end: b.end, section: b.heading, headings: b.headings}
+	if o.Tokens(text[p.start:p.end]) <= o.MaxTokens {
+		return append(pieces, p)
+	}
+	limit := o.MaxTokens
+	if o.Overlap > 0 {
+		limit = o.Overlap
+	}
+	return splitPiece(pieces, o, text, p, 0, limit)
+}
+
+// splitPiece appends p to pieces, first splitting it
+// at separators[sep:] if it has more than limit tokens.
+func splitPiece(pieces []piece, o *Options, text string, p piece, sep, limit int) []piece {
+	if p.end-p.start <= 1 || sep >= len(separators) || o.Tokens(text[p.start:p.end]) <= limit {
+		return append(pieces, p)
+	}
+
+	// Split p into units at the separator, each unit
+	// including any trailing separator, and pack the units
+	// greedily into pieces, splitting oversized units further.
+	s := separators[sep]
+	first := true
+	cur := piece{start: p.start, end: p.start, headings: p.headings}
+	tokens := 0
+	add := func() {
+		q := cur
+		if s != "" {
+			q.end = q.start + len(strings.TrimRight(text[q.start:q.end], " \t\r\n"))
+		}
+		if q.end == q.start {
+			return
+		}
+		q.section = p.section && first
+		first = false
+		pieces = splitPiece(pieces, o, text, q, sep+1, limit)
+	}
+	for off := p.start; off < p.end; {
+		next := p.end
+		if s == "" {
+			_, size := utf8.DecodeRuneInString(text[off:p.end])
+			next = off + size
+		} else if i := strings.Index(text[off:p.end], s); i >= 0 {
+			next = off + i + len(s)
+		}
+		// Approximate the tokens in the piece by the sum
+		// of the tokens in its units, to avoid quadratic work.
+		n := o.Tokens(text[off:next])
+		if cur.end > cur.start && tokens+n > limit {
+			add()
+			cur.start, tokens = off, 0
+		}
+		cur.end = next
+		tokens += n
+		off = next
+	}
+	add()
+	return pieces
+}
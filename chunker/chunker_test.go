@@ -0,0 +1,209 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chunker
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/superryanguo/ryai/docs"
+)
+
+// words returns the number of words in text,
+// for use as a simple and exact token count.
+func words(text string) int {
+	return len(strings.Fields(text))
+}
+
+// checkChunks checks the invariants of the chunks of d.
+func checkChunks(t *testing.T, d *docs.Doc, chunks []Chunk, opts *Options) {
+	t.Helper()
+	for i, c := range chunks {
+		if c.ID != ID(d.ID, i) || c.DocID != d.ID || c.Index != i || c.Title != d.Title {
+			t.Errorf("chunk %d: ID=%q DocID=%q Index=%d Title=%q", i, c.ID, c.DocID, c.Index, c.Title)
+		}
+		if c.Text != d.Text[c.Start:c.End] {
+			t.Errorf("chunk %d: Text=%q, but text[%d:%d]=%q", i, c.Text, c.Start, c.End, d.Text[c.Start:c.End])
+		}
+		if strings.TrimSpace(c.Text) != c.Text || c.Text == "" {
+			t.Errorf("chunk %d: Text=%q not trimmed", i, c.Text)
+		}
+		if n := opts.Tokens(c.Text); n > opts.MaxTokens {
+			t.Errorf("chunk %d: %d tokens > %d: %q", i, n, opts.MaxTokens, c.Text)
+		}
+		if i > 0 && c.Start < chunks[i-1].Start {
+			t.Errorf("chunk %d: starts at %d before chunk %d at %d", i, c.Start, i-1, chunks[i-1].Start)
+		}
+	}
+	// Every word in the text appears in some chunk.
+	covered := make([]bool, len(d.Text))
+	for _, c := range chunks {
+		for i := c.Start; i < c.End; i++ {
+			covered[i] = true
+		}
+	}
+	for i, r := range d.Text {
+		if !covered[i] && !strings.ContainsRune(" \t\r\n", r) {
+			t.Errorf("text[%d]=%q not in any chunk", i, r)
+			break
+		}
+	}
+}
+
+func texts(chunks []Chunk) []string {
+	var s []string
+	for _, c := range chunks {
+		s = append(s, c.Text)
+	}
+	return s
+}
+
+func TestSplit(t *testing.T) {
+	opts := &Options{MaxTokens: 8, Overlap: 3, Tokens: words}
+	for _, tt := range []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", " \n\n ", nil},
+		{"short", "Just one paragraph.\n", []string{"Just one paragraph."}},
+		{
+			"paragraphs",
+			"one two three\n\nfour five six\n\nseven eight nine\n",
+			[]string{"one two three\n\nfour five six", "four five six\n\nseven eight nine"},
+		},
+		{
+			"long paragraph",
+			"a b c d e f g h i j k l",
+			[]string{"a b c d e f", "d e f g h i", "g h i j k l"},
+		},
+		{
+			"headings",
+			"intro\n\n# One\n\nfirst section\n\n## Sub\n\nsub text\n# Two\nsecond",
+			[]string{"intro", "# One\n\nfirst section", "## Sub\n\nsub text", "# Two\nsecond"},
+		},
+		{
+			"fence",
+			"before text here\n\n```go\nfunc f() {\n\n\treturn\n}\n```\n\nafter",
+			[]string{"before text here", "```go\nfunc f() {\n\n\treturn\n}\n```\n\nafter"},
+		},
+		{
+			"long fence",
+			"````\na b c\nd e f\ng h i\n```\nj k l\n````",
+			[]string{"````\na b c\nd e f", "d e f\ng h i\n```", "```\nj k l\n````"},
+		},
+	} {
+		d := &docs.Doc{ID: "doc", Title: "Title", Text: tt.text}
+		chunks := Split(d, opts)
+		checkChunks(t, d, chunks, opts)
+		if have := texts(chunks); !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%s: Split = %q, want %q", tt.name, have, tt.want)
+		}
+	}
+}
+
+func TestSplitHeadings(t *testing.T) {
+	d := &docs.Doc{ID: "doc", Title: "Guide", Text: "# A\n\na\n\n## B\n\nb\n\n### C #\n\nc\n\n## D\n\nd\n\n#notaheading\n\n    # code"}
+	chunks := Split(d, &Options{MaxTokens: 4, Tokens: words})
+	checkChunks(t, d, chunks, &Options{MaxTokens: 4, Tokens: words})
+	var have []string
+	for _, c := range chunks {
+		have = append(have, c.EmbedTitle())
+	}
+	want := []string{"Guide › A", "Guide › A › B", "Guide › A › B › C", "Guide › A › D", "Guide › A › D"}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("EmbedTitles = %q, want %q", have, want)
+	}
+	if last := chunks[len(chunks)-1].Text; !strings.HasSuffix(last, "    # code") {
+		t.Errorf("last chunk = %q, want indented code included", last)
+	}
+}
+
+func TestSplitLarge(t *testing.T) {
+	var b strings.Builder
+	for i := range 200 {
+		switch i % 20 {
+		case 0:
+			fmt.Fprintf(&b, "## Section %d\n\n", i)
+		case 7:
+			fmt.Fprintf(&b, "```\n%s\n```\n\n", strings.Repeat("code line\n", i%13+2))
+		default:
+			fmt.Fprintf(&b, "%s\n\n", strings.Repeat(fmt.Sprintf("word%d ", i), i%50+1))
+		}
+	}
+	b.WriteString(strings.Repeat("x", 5000)) // one very long word
+	d := &docs.Doc{ID: "https://example.com/doc#frag", Text: b.String()}
+	for _, opts := range []*Options{
+		{MaxTokens: 64, Tokens: words},
+		{MaxTokens: 64, Overlap: -1, Tokens: words},
+		{MaxTokens: 100},
+		{},
+	} {
+		chunks := Split(d, opts)
+		o := *opts
+		if o.MaxTokens == 0 {
+			o.MaxTokens = 512
+		}
+		if o.Tokens == nil {
+			o.Tokens = EstimateTokens
+		}
+		checkChunks(t, d, chunks, &o)
+		if len(chunks) < 2 {
+			t.Errorf("%+v: %d chunks", o, len(chunks))
+		}
+		if o.Overlap < 0 {
+			for i := 1; i < len(chunks); i++ {
+				if chunks[i].Start < chunks[i-1].End {
+					t.Errorf("%+v: chunks %d and %d overlap", o, i-1, i)
+				}
+			}
+		}
+	}
+}
+
+func TestParseID(t *testing.T) {
+	for _, tt := range []struct {
+		id    string
+		doc   string
+		index int
+		ok    bool
+	}{
+		{"doc#chunk-0", "doc", 0, true},
+		{"https://go.dev/x#frag#chunk-12", "https://go.dev/x#frag", 12, true},
+		{"#chunk-3", "", 3, true},
+		{"doc", "", 0, false},
+		{"doc#chunk-", "", 0, false},
+		{"doc#chunk-01", "", 0, false},
+		{"doc#chunk--1", "", 0, false},
+		{"doc#chunk-1x", "", 0, false},
+	} {
+		doc, index, ok := ParseID(tt.id)
+		if doc != tt.doc || index != tt.index || ok != tt.ok {
+			t.Errorf("ParseID(%q) = %q, %d, %v, want %q, %d, %v", tt.id, doc, index, ok, tt.doc, tt.index, tt.ok)
+		}
+		if ok && ID(doc, index) != tt.id {
+			t.Errorf("ID(ParseID(%q)) = %q", tt.id, ID(doc, index))
+		}
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abc", 1},
+		{"abcdefghi", 3},
+		{"a b c d e", 5},
+		{"  a\n\tb  ", 2},
+	} {
+		if have := EstimateTokens(tt.text); have != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, have, tt.want)
+		}
+	}
+}
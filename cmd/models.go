@@ -0,0 +1,172 @@
+/*
+Copyright © 2024 superryanguo
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/superryanguo/ryai/ollama"
+)
+
+// autoPull reports whether to pull missing Ollama models on first use.
+var autoPull bool
+
+var modelsCmd = &cobra.Command{
+	Use:   "models",
+	Short: "Manage the models on the Ollama server",
+	Long: `Manage the models on the Ollama server of the configured backend,
+without leaving ryai for the ollama command.`,
+}
+
+var modelsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the models on the server",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := modelsClient()
+		if err != nil {
+			return err
+		}
+		list, err := c.List(cmd.Context())
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "NAME\tSIZE\tPARAMS\tQUANT\tMODIFIED\n")
+		for _, m := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Name, formatBytes(m.Size),
+				m.Details.ParameterSize, m.Details.QuantizationLevel, m.ModifiedAt.Format("2006-01-02 15:04"))
+		}
+		return w.Flush()
+	},
+}
+
+var modelsShowCmd = &cobra.Command{
+	Use:   "show <model>",
+	Short: "Show the details of a model",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := modelsClient()
+		if err != nil {
+			return err
+		}
+		m, err := c.Show(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Model:        %s\n", args[0])
+		fmt.Printf("Family:       %s\n", m.Details.Family)
+		fmt.Printf("Parameters:   %s\n", m.Details.ParameterSize)
+		fmt.Printf("Quantization: %s\n", m.Details.QuantizationLevel)
+		fmt.Printf("Format:       %s\n", m.Details.Format)
+		if len(m.Capabilities) > 0 {
+			fmt.Printf("Capabilities: %s\n", strings.Join(m.Capabilities, ", "))
+		}
+		if m.Parameters != "" {
+			fmt.Printf("\n%s\n", m.Parameters)
+		}
+		return nil
+	},
+}
+
+var modelsPullCmd = &cobra.Command{
+	Use:   "pull <model>",
+	Short: "Pull a model to the server, showing progress",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := modelsClient()
+		if err != nil {
+			return err
+		}
+		progress := progressBar(os.Stderr)
+		for p, err := range c.Pull(cmd.Context(), args[0]) {
+			if err != nil {
+				return err
+			}
+			progress(p)
+		}
+		return nil
+	},
+}
+
+var modelsDeleteCmd = &cobra.Command{
+	Use:     "rm <model>",
+	Aliases: []string{"delete"},
+	Short:   "Delete a model from the server",
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := modelsClient()
+		if err != nil {
+			return err
+		}
+		if err := c.Delete(cmd.Context(), args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("deleted %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	modelsCmd.AddCommand(modelsListCmd, modelsShowCmd, modelsPullCmd, modelsDeleteCmd)
+	modelsCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		cmd.SilenceUsage = true // errors come from the server, not the command line
+	}
+}
+
+// modelsClient returns an Ollama client for the server of the configured backend.
+func modelsClient() (*ollama.Client, error) {
+	bc := ryaiCfg.Llm.Backend()
+	if bc.Type != ollama.BackendName {
+		return nil, fmt.Errorf("backend %s has type %q; model management needs %q", ryaiCfg.Llm.Name, bc.Type, ollama.BackendName)
+	}
+	return ollama.NewClient(logger, http.DefaultClient, bc.Server, bc.GenModel)
+}
+
+// progressBar returns a function that draws pull progress reports on w.
+// Each download is drawn as a bar on a single line, redrawn in place.
+func progressBar(w io.Writer) func(*ollama.PullProgress) {
+	const width = 30
+	last := ""
+	return func(p *ollama.PullProgress) {
+		if p.Total <= 0 {
+			if last != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintln(w, p.Status)
+			last = ""
+			return
+		}
+		if last != "" && last != p.Digest {
+			fmt.Fprintln(w)
+		}
+		last = p.Digest
+		// Ollama can report more completed than total bytes.
+		done := min(max(p.Completed, 0), p.Total)
+		n := int(width * done / p.Total)
+		fmt.Fprintf(w, "\r%s [%s%s] %3d%% %s/%s", p.Status,
+			strings.Repeat("=", n), strings.Repeat(" ", width-n),
+			100*done/p.Total, formatBytes(p.Completed), formatBytes(p.Total))
+	}
+}
+
+// formatBytes formats n bytes for humans, such as "2.0 GB".
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
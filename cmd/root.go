@@ -17,6 +17,7 @@ import (
 
 var (
 	cfgFile  string
+	ryaiCfg  config.RyaiConfig
 	logger   *slog.Logger
 	loglevel *slog.LevelVar
 )
@@ -44,9 +45,12 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "./conf/ryai.yaml", "config file (default is ./conf/ryai.yaml)")
 	rootCmd.PersistentFlags().BoolP("version", "v", false, "Print the version number")
+	rootCmd.PersistentFlags().BoolVar(&autoPull, "pull", false, "pull missing Ollama models on first use")
 
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(chatCmd)
+	rootCmd.AddCommand(modelsCmd)
+	rootCmd.AddCommand(usageCmd)
 }
 
 var versionCmd = &cobra.Command{
@@ -62,6 +66,7 @@ var chatCmd = &cobra.Command{
 	Short: "Chat with the AI",
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Printf("Begin the chat loop with the AI, ctrl+c or exit to quit\n")
+		fmt.Printf("Use /image <file> to attach a PNG or JPEG image to the next question\n")
 		Chat()
 	},
 }
@@ -97,6 +102,7 @@ func initConfig() {
 	}
 
 	fmt.Printf("\nRyaiConfig:%s", cfg)
+	ryaiCfg = cfg
 
 	loglevel = new(slog.LevelVar)
 	if err = loglevel.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
@@ -6,16 +6,23 @@ package cmd
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/superryanguo/ryai/docs"
+	"github.com/superryanguo/ryai/embeddocs"
 	"github.com/superryanguo/ryai/llm"
 	"github.com/superryanguo/ryai/llmapp"
 	"github.com/superryanguo/ryai/ollama"
+	_ "github.com/superryanguo/ryai/openai" // register the openai backend
+	"github.com/superryanguo/ryai/pebble"
 	"github.com/superryanguo/ryai/secret"
 	"github.com/superryanguo/ryai/storage"
 	//"github.com/superryanguo/ryai/utils"
@@ -48,77 +55,154 @@ func Run() {
 		addr:      "localhost:4229",
 	}
 
-	var osrv string
-	ai, err := ollama.NewClient(g.slog, g.http, osrv, ollama.DefaultEmbeddingModel)
+	g.secret = secret.Netrc()
+	b, err := newBackend(g.slog, g.http, g.secret)
 	if err != nil {
 		log.Fatal(err)
 	}
-	g.embed = ai
-	//g.llm = ai
-	//g.llmapp = llmapp.New(g.slog, ai, g.db)
-
-	var docs = []llm.EmbedDoc{
-		{Text: "for loops"},
-		{Text: "for all time, always"},
-	}
-
-	vecs, err := g.embed.EmbedDocs(g.ctx, docs)
+	g.db, err = openDB(g.slog)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("vecs:%v\n", vecs)
+	defer g.db.Close()
+	mws := []llm.Middleware{llm.Retry(llm.RetryPolicy{}), llm.Log(g.slog)}
+	// The cache is keyed by the embedder's model,
+	// which may be the backend's default.
+	g.embed = storage.CachedEmbedder(g.db, g.slog, "", llm.WrapEmbedder(b.Embedder, mws...))
+	g.llm = llm.WrapGenerator(b.Generator, mws...)
+	g.llmapp = llmapp.New(g.slog, g.llm, g.db)
+
+	g.docs = docs.New(g.slog, g.db)
+	g.vector = storage.MemVectorDB(g.db, g.slog, "")
+
 	input := "how about Donald Trump?"
-	gai, err := ollama.NewClient(g.slog, g.http, osrv, ollama.DefaultGenModel)
-	if err != nil {
-		log.Fatal(err)
-	}
-	rsp, err := gai.Prompt(g.ctx, input)
+	s, err := g.llm.GenerateContent(g.ctx, nil, []llm.Part{llm.Text(input)})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Printf("Gai get rsp: %s\n", string(rsp))
-	s, err := ollama.AssembleRsp(rsp)
-	if err != nil {
+	fmt.Printf("Gai get rsp: %s\n", s)
 
+	//utils.ShowJsonRsp(rsp)
+
+	for {
+		if err := embeddocs.Sync(g.ctx, g.slog, g.vector, g.embed, g.docs); err != nil {
+			g.slog.Error("embeddocs.Sync", "err", err)
+		}
+		time.Sleep(syncInterval)
 	}
+}
 
-	fmt.Printf("AssembleRsp: %s\n", s)
+// syncInterval is how often Run embeds new documents in the corpus.
+const syncInterval = time.Minute
 
-	//utils.ShowJsonRsp(rsp)
+// openDB opens the database configured by Db.dir, creating it if needed.
+// With no directory configured, openDB returns an in-memory database.
+func openDB(lg *slog.Logger) (storage.DB, error) {
+	dir := ryaiCfg.Db.Dir
+	if dir == "" {
+		return storage.MemDB(), nil
+	}
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return pebble.Create(lg, dir)
+	}
+	return pebble.Open(lg, dir)
+}
 
-	select {}
+// newBackend returns the LLM backend selected by the configuration.
+// With the --pull flag, Ollama models missing from the server
+// are pulled on first use.
+func newBackend(lg *slog.Logger, hc *http.Client, sdb secret.DB) (*llm.Backend, error) {
+	bc := ryaiCfg.Llm.Backend()
+	lg.Info("LLM backend", "name", ryaiCfg.Llm.Name, "type", bc.Type, "server", bc.Server,
+		"embedmodel", bc.EmbedModel, "genmodel", bc.GenModel)
+	b, err := llm.NewBackend(lg, bc.Type, &llm.BackendConfig{
+		Server:     bc.Server,
+		EmbedModel: bc.EmbedModel,
+		GenModel:   bc.GenModel,
+		Options:    bc.Options,
+		HTTP:       hc,
+		Secret:     sdb,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if autoPull {
+		for _, x := range []any{b.Embedder, b.Generator} {
+			if c, ok := x.(*ollama.Client); ok {
+				c.AutoPull(progressBar(os.Stderr))
+			}
+		}
+	}
+	return b, nil
 }
 
 func Chat() {
+	b, err := newBackend(logger, http.DefaultClient, secret.Netrc())
+	if err != nil {
+		log.Fatal(err)
+	}
+	ai, ok := b.Generator.(llm.ChatGenerator)
+	if !ok {
+		log.Fatalf("model %s does not support chat", b.Generator.Model())
+	}
 	scanner := bufio.NewScanner(os.Stdin)
 
+	var history []llm.Message
+	var attached []llm.Part // images to attach to the next question
 	for scanner.Scan() {
 		t := scanner.Text()
 		if t == "exit" {
 			break
 		}
-		chat(t)
+		if file, ok := strings.CutPrefix(t, "/image "); ok {
+			img, err := readImage(strings.TrimSpace(file))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error attaching image: %v\n", err)
+				continue
+			}
+			attached = append(attached, img)
+			fmt.Printf("Attached %s (%s) to the next question\n", file, img.MIMEType)
+			continue
+		}
+		history = chat(ai, history, t, attached...)
+		attached = nil
 	}
 
 	if err := scanner.Err(); err != nil {
 		fmt.Fprintf(os.Stderr, "error reading input: %v\n", err)
 	}
 }
-func chat(input string) {
-	ctx := context.Background()
-	ai, err := ollama.NewClient(logger, http.DefaultClient, "", ollama.DefaultGenModel)
-	if err != nil {
-		log.Fatal(err)
-	}
-	rsp, err := ai.Prompt(ctx, input)
-	if err != nil {
-		log.Fatal(err)
-	}
 
-	s, err := ollama.AssembleRsp(rsp)
+// readImage reads the named image file into an [llm.Blob].
+func readImage(file string) (llm.Blob, error) {
+	data, err := os.ReadFile(file)
 	if err != nil {
+		return llm.Blob{}, err
 	}
+	return llm.Blob{MIMEType: http.DetectContentType(data), Data: data}, nil
+}
 
-	fmt.Printf("\033[32mAnswer: %s\033[0m\n", s)
+// chat asks g the question in input, along with any attached parts,
+// following the conversation so far in history, and streams the answer,
+// printing each piece of it as it arrives.
+// It returns the history updated with the question and answer.
+// If g fails to answer, the history is returned unchanged.
+func chat(g llm.ChatGenerator, history []llm.Message, input string, attached ...llm.Part) []llm.Message {
+	ctx := context.Background()
+	parts := append([]llm.Part{llm.Text(input)}, attached...)
+	msgs := append(history, llm.Message{Role: llm.RoleUser, Parts: parts})
+
+	fmt.Printf("\033[32mAnswer: ")
+	defer fmt.Printf("\033[0m\n")
+	var answer strings.Builder
+	for c, err := range g.StreamChat(ctx, nil, msgs) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nchat error: %v", err)
+			return history
+		}
+		fmt.Print(c.Text)
+		answer.WriteString(c.Text)
+	}
+	return append(msgs, llm.Message{Role: llm.RoleAssistant, Parts: []llm.Part{llm.Text(answer.String())}})
 }
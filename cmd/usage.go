@@ -0,0 +1,67 @@
+/*
+Copyright © 2024 superryanguo
+*/
+package cmd
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/superryanguo/ryai/llmapp"
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Report the LLM tokens and time used per model and task",
+	Long: `Report the LLM usage recorded in the configured database,
+one line per model and llmapp task, with the heaviest users first.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		if ryaiCfg.Db.Dir == "" {
+			return errors.New("no database configured (set Db.dir in the config file)")
+		}
+		db, err := openDB(logger)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		stats := llmapp.Usage(db)
+		slices.SortStableFunc(stats, func(x, y *llmapp.UsageStats) int {
+			return -cmp.Compare(x.InputTokens+x.OutputTokens, y.InputTokens+y.OutputTokens)
+		})
+		var total llmapp.UsageStats
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(w, "MODEL\tTASK\tCALLS\tCACHED\tIN TOKENS\tOUT TOKENS\tTIME\tAVG TIME\t\n")
+		for _, s := range stats {
+			printUsage(w, s)
+			total.Calls += s.Calls
+			total.CacheHits += s.CacheHits
+			total.Usage.Add(&s.Usage)
+		}
+		total.Model = "TOTAL"
+		printUsage(w, &total)
+		return w.Flush()
+	},
+}
+
+// printUsage prints one line of the usage report to w.
+func printUsage(w *tabwriter.Writer, s *llmapp.UsageStats) {
+	var avg time.Duration
+	if s.Calls > 0 {
+		avg = s.Latency / time.Duration(s.Calls)
+	}
+	task := s.Task
+	if task == "" {
+		task = "-"
+	}
+	fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t\n", s.Model, task, s.Calls, s.CacheHits,
+		s.InputTokens, s.OutputTokens, s.Latency.Round(time.Millisecond), avg.Round(time.Millisecond))
+}
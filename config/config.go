@@ -2,13 +2,28 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
 	"github.com/spf13/viper"
+	"github.com/superryanguo/ryai/llm"
 )
 
 type RyaiConfig struct {
 	Llm LlmSet `yaml:"Llm"`
 	Log LogSet `yaml:"Log"`
+	Db  DbSet  `yaml:"Db"`
+}
+
+// DbSet configures the database holding the LLM response cache,
+// the embedding cache and usage statistics.
+type DbSet struct {
+	Dir string `yaml:"dir"` // Pebble database directory; empty means an in-memory database
+}
+
+func (c DbSet) String() string {
+	return fmt.Sprintf("dir:%s;\n", c.Dir)
 }
 
 type LogSet struct {
@@ -19,9 +34,20 @@ type LogSet struct {
 	Age   string `yaml:"age"`
 }
 
+// LlmSet selects the LLM backend by Name among the configured Backends.
 type LlmSet struct {
-	Name string `yaml:"name"`
-	Mod  string `yaml:"mod"`
+	Name     string                `yaml:"name"`
+	Mod      string                `yaml:"mod"`
+	Backends map[string]BackendSet `yaml:"backends"`
+}
+
+// BackendSet configures one LLM backend.
+type BackendSet struct {
+	Type       string            `yaml:"type"`       // registered backend type, such as "ollama"; defaults to the entry name
+	Server     string            `yaml:"server"`     // server URL; empty means the backend's default
+	EmbedModel string            `yaml:"embedmodel"` // embedding model
+	GenModel   string            `yaml:"genmodel"`   // generative model
+	Options    map[string]string `yaml:"options"`    // backend-specific options, such as temperature
 }
 
 func (c LogSet) String() string {
@@ -29,11 +55,38 @@ func (c LogSet) String() string {
 }
 
 func (c LlmSet) String() string {
-	return fmt.Sprintf("name:%s,\nmod:%s;\n\n", c.Name, c.Mod)
+	var b strings.Builder
+	fmt.Fprintf(&b, "name:%s,\nmod:%s;\n", c.Name, c.Mod)
+	for _, name := range slices.Sorted(maps.Keys(c.Backends)) {
+		fmt.Fprintf(&b, "backend %s:%s", name, c.Backends[name])
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func (c BackendSet) String() string {
+	return fmt.Sprintf("type:%s,server:%s,embedmodel:%s,genmodel:%s,options:%v;\n", c.Type, c.Server, c.EmbedModel, c.GenModel, c.Options)
+}
+
+// Backend returns the configuration of the selected backend, c.Backends[c.Name],
+// with its Type defaulted to the entry name.
+// If there is no such entry, Backend returns a configuration using
+// the backend type c.Name with default settings.
+// An empty c.Name selects [llm.DefaultBackend].
+func (c LlmSet) Backend() BackendSet {
+	name := strings.ToLower(c.Name)
+	if name == "" {
+		name = llm.DefaultBackend
+	}
+	b := c.Backends[name]
+	if b.Type == "" {
+		b.Type = name
+	}
+	return b
 }
 
 func (c RyaiConfig) String() string {
-	return fmt.Sprintf("\nLlm:\n%sLog:\n%s\n", c.Llm.String(), c.Log.String())
+	return fmt.Sprintf("\nLlm:\n%sLog:\n%sDb:\n%s\n", c.Llm.String(), c.Log.String(), c.Db.String())
 }
 
 func ReadCfg() (cfg RyaiConfig, err error) {
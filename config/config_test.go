@@ -0,0 +1,45 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/superryanguo/ryai/llm"
+)
+
+func TestBackend(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		yaml string
+		want BackendSet
+	}{
+		{"no config", "", BackendSet{Type: llm.DefaultBackend}},
+		{"no Llm", "Log:\n  level: info\n", BackendSet{Type: llm.DefaultBackend}},
+		{
+			"no name",
+			"Llm:\n  backends:\n    ollama:\n      server: http://x:11434\n",
+			BackendSet{Type: llm.DefaultBackend, Server: "http://x:11434"},
+		},
+		{
+			"named",
+			"Llm:\n  name: Local\n  backends:\n    local:\n      type: openai\n      genmodel: m\n",
+			BackendSet{Type: "openai", GenModel: "m"},
+		},
+		{"unlisted", "Llm:\n  name: openai\n", BackendSet{Type: "openai"}},
+	} {
+		viper.Reset()
+		viper.SetConfigType("yaml")
+		if err := viper.ReadConfig(strings.NewReader(tt.yaml)); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		cfg, err := ReadCfg()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if have := cfg.Llm.Backend(); have.String() != tt.want.String() {
+			t.Errorf("%s: Backend() = %v, want %v", tt.name, have, tt.want)
+		}
+	}
+	viper.Reset()
+}
@@ -22,12 +22,16 @@ const docsKind = "docs.Doc"
 // This package stores the following key schemas in the database:
 //
 //	["docs.Doc", URL] => [DBTime, Title, Text]
+//	["docs.Doc", URL] => [DBTime] (deleted doc)
 //	["docs.DocByTime", DBTime, URL] => []
 //
 // DocByTime is an index of Docs by DBTime, which is the time when the
 // record was added to the database. Code that processes new docs can
 // record which DBTime it has most recently processed and then scan forward in
 // the index to learn about new docs.
+//
+// Deleting a doc leaves a record with no title or text,
+// so that code processing new docs also learns about deletions.
 
 // A Corpus is the collection of documents stored in a database.
 type Corpus struct {
@@ -46,6 +50,9 @@ type Doc struct {
 	ID     string       // document identifier (such as a URL)
 	Title  string       // title of document
 	Text   string       // text of document
+	// Deleted reports whether the document has been deleted.
+	// Deleted documents are only visible to a [Corpus.DocWatcher].
+	Deleted bool
 }
 
 // decodeDoc decodes the document in the timed key-value pair.
@@ -57,6 +64,10 @@ func (c *Corpus) decodeDoc(t *timed.Entry) *Doc {
 		// unreachable unless db corruption
 		c.db.Panic("docs decode", "key", storage.Fmt(t.Key), "err", err)
 	}
+	if len(t.Val) == 0 {
+		d.Deleted = true
+		return d
+	}
 	if err := ordered.Decode(t.Val, &d.Title, &d.Text); err != nil {
 		// unreachable unless db corruption
 		c.db.Panic("docs decode", "key", storage.Fmt(t.Key), "val", storage.Fmt(t.Val), "err", err)
@@ -72,7 +83,11 @@ func (c *Corpus) Get(id string) (doc *Doc, ok bool) {
 	if !ok {
 		return nil, false
 	}
-	return c.decodeDoc(t), true
+	d := c.decodeDoc(t)
+	if d.Deleted {
+		return nil, false
+	}
+	return d, true
 }
 
 // Add adds a document with the given id, title, and text.
@@ -90,14 +105,16 @@ func (c *Corpus) Add(id, title, text string) {
 }
 
 // Delete deletes a document with the given id.
-// If the document does not exist inthe corpus, Delete is a no-op.
+// If the document does not exist in the corpus, Delete is a no-op.
+// A [Corpus.DocWatcher] reports the deletion as a [Doc]
+// with Deleted set.
 func (c *Corpus) Delete(id string) {
 	doc, ok := c.Get(id)
 	if !ok {
 		return
 	}
 	b := c.db.Batch()
-	timed.Delete(c.db, b, docsKind, ordered.Encode(doc.ID))
+	timed.Set(c.db, b, docsKind, ordered.Encode(doc.ID), nil)
 	b.Apply()
 }
 
@@ -107,7 +124,7 @@ func (c *Corpus) Delete(id string) {
 func (c *Corpus) Docs(prefix string) iter.Seq[*Doc] {
 	return func(yield func(*Doc) bool) {
 		for t := range timed.Scan(c.db, docsKind, ordered.Encode(prefix), ordered.Encode(prefix+"\xff")) {
-			if !yield(c.decodeDoc(t)) {
+			if d := c.decodeDoc(t); !d.Deleted && !yield(d) {
 				return
 			}
 		}
@@ -131,7 +148,7 @@ func (c *Corpus) DocsAfter(dbtime timed.DBTime, prefix string) iter.Seq[*Doc] {
 	}
 	return func(yield func(*Doc) bool) {
 		for t := range timed.ScanAfter(c.slog, c.db, docsKind, dbtime, filter) {
-			if !yield(c.decodeDoc(t)) {
+			if d := c.decodeDoc(t); !d.Deleted && !yield(d) {
 				return
 			}
 		}
@@ -140,6 +157,8 @@ func (c *Corpus) DocsAfter(dbtime timed.DBTime, prefix string) iter.Seq[*Doc] {
 
 // DocWatcher returns a new [storage.Watcher] with the given name.
 // It picks up where any previous Watcher of the same name left off.
+// Unlike [Corpus.Docs] and [Corpus.DocsAfter], the Watcher reports
+// deleted documents, with Deleted set and no Title or Text.
 func (c *Corpus) DocWatcher(name string) *timed.Watcher[*Doc] {
 	return timed.NewWatcher(c.slog, c.db, name, docsKind, c.decodeDoc)
 }
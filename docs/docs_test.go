@@ -130,3 +130,48 @@ func TestCorpus(t *testing.T) {
 		t.Errorf("DocsAfter(0, id1) = %v, want %v", ids, want)
 	}
 }
+
+func TestDocWatcherDelete(t *testing.T) {
+	lg := testutil.Slogger(t)
+	db := storage.MemDB()
+
+	corpus := New(lg, db)
+	corpus.Add("id1", "Title1", "text1")
+	corpus.Add("id2", "Title2", "text2")
+	w := corpus.DocWatcher("test")
+	for d := range w.Recent() {
+		w.MarkOld(d.DBTime)
+	}
+
+	corpus.Delete("id1")
+	if d, ok := corpus.Get("id1"); ok {
+		t.Errorf("Get(id1) after Delete = %v, true", d)
+	}
+	var deleted []string
+	for d := range w.Recent() {
+		if !d.Deleted || d.Title != "" || d.Text != "" {
+			t.Errorf("DocWatcher after Delete returned %+v, want deleted doc", d)
+		}
+		deleted = append(deleted, d.ID)
+		w.MarkOld(d.DBTime)
+	}
+	if want := []string{"id1"}; !slices.Equal(deleted, want) {
+		t.Errorf("DocWatcher after Delete returned %v, want %v", deleted, want)
+	}
+	for d := range corpus.DocsAfter(0, "") {
+		if d.Deleted {
+			t.Errorf("DocsAfter returned deleted doc %s", d.ID)
+		}
+	}
+
+	// Adding the doc again makes it visible again.
+	corpus.Add("id1", "Title1", "text1")
+	if d, ok := corpus.Get("id1"); !ok || d.Deleted || d.Text != "text1" {
+		t.Errorf("Get(id1) after re-Add = %+v, %v", d, ok)
+	}
+	for d := range w.Recent() {
+		if d.ID != "id1" || d.Deleted {
+			t.Errorf("DocWatcher after re-Add returned %+v", d)
+		}
+	}
+}
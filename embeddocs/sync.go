@@ -0,0 +1,142 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package embeddocs keeps a vector database in sync
+// with the documents in a [docs.Corpus].
+package embeddocs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/superryanguo/ryai/docs"
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/storage"
+	"github.com/superryanguo/ryai/storage/timed"
+)
+
+// watcherName is the name of the [docs.Corpus.DocWatcher] used by Sync.
+const watcherName = "embed"
+
+// Batch limits: a batch is embedded and written once it holds
+// embedBatchSize documents or maxEmbedBatchBytes bytes of text.
+const (
+	embedBatchSize     = 100
+	maxEmbedBatchBytes = 4 << 20
+)
+
+// Sync embeds the documents in dc that are new or have changed
+// since the last call to Sync, using embed, and stores the vectors in vdb
+// under the document IDs. It deletes the vectors of documents
+// that have been deleted from dc, as well as of documents
+// with no title or text, which are not embedded.
+//
+// Sync processes documents in batches. Each batch is written to vdb
+// in a single [storage.VectorBatch], and vdb is flushed,
+// before the batch is marked old,
+// so that if Sync fails or the program crashes, the next call to Sync
+// resumes with the first batch that was not written,
+// without embedding earlier documents again.
+//
+// Sync returns an error if embedding fails or the vector database
+// rejects a vector, leaving the failed batch for the next call.
+func Sync(ctx context.Context, lg *slog.Logger, vdb storage.VectorDB, embed llm.Embedder, dc *docs.Corpus) error {
+	w := dc.DocWatcher(watcherName)
+	s := &syncer{slog: lg, vdb: vdb, embed: embed, w: w}
+	var err error
+	for d := range w.Recent() {
+		s.add(d)
+		if len(s.docs)+len(s.deleted) >= embedBatchSize || s.bytes >= maxEmbedBatchBytes {
+			if err = s.flush(ctx); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil || s.last == 0 {
+		return err
+	}
+
+	// The final batch is pending, but Recent has released the lock
+	// that MarkOld requires. Reacquire it by iterating again.
+	// If another Sync has processed the batch in the meantime,
+	// the iteration starts after it, and the batch is dropped
+	// rather than overwriting newer vectors.
+	for d := range w.Recent() {
+		if d.DBTime <= s.last {
+			err = s.flush(ctx)
+		}
+		break
+	}
+	return err
+}
+
+// Restart causes the next call to [Sync] to embed
+// every document in dc again.
+func Restart(dc *docs.Corpus) {
+	dc.DocWatcher(watcherName).Restart()
+}
+
+// A syncer holds the pending batch for [Sync].
+type syncer struct {
+	slog  *slog.Logger
+	vdb   storage.VectorDB
+	embed llm.Embedder
+	w     *timed.Watcher[*docs.Doc]
+
+	ids     []string       // IDs of docs to embed
+	docs    []llm.EmbedDoc // docs to embed
+	deleted []string       // IDs of docs to delete
+	bytes   int            // total size of docs
+	last    timed.DBTime   // DBTime of last doc in batch; 0 if batch empty
+}
+
+// add adds d to the pending batch.
+func (s *syncer) add(d *docs.Doc) {
+	s.slog.Debug("embeddocs.Sync", "doc", d.ID, "dbtime", d.DBTime, "deleted", d.Deleted)
+	if d.Deleted || d.Title == "" && d.Text == "" {
+		s.deleted = append(s.deleted, d.ID)
+	} else {
+		s.ids = append(s.ids, d.ID)
+		s.docs = append(s.docs, llm.EmbedDoc{Title: d.Title, Text: d.Text})
+		s.bytes += len(d.Title) + len(d.Text)
+	}
+	s.last = d.DBTime
+}
+
+// flush embeds, writes and flushes the pending batch
+// and then marks its documents old.
+// It must be called during an iteration over s.w.Recent.
+func (s *syncer) flush(ctx context.Context) error {
+	b := s.vdb.Batch()
+	if len(s.docs) > 0 {
+		vecs, err := s.embed.EmbedDocs(ctx, s.docs)
+		if err != nil {
+			return fmt.Errorf("embeddocs: %w", err)
+		}
+		if len(vecs) != len(s.docs) {
+			return fmt.Errorf("embeddocs: embedded %d docs, got %d vectors", len(s.docs), len(vecs))
+		}
+		for i, v := range vecs {
+			if err := b.Set(s.ids[i], v); err != nil {
+				return fmt.Errorf("embeddocs: %s: %w", s.ids[i], err)
+			}
+		}
+	}
+	for _, id := range s.deleted {
+		b.Delete(id)
+	}
+	b.Apply()
+	// Flush the vectors before marking the batch old: vdb may be backed
+	// by a different database than dc, and a crash must not
+	// keep the new watcher position but lose the vectors.
+	s.vdb.Flush()
+	s.w.MarkOld(s.last)
+	s.w.Flush()
+	s.slog.Info("embeddocs.Sync batch", "embedded", len(s.docs), "deleted", len(s.deleted), "dbtime", s.last)
+
+	s.ids, s.docs, s.deleted = nil, nil, nil
+	s.bytes, s.last = 0, 0
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package embeddocs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/superryanguo/ryai/docs"
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/storage"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+// A countEmbedder is a QuoteEmbedder that counts
+// the documents it embeds and fails after fail calls if fail > 0.
+type countEmbedder struct {
+	calls int
+	docs  int
+	fail  int
+}
+
+var errFail = errors.New("embed failed")
+
+func (e *countEmbedder) EmbedDocs(ctx context.Context, docs []llm.EmbedDoc) ([]llm.Vector, error) {
+	e.calls++
+	if e.fail > 0 && e.calls > e.fail {
+		return nil, errFail
+	}
+	e.docs += len(docs)
+	return llm.QuoteEmbedder().EmbedDocs(ctx, docs)
+}
+
+// A flushVectorDB is a VectorDB that counts calls to Flush.
+type flushVectorDB struct {
+	storage.VectorDB
+	flushes int
+}
+
+func (vdb *flushVectorDB) Flush() {
+	vdb.flushes++
+	vdb.VectorDB.Flush()
+}
+
+// check checks that vdb holds exactly the quoted text of each doc in dc.
+func check(t *testing.T, vdb storage.VectorDB, dc *docs.Corpus) {
+	t.Helper()
+	n := 0
+	for d := range dc.Docs("") {
+		n++
+		v, ok := vdb.Get(d.ID)
+		if !ok {
+			t.Errorf("missing vector for %s", d.ID)
+			continue
+		}
+		if text := llm.UnquoteVector(v); text != d.Text {
+			t.Errorf("vector for %s = %q, want %q", d.ID, text, d.Text)
+		}
+	}
+	m := 0
+	for range vdb.All() {
+		m++
+	}
+	if m != n {
+		t.Errorf("vector db has %d vectors, want %d", m, n)
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	lg := testutil.Slogger(t)
+	db := storage.MemDB()
+	dc := docs.New(lg, db)
+	vdb := &flushVectorDB{VectorDB: storage.MemVectorDB(db, lg, "")}
+	for i := range 250 {
+		dc.Add(fmt.Sprintf("doc%03d", i), "", fmt.Sprintf("text %d", i))
+	}
+
+	e := &countEmbedder{}
+	if err := Sync(ctx, lg, vdb, e, dc); err != nil {
+		t.Fatal(err)
+	}
+	if e.calls != 3 || e.docs != 250 {
+		t.Errorf("first Sync: %d calls embedding %d docs, want 3 calls, 250 docs", e.calls, e.docs)
+	}
+	if vdb.flushes != 3 {
+		t.Errorf("first Sync: %d vector db flushes, want 3", vdb.flushes)
+	}
+	check(t, vdb, dc)
+
+	// Only changed docs are embedded again.
+	e = &countEmbedder{}
+	dc.Add("doc001", "", "new text 1")
+	dc.Add("doc002", "", "text 2") // unchanged
+	dc.Add("new", "Title", "new text")
+	dc.Delete("doc003")
+	dc.Add("doc004", "", "")
+	if err := Sync(ctx, lg, vdb, e, dc); err != nil {
+		t.Fatal(err)
+	}
+	if e.calls != 1 || e.docs != 2 {
+		t.Errorf("second Sync: %d calls embedding %d docs, want 1 call, 2 docs", e.calls, e.docs)
+	}
+	if _, ok := vdb.Get("doc004"); ok {
+		t.Errorf("vector for empty doc004 not deleted")
+	}
+	dc.Delete("doc004")
+	check(t, vdb, dc)
+
+	// Nothing to do.
+	e = &countEmbedder{}
+	if err := Sync(ctx, lg, vdb, e, dc); err != nil || e.calls != 0 {
+		t.Errorf("third Sync: %d calls, %v, want 0 calls", e.calls, err)
+	}
+
+	// Restart embeds everything again.
+	Restart(dc)
+	if err := Sync(ctx, lg, vdb, e, dc); err != nil || e.docs != 249 {
+		t.Errorf("Sync after Restart: embedded %d docs, %v, want 249", e.docs, err)
+	}
+	check(t, vdb, dc)
+}
+
+// Test that a failed Sync keeps the batches written before the failure
+// and that the next Sync resumes after them.
+func TestSyncFail(t *testing.T) {
+	ctx := context.Background()
+	lg := testutil.Slogger(t)
+	db := storage.MemDB()
+	dc := docs.New(lg, db)
+	vdb := storage.MemVectorDB(db, lg, "")
+	for i := range 250 {
+		dc.Add(fmt.Sprintf("doc%03d", i), "", fmt.Sprintf("text %d", i))
+	}
+
+	e := &countEmbedder{fail: 1}
+	if err := Sync(ctx, lg, vdb, e, dc); !errors.Is(err, errFail) {
+		t.Fatalf("Sync = %v, want %v", err, errFail)
+	}
+	if _, ok := vdb.Get("doc099"); !ok {
+		t.Errorf("first batch not written")
+	}
+	if _, ok := vdb.Get("doc100"); ok {
+		t.Errorf("failed batch written")
+	}
+
+	// The final partial batch can fail too.
+	e = &countEmbedder{fail: 1}
+	if err := Sync(ctx, lg, vdb, e, dc); !errors.Is(err, errFail) {
+		t.Fatalf("Sync = %v, want %v", err, errFail)
+	}
+
+	e = &countEmbedder{}
+	if err := Sync(ctx, lg, vdb, e, dc); err != nil {
+		t.Fatal(err)
+	}
+	if e.docs != 50 {
+		t.Errorf("Sync after failures embedded %d docs, want 50", e.docs)
+	}
+	check(t, vdb, dc)
+
+	// A vector that the database rejects fails the batch.
+	vdb = storage.NewMemVectorDB(db, lg, "dim", &storage.VectorOptions{Dim: 3})
+	Restart(dc)
+	if err := Sync(ctx, lg, vdb, &countEmbedder{}, dc); err == nil {
+		t.Errorf("Sync with wrong dimension succeeded")
+	}
+}
@@ -0,0 +1,503 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package lexical implements a persistent full-text index
+// of the documents in a [docs.Corpus].
+//
+// An [Index] tokenizes each document's title and text into words
+// and stores the positions of each word in a [storage.DB].
+// [Index.Search] ranks documents by BM25 score and supports
+// quoted phrases and the boolean operators AND, OR, and NOT
+// (see [Index.Search] for the query syntax).
+//
+// Unlike embedding-based search, lexical search finds exact
+// identifiers and error codes such as ERR_TIMEOUT_42, which are
+// indexed as single words.
+package lexical
+
+import (
+	"cmp"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/superryanguo/ryai/docs"
+	"github.com/superryanguo/ryai/storage"
+	"github.com/superryanguo/ryai/storage/timed"
+	"rsc.io/ordered"
+)
+
+// This package stores the following key schemas in the database:
+//
+//	["lexical.Post", Name, Word, DocID] => Posting
+//	["lexical.Doc", Name, DocID] => JSON of docInfo
+//	["lexical.Stats", Name] => JSON of indexStats
+//
+// A Posting is the number of words in the document
+// followed by the positions of Word in the document,
+// each encoded as a uvarint delta from the previous position.
+// The docInfo records the distinct words in the document,
+// so that its postings can be removed when it changes.
+// The indexStats record the number of documents in the index
+// and their total length, needed for BM25 scoring.
+
+const (
+	postKind  = "lexical.Post"
+	docKind   = "lexical.Doc"
+	statsKind = "lexical.Stats"
+)
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// maxWord is the length in bytes of the longest word that is indexed.
+// Longer words, such as base64 data, are dropped.
+const maxWord = 100
+
+// An Index is a full-text index of the documents in a [docs.Corpus].
+type Index struct {
+	slog *slog.Logger
+	db   storage.DB
+	dc   *docs.Corpus
+	name string
+	w    *timed.Watcher[*docs.Doc]
+}
+
+type docInfo struct {
+	Len   int      // number of words in document
+	Words []string // distinct words in document
+}
+
+type indexStats struct {
+	Docs  int64 // number of documents
+	Words int64 // total number of words in all documents
+}
+
+// New returns a new Index of the documents in dc, stored in db.
+// Multiple indexes of the same corpus can be stored in a single
+// database by giving them different names.
+//
+// The index is initially empty or, if db already holds an index with
+// the given name, reflects the corpus as of the most recent [Index.Sync].
+func New(lg *slog.Logger, db storage.DB, dc *docs.Corpus, name string) *Index {
+	return &Index{
+		slog: lg,
+		db:   db,
+		dc:   dc,
+		name: name,
+		w:    dc.DocWatcher("lexical." + name),
+	}
+}
+
+// Sync adds the documents that are new or have changed in the corpus
+// since the last call to Sync, replacing any earlier versions in the index,
+// and removes documents that have been deleted.
+// Sync uses [docs.Corpus.DocWatcher] to save its position across calls,
+// so it is safe to call after a crash or restart.
+func (ix *Index) Sync() {
+	b := ix.db.Batch()
+	first := true
+	var st indexStats
+	for d := range ix.w.Recent() {
+		ix.slog.Debug("lexical.Sync", "doc", d.ID, "dbtime", d.DBTime)
+		if first {
+			st = ix.stats()
+			first = false
+		}
+		// Each document and the updated stats are applied atomically.
+		ix.update(b, d, &st)
+		b.Apply()
+		ix.w.MarkOld(d.DBTime)
+	}
+}
+
+// Restart causes the next call to [Index.Sync] to reindex
+// every document in the corpus.
+func (ix *Index) Restart() {
+	ix.w.Restart()
+}
+
+// update adds d to the batch b, replacing any earlier version,
+// or removes d if it has been deleted.
+// It also updates st and adds it to b.
+func (ix *Index) update(b storage.Batch, d *docs.Doc, st *indexStats) {
+	if old, ok := ix.docInfo(d.ID); ok {
+		for _, w := range old.Words {
+			b.Delete(ix.postKey(w, d.ID))
+		}
+		st.Docs--
+		st.Words -= int64(old.Len)
+	}
+	if d.Deleted {
+		b.Delete(ordered.Encode(docKind, ix.name, d.ID))
+		b.Set(ordered.Encode(statsKind, ix.name), storage.JSON(st))
+		return
+	}
+
+	// Separate the title and text by one position
+	// so that phrases do not match across them.
+	words := tokenize(nil, d.Title)
+	words = append(words, "")
+	words = tokenize(words, d.Text)
+
+	pos := make(map[string][]int)
+	info := docInfo{Len: len(words) - 1}
+	for i, w := range words {
+		if w == "" {
+			continue
+		}
+		if pos[w] == nil {
+			info.Words = append(info.Words, w)
+		}
+		pos[w] = append(pos[w], i)
+	}
+	for _, w := range info.Words {
+		b.Set(ix.postKey(w, d.ID), encodePosting(info.Len, pos[w]))
+	}
+	b.Set(ordered.Encode(docKind, ix.name, d.ID), storage.JSON(&info))
+	st.Docs++
+	st.Words += int64(info.Len)
+	b.Set(ordered.Encode(statsKind, ix.name), storage.JSON(st))
+}
+
+// stats returns the stored index statistics.
+func (ix *Index) stats() indexStats {
+	var st indexStats
+	if enc, ok := ix.db.Get(ordered.Encode(statsKind, ix.name)); ok {
+		if err := json.Unmarshal(enc, &st); err != nil {
+			// unreachable except db corruption
+			ix.db.Panic("lexical stats decode", "name", ix.name, "err", err)
+		}
+	}
+	return st
+}
+
+// docInfo returns the stored docInfo for the document with the given ID.
+func (ix *Index) docInfo(id string) (*docInfo, bool) {
+	enc, ok := ix.db.Get(ordered.Encode(docKind, ix.name, id))
+	if !ok {
+		return nil, false
+	}
+	info := new(docInfo)
+	if err := json.Unmarshal(enc, info); err != nil {
+		// unreachable except db corruption
+		ix.db.Panic("lexical doc decode", "id", id, "err", err)
+	}
+	return info, true
+}
+
+func (ix *Index) postKey(word, id string) []byte {
+	return ordered.Encode(postKind, ix.name, word, id)
+}
+
+// encodePosting returns the encoding of a posting
+// for a document of length n with the word at the given positions.
+func encodePosting(n int, pos []int) []byte {
+	enc := binary.AppendUvarint(nil, uint64(n))
+	last := 0
+	for _, p := range pos {
+		enc = binary.AppendUvarint(enc, uint64(p-last))
+		last = p
+	}
+	return enc
+}
+
+// decodePosting decodes a posting returned by encodePosting.
+func (ix *Index) decodePosting(enc []byte) (n int, pos []int) {
+	x, k := binary.Uvarint(enc)
+	if k <= 0 {
+		// unreachable except db corruption
+		ix.db.Panic("lexical posting decode", "enc", storage.Fmt(enc))
+	}
+	n = int(x)
+	enc = enc[k:]
+	last := 0
+	for len(enc) > 0 {
+		x, k := binary.Uvarint(enc)
+		if k <= 0 {
+			// unreachable except db corruption
+			ix.db.Panic("lexical posting decode", "enc", storage.Fmt(enc))
+		}
+		last += int(x)
+		pos = append(pos, last)
+		enc = enc[k:]
+	}
+	return n, pos
+}
+
+// tokenize appends the words in text to words and returns the result.
+// A word is a maximal sequence of letters, digits, and underscores,
+// so that identifiers like ERR_TIMEOUT_42 are a single word.
+// Words are case-folded to lower case.
+func tokenize(words []string, text string) []string {
+	for text != "" {
+		i := strings.IndexFunc(text, isWord)
+		if i < 0 {
+			break
+		}
+		text = text[i:]
+		j := strings.IndexFunc(text, func(r rune) bool { return !isWord(r) })
+		if j < 0 {
+			j = len(text)
+		}
+		if j <= maxWord {
+			words = append(words, strings.ToLower(text[:j]))
+		}
+		text = text[j:]
+	}
+	return words
+}
+
+func isWord(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// A Result is a single search result.
+type Result struct {
+	ID    string  // document ID
+	Score float64 // BM25 score; higher is better
+}
+
+// Search returns the IDs of the n documents that best match
+// the query, ordered by decreasing BM25 score.
+//
+// A query is a sequence of words, which must all appear in
+// a matching document. Words are matched ignoring case.
+// Quoting a sequence of words, as in "connection reset",
+// matches only documents where the words appear consecutively.
+// A single word containing punctuation, such as os.ReadFile,
+// is treated as a quoted phrase.
+// The operators AND, OR, and NOT (which must be written in upper case)
+// combine queries; AND binds more tightly than OR,
+// and AND is implied between adjacent terms.
+// Parentheses group terms.
+// For example, the query
+//
+//	timeout (ERR_TIMEOUT_42 OR "deadline exceeded") NOT retry
+//
+// matches documents that mention timeout, do not mention retry,
+// and either mention ERR_TIMEOUT_42 or contain the phrase
+// “deadline exceeded”.
+//
+// Search returns an error if the query is malformed.
+// Documents that have been deleted from the corpus
+// are omitted from the results, even before the next [Index.Sync].
+func (ix *Index) Search(query string, n int) ([]Result, error) {
+	q, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	return ix.search(q, n)
+}
+
+// SearchWords returns the n documents that best match
+// any of the words in text, ordered by decreasing BM25 score.
+// Unlike [Index.Search], SearchWords treats text as plain words,
+// not query syntax, making it suitable for natural-language questions.
+// If text contains no words, SearchWords returns no results.
+func (ix *Index) SearchWords(text string, n int) ([]Result, error) {
+	words := tokenize(nil, text)
+	slices.Sort(words)
+	words = slices.Compact(words)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	q := &node{op: opOr}
+	for _, w := range words {
+		q.args = append(q.args, &node{op: opPhrase, words: []string{w}})
+	}
+	return ix.search(q, n)
+}
+
+// search returns the n best results for the parsed query q.
+func (ix *Index) search(q *node, n int) ([]Result, error) {
+	s := &searcher{ix: ix, stats: ix.stats(), posts: make(map[string]map[string]*posting)}
+	scores, err := s.eval(q)
+	if err != nil {
+		return nil, err
+	}
+	var rs []Result
+	for id, score := range scores {
+		rs = append(rs, Result{id, score})
+	}
+	slices.SortFunc(rs, func(x, y Result) int {
+		if c := cmp.Compare(y.Score, x.Score); c != 0 {
+			return c
+		}
+		return strings.Compare(x.ID, y.ID)
+	})
+	var out []Result
+	for _, r := range rs {
+		if len(out) >= n {
+			break
+		}
+		if _, ok := ix.dc.Get(r.ID); ok {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// A posting is a decoded posting of a word in a document.
+type posting struct {
+	n   int   // length of document
+	pos []int // positions of word in document
+}
+
+// A searcher holds the state for evaluating a single query.
+type searcher struct {
+	ix    *Index
+	stats indexStats
+	posts map[string]map[string]*posting // word → doc ID → posting
+}
+
+// postings returns the postings of word, keyed by document ID.
+func (s *searcher) postings(word string) map[string]*posting {
+	if m, ok := s.posts[word]; ok {
+		return m
+	}
+	ix := s.ix
+	m := make(map[string]*posting)
+	start := ordered.Encode(postKind, ix.name, word)
+	end := ordered.Encode(postKind, ix.name, word, ordered.Inf)
+	for key, val := range ix.db.Scan(start, end) {
+		var id string
+		if _, err := ordered.DecodePrefix(key[len(start):], &id); err != nil {
+			// unreachable except db corruption
+			ix.db.Panic("lexical posting key decode", "key", storage.Fmt(key), "err", err)
+		}
+		p := new(posting)
+		p.n, p.pos = ix.decodePosting(val())
+		m[id] = p
+	}
+	s.posts[word] = m
+	return m
+}
+
+// eval returns the BM25 scores of the documents matching q.
+func (s *searcher) eval(q *node) (map[string]float64, error) {
+	switch q.op {
+	case opPhrase:
+		return s.phrase(q.words), nil
+
+	case opNot:
+		return nil, fmt.Errorf("lexical search: NOT must follow another term")
+
+	case opOr:
+		scores := make(map[string]float64)
+		for _, x := range q.args {
+			m, err := s.eval(x)
+			if err != nil {
+				return nil, err
+			}
+			for id, score := range m {
+				scores[id] += score
+			}
+		}
+		return scores, nil
+
+	case opAnd:
+		var scores map[string]float64
+		var not []map[string]float64
+		for _, x := range q.args {
+			if x.op == opNot {
+				m, err := s.eval(x.args[0])
+				if err != nil {
+					return nil, err
+				}
+				not = append(not, m)
+				continue
+			}
+			m, err := s.eval(x)
+			if err != nil {
+				return nil, err
+			}
+			if scores == nil {
+				scores = m
+				continue
+			}
+			for id, score := range scores {
+				if ms, ok := m[id]; ok {
+					scores[id] = score + ms
+				} else {
+					delete(scores, id)
+				}
+			}
+		}
+		if scores == nil {
+			return nil, fmt.Errorf("lexical search: NOT must follow another term")
+		}
+		for _, m := range not {
+			for id := range m {
+				delete(scores, id)
+			}
+		}
+		return scores, nil
+	}
+	panic("lexical: invalid query op")
+}
+
+// phrase returns the BM25 scores of the documents
+// containing the words consecutively,
+// treating each occurrence of the phrase as one occurrence of a term.
+func (s *searcher) phrase(words []string) map[string]float64 {
+	posts := make([]map[string]*posting, len(words))
+	for i, w := range words {
+		posts[i] = s.postings(w)
+	}
+	tf := make(map[string]int)
+	dl := make(map[string]int)
+Docs:
+	for id, p := range posts[0] {
+		if len(words) == 1 {
+			tf[id], dl[id] = len(p.pos), p.n
+			continue
+		}
+		rest := make([]*posting, len(words)-1)
+		for i := range rest {
+			if rest[i] = posts[i+1][id]; rest[i] == nil {
+				continue Docs
+			}
+		}
+		count := 0
+		for _, start := range p.pos {
+			ok := true
+			for i, r := range rest {
+				if _, found := slices.BinarySearch(r.pos, start+1+i); !found {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				count++
+			}
+		}
+		if count > 0 {
+			tf[id], dl[id] = count, p.n
+		}
+	}
+
+	st := s.stats
+	N := float64(max(st.Docs, int64(len(tf))))
+	avgdl := 1.0
+	if st.Docs > 0 {
+		avgdl = max(float64(st.Words)/float64(st.Docs), 1)
+	}
+	df := float64(len(tf))
+	idf := math.Log(1 + (N-df+0.5)/(df+0.5))
+	scores := make(map[string]float64, len(tf))
+	for id, f := range tf {
+		f := float64(f)
+		scores[id] = idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(dl[id])/avgdl))
+	}
+	return scores
+}
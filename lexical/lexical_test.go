@@ -0,0 +1,228 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lexical
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/superryanguo/ryai/docs"
+	"github.com/superryanguo/ryai/storage"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+var testDocs = []struct{ id, title, text string }{
+	{"timeout", "Request timeout", "The server returns ERR_TIMEOUT_42 when the deadline is exceeded. Retry with backoff."},
+	{"deadline", "Deadlines", "A context deadline exceeded error means the deadline passed before the call finished."},
+	{"files", "Reading files", "Use os.ReadFile to read a whole file. The read is not retried."},
+	{"network", "Network errors", "Connection reset by peer. Retry the request; a timeout is possible too."},
+	{"timeout2", "Timeout", "timeout timeout timeout"},
+}
+
+func newTestIndex(t *testing.T) (*Index, *docs.Corpus, storage.DB) {
+	lg := testutil.Slogger(t)
+	db := storage.MemDB()
+	dc := docs.New(lg, db)
+	for _, d := range testDocs {
+		dc.Add(d.id, d.title, d.text)
+	}
+	ix := New(lg, db, dc, "test")
+	ix.Sync()
+	return ix, dc, db
+}
+
+func ids(rs []Result) []string {
+	var ids []string
+	for _, r := range rs {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestSearch(t *testing.T) {
+	ix, _, _ := newTestIndex(t)
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"ERR_TIMEOUT_42", []string{"timeout"}},
+		{"err_timeout_42", []string{"timeout"}},
+		{"ERR_TIMEOUT", nil},
+		{"timeout", []string{"timeout2", "network", "timeout"}},
+		{"deadline", []string{"deadline", "timeout"}},
+		{`"deadline exceeded"`, []string{"deadline"}},
+		{`"exceeded deadline"`, nil},
+		{`"the deadline"`, []string{"deadline", "timeout"}},
+		{"os.ReadFile", []string{"files"}},
+		{"ReadFile os", []string{"files"}},
+		{"retry timeout", []string{"network", "timeout"}},
+		{"retry AND timeout", []string{"network", "timeout"}},
+		{"retry NOT timeout", nil},
+		{"deadline NOT ERR_TIMEOUT_42", []string{"deadline"}},
+		{"ERR_TIMEOUT_42 OR peer", []string{"network", "timeout"}},
+		{`timeout (ERR_TIMEOUT_42 OR "deadline exceeded") NOT peer`, []string{"timeout"}},
+		{"(reading OR connection) NOT (os OR reset)", nil},
+		{"file OR (context AND call)", []string{"deadline", "files"}},
+		// Phrases do not match across the title and text.
+		{`"deadlines a"`, nil},
+		{`"deadlines" context`, []string{"deadline"}},
+		{"missing", nil},
+	} {
+		rs, err := ix.Search(tt.query, 10)
+		if err != nil {
+			t.Errorf("Search(%q): %v", tt.query, err)
+			continue
+		}
+		if have := ids(rs); !slices.Equal(have, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, have, tt.want)
+		}
+		for i := 1; i < len(rs); i++ {
+			if rs[i].Score > rs[i-1].Score {
+				t.Errorf("Search(%q) results not sorted: %v", tt.query, rs)
+			}
+		}
+	}
+
+	rs, err := ix.Search("timeout", 2)
+	if have, want := ids(rs), []string{"timeout2", "network"}; err != nil || !slices.Equal(have, want) {
+		t.Errorf("Search(timeout, 2) = %v, %v, want %v", have, err, want)
+	}
+}
+
+func TestSearchWords(t *testing.T) {
+	ix, _, _ := newTestIndex(t)
+	for _, tt := range []struct {
+		text string
+		want []string
+	}{
+		{"What does ERR_TIMEOUT_42 mean?", []string{"timeout"}},
+		{"peer OR (backoff", []string{"network", "timeout"}},
+		{"NOT", []string{"files"}},
+		{"?!", nil},
+	} {
+		rs, err := ix.SearchWords(tt.text, 10)
+		if have := ids(rs); err != nil || !slices.Equal(have, tt.want) {
+			t.Errorf("SearchWords(%q) = %v, %v, want %v", tt.text, have, err, tt.want)
+		}
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	ix, _, _ := newTestIndex(t)
+	for _, query := range []string{
+		"",
+		"   ",
+		"+++",
+		"NOT timeout",
+		"timeout OR NOT retry",
+		"timeout AND",
+		"AND timeout",
+		"timeout OR",
+		"(timeout",
+		"timeout)",
+		`"timeout`,
+		"NOT",
+	} {
+		if rs, err := ix.Search(query, 10); err == nil {
+			t.Errorf("Search(%q) = %v, want error", query, rs)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	ix, dc, db := newTestIndex(t)
+	lg := testutil.Slogger(t)
+
+	// A second Index on the same database sees the stored index.
+	ix2 := New(lg, db, dc, "test")
+	rs, err := ix2.Search("ERR_TIMEOUT_42", 10)
+	if have, want := ids(rs), []string{"timeout"}; err != nil || !slices.Equal(have, want) {
+		t.Errorf("Search in reopened index = %v, %v, want %v", have, err, want)
+	}
+
+	// An index with a different name is separate.
+	other := New(lg, db, dc, "other")
+	if rs, err := other.Search("ERR_TIMEOUT_42", 10); err != nil || len(rs) != 0 {
+		t.Errorf("Search in unsynced index = %v, %v, want none", rs, err)
+	}
+
+	// Changed docs replace their earlier versions,
+	// and new docs are added.
+	dc.Add("timeout", "Request timeout", "The server now returns ERR_DEADLINE_7.")
+	dc.Add("new", "New", "ERR_TIMEOUT_42 moved here.")
+	ix.Sync()
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"ERR_TIMEOUT_42", []string{"new"}},
+		{"ERR_DEADLINE_7", []string{"timeout"}},
+		{"backoff", nil},
+	} {
+		rs, err := ix2.Search(tt.query, 10)
+		if have := ids(rs); err != nil || !slices.Equal(have, tt.want) {
+			t.Errorf("Search(%q) after update = %v, %v, want %v", tt.query, have, err, tt.want)
+		}
+	}
+	st := ix.stats()
+	if want := int64(len(testDocs) + 1); st.Docs != want {
+		t.Errorf("stats.Docs = %d, want %d", st.Docs, want)
+	}
+	words := 0
+	for d := range dc.Docs("") {
+		words += len(tokenize(tokenize(nil, d.Title), d.Text))
+	}
+	if st.Words != int64(words) {
+		t.Errorf("stats.Words = %d, want %d", st.Words, words)
+	}
+
+	// Deleted docs are omitted from results immediately
+	// and removed from the index by Sync.
+	dc.Delete("new")
+	if rs, err := ix.Search("ERR_TIMEOUT_42", 10); err != nil || len(rs) != 0 {
+		t.Errorf("Search after Delete = %v, %v, want none", rs, err)
+	}
+	ix.Sync()
+	if _, ok := db.Get(ix.postKey("err_timeout_42", "new")); ok {
+		t.Errorf("posting for deleted doc not removed")
+	}
+	st = ix.stats()
+	if want := int64(len(testDocs)); st.Docs != want {
+		t.Errorf("stats.Docs after Delete = %d, want %d", st.Docs, want)
+	}
+
+	// Restart reindexes from scratch without changing the results.
+	before, _ := ix.Search("timeout OR retry", 10)
+	ix.Restart()
+	ix.Sync()
+	after, _ := ix.Search("timeout OR retry", 10)
+	if !slices.Equal(before, after) {
+		t.Errorf("Search after Restart = %v, want %v", after, before)
+	}
+	if st2 := ix.stats(); st2 != st {
+		t.Errorf("stats after Restart = %+v, want %+v", st2, st)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	long := make([]byte, maxWord+1)
+	for i := range long {
+		long[i] = 'x'
+	}
+	for _, tt := range []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"Hello, World!", []string{"hello", "world"}},
+		{"ERR_TIMEOUT_42: os.ReadFile failed", []string{"err_timeout_42", "os", "readfile", "failed"}},
+		{"Grüße über-alles 12.5", []string{"grüße", "über", "alles", "12", "5"}},
+		{"a " + string(long) + " b", []string{"a", "b"}},
+	} {
+		if have := tokenize(nil, tt.text); !slices.Equal(have, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.text, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lexical
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+)
+
+// A node is a node in a parsed query.
+type node struct {
+	op    op
+	words []string // for opPhrase
+	args  []*node  // for opAnd, opOr, opNot
+}
+
+type op int
+
+const (
+	opPhrase op = iota // words appear consecutively
+	opAnd              // all args match
+	opOr               // any arg matches
+	opNot              // args[0] does not match
+)
+
+func (n *node) String() string {
+	switch n.op {
+	case opPhrase:
+		return fmt.Sprintf("%q", strings.Join(n.words, " "))
+	case opNot:
+		return "NOT " + n.args[0].String()
+	}
+	sep := " AND "
+	if n.op == opOr {
+		sep = " OR "
+	}
+	var args []string
+	for _, a := range n.args {
+		args = append(args, a.String())
+	}
+	return "(" + strings.Join(args, sep) + ")"
+}
+
+// A parser holds the state for parsing a query.
+type parser struct {
+	query string
+	toks  []string
+}
+
+// parse parses the query, using the syntax described in [Index.Search].
+//
+// The grammar is:
+//
+//	or     = and { "OR" and }
+//	and    = unary { [ "AND" ] unary }
+//	unary  = "NOT" unary | "(" or ")" | phrase | word
+func parse(query string) (*node, error) {
+	p := &parser{query: query}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	if len(p.toks) == 0 {
+		return nil, p.errorf("empty query")
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if len(p.toks) > 0 {
+		return nil, p.errorf("unexpected %s", p.toks[0])
+	}
+	return n, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("lexical search: parsing %q: %s", p.query, fmt.Sprintf(format, args...))
+}
+
+// lex splits p.query into tokens: parentheses,
+// quoted phrases (with their quotes), and unquoted words.
+func (p *parser) lex() error {
+	s := p.query
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" {
+			return nil
+		}
+		switch s[0] {
+		case '(', ')':
+			p.toks = append(p.toks, s[:1])
+			s = s[1:]
+			continue
+		case '"':
+			i := strings.IndexByte(s[1:], '"')
+			if i < 0 {
+				return p.errorf("unterminated quoted phrase")
+			}
+			p.toks = append(p.toks, s[:i+2])
+			s = s[i+2:]
+			continue
+		}
+		i := strings.IndexAny(s, " \t\r\n()\"")
+		if i < 0 {
+			i = len(s)
+		}
+		p.toks = append(p.toks, s[:i])
+		s = s[i:]
+	}
+}
+
+func (p *parser) peek() string {
+	if len(p.toks) == 0 {
+		return ""
+	}
+	return p.toks[0]
+}
+
+func (p *parser) next() string {
+	t := p.toks[0]
+	p.toks = p.toks[1:]
+	return t
+}
+
+func (p *parser) or() (*node, error) {
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	args := []*node{n}
+	for p.peek() == "OR" {
+		p.next()
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, n)
+	}
+	return join(opOr, args), nil
+}
+
+func (p *parser) and() (*node, error) {
+	var args []*node
+	empty := false // saw terms with no indexed words
+	for {
+		switch p.peek() {
+		case "", ")", "OR":
+			if len(args) == 0 && empty {
+				return nil, p.errorf("no searchable words")
+			}
+			if len(args) == 0 {
+				return nil, p.errorf("missing term before %s", cmp.Or(p.peek(), "end of query"))
+			}
+			return join(opAnd, args), nil
+		case "AND":
+			if len(args) == 0 && !empty {
+				return nil, p.errorf("missing term before AND")
+			}
+			p.next()
+			if t := p.peek(); t == "" || t == ")" || t == "OR" || t == "AND" {
+				return nil, p.errorf("missing term after AND")
+			}
+		}
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if n == nil {
+			empty = true
+			continue
+		}
+		args = append(args, n)
+	}
+}
+
+// unary parses a single term, returning nil
+// for a word or phrase that contains no indexed words.
+func (p *parser) unary() (*node, error) {
+	switch t := p.next(); {
+	case t == "NOT":
+		switch p.peek() {
+		case "", ")", "OR", "AND":
+			return nil, p.errorf("missing term after NOT")
+		}
+		n, err := p.unary()
+		if err != nil || n == nil {
+			return nil, err
+		}
+		return &node{op: opNot, args: []*node{n}}, nil
+	case t == "(":
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, p.errorf("missing )")
+		}
+		p.next()
+		return n, nil
+	case t == ")":
+		return nil, p.errorf("unexpected )")
+	default:
+		// Quoted phrase or bare word.
+		words := tokenize(nil, strings.Trim(t, `"`))
+		if len(words) == 0 {
+			return nil, nil
+		}
+		return &node{op: opPhrase, words: words}, nil
+	}
+}
+
+// join returns a node applying op to args,
+// or the single arg itself if there is only one.
+func join(op op, args []*node) *node {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return &node{op: op, args: args}
+}
@@ -0,0 +1,38 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lexical
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"Timeout", `"timeout"`},
+		{"a b", `("a" AND "b")`},
+		{"a AND b", `("a" AND "b")`},
+		{"a OR b c", `("a" OR ("b" AND "c"))`},
+		{"a b OR c", `(("a" AND "b") OR "c")`},
+		{"a (b OR c)", `("a" AND ("b" OR "c"))`},
+		{`"Connection reset" by`, `("connection reset" AND "by")`},
+		{"os.ReadFile", `"os readfile"`},
+		{"a NOT b", `("a" AND NOT "b")`},
+		{"a NOT (b OR c)", `("a" AND NOT ("b" OR "c"))`},
+		{"and or not", `("and" AND "or" AND "not")`},
+		{"a +++ b", `("a" AND "b")`},
+		{"a AND --- b", `("a" AND "b")`},
+		{"x(y)", `("x" AND "y")`},
+	} {
+		n, err := parse(tt.query)
+		if err != nil {
+			t.Errorf("parse(%q): %v", tt.query, err)
+			continue
+		}
+		if have := n.String(); have != tt.want {
+			t.Errorf("parse(%q) = %s, want %s", tt.query, have, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/superryanguo/ryai/secret"
+)
+
+// A Backend is a configured connection to an LLM service,
+// created by [NewBackend].
+type Backend struct {
+	Embedder  Embedder         // embedder using the configured embedding model
+	Generator ContentGenerator // generator using the configured generative model
+}
+
+// A BackendConfig is the configuration of a [Backend].
+type BackendConfig struct {
+	Server     string            // URL of the server; empty means the backend's default
+	EmbedModel string            // embedding model; empty means the backend's default
+	GenModel   string            // generative model; empty means the backend's default
+	Options    map[string]string // backend-specific options, such as "temperature"
+	HTTP       *http.Client      // HTTP client to use; nil means http.DefaultClient
+	Secret     secret.DB         // secrets, such as API keys; nil means no secrets
+}
+
+// DefaultBackend is the name of the backend to use
+// when a configuration does not name one.
+// The Ollama backend registers itself under this name.
+const DefaultBackend = "ollama"
+
+// A NewBackendFunc creates a [Backend] from a configuration.
+// The configuration's HTTP and Secret fields are never nil.
+type NewBackendFunc func(lg *slog.Logger, cfg *BackendConfig) (*Backend, error)
+
+var backends struct {
+	sync.Mutex
+	m map[string]NewBackendFunc
+}
+
+// RegisterBackend registers the constructor for the backend with the given name,
+// making it available to [NewBackend].
+// Backend packages call RegisterBackend from an init function,
+// so importing a backend package (perhaps only for its side effects)
+// makes the backend available.
+// RegisterBackend panics if called twice with the same name.
+func RegisterBackend(name string, f NewBackendFunc) {
+	backends.Lock()
+	defer backends.Unlock()
+
+	if _, dup := backends.m[name]; dup {
+		panic("llm: RegisterBackend called twice for " + name)
+	}
+	if backends.m == nil {
+		backends.m = make(map[string]NewBackendFunc)
+	}
+	backends.m[name] = f
+}
+
+// Backends returns the sorted names of the registered backends.
+func Backends() []string {
+	backends.Lock()
+	defer backends.Unlock()
+
+	var names []string
+	for name := range backends.m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewBackend returns a new [Backend] created by the constructor
+// registered with the given name, using the configuration cfg.
+func NewBackend(lg *slog.Logger, name string, cfg *BackendConfig) (*Backend, error) {
+	backends.Lock()
+	f := backends.m[name]
+	backends.Unlock()
+
+	if f == nil {
+		return nil, fmt.Errorf("llm: unknown backend %q (registered: %v)", name, Backends())
+	}
+	c := *cfg
+	if c.HTTP == nil {
+		c.HTTP = http.DefaultClient
+	}
+	if c.Secret == nil {
+		c.Secret = secret.Empty()
+	}
+	return f(lg, &c)
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/superryanguo/ryai/testutil"
+)
+
+func TestBackend(t *testing.T) {
+	lg := testutil.Slogger(t)
+
+	var got *BackendConfig
+	RegisterBackend("test-echo", func(_ *slog.Logger, cfg *BackendConfig) (*Backend, error) {
+		got = cfg
+		return &Backend{Embedder: QuoteEmbedder(), Generator: EchoContentGenerator()}, nil
+	})
+	if !slices.Contains(Backends(), "test-echo") {
+		t.Errorf("Backends() = %v, missing test-echo", Backends())
+	}
+
+	b, err := NewBackend(lg, "test-echo", &BackendConfig{GenModel: "m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Generator.Model() != "echo" {
+		t.Errorf("NewBackend().Generator.Model() = %q, want echo", b.Generator.Model())
+	}
+	if got.GenModel != "m" || got.HTTP == nil || got.Secret == nil {
+		t.Errorf("backend constructor got config %+v, want GenModel=m and defaults", got)
+	}
+
+	if _, err := NewBackend(lg, "missing", &BackendConfig{}); err == nil {
+		t.Errorf("NewBackend(missing) succeeded, want error")
+	}
+
+	testutil.StopPanic(func() {
+		RegisterBackend("test-echo", nil)
+		t.Errorf("duplicate RegisterBackend did not panic")
+	})
+}
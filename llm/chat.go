@@ -0,0 +1,64 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"iter"
+	"strings"
+)
+
+// A Role identifies the author of a [Message] in a conversation.
+type Role string
+
+const (
+	// RoleSystem is the role of instructions that guide
+	// the model's behavior for the whole conversation.
+	RoleSystem Role = "system"
+	// RoleUser is the role of the person talking to the model.
+	RoleUser Role = "user"
+	// RoleAssistant is the role of the model itself.
+	RoleAssistant Role = "assistant"
+)
+
+// A Message is a single message in a conversation.
+type Message struct {
+	Role  Role
+	Parts []Part
+}
+
+// Text returns the concatenation of the [Text] parts of m,
+// ignoring any other parts.
+func (m *Message) Text() string {
+	var b strings.Builder
+	for _, p := range m.Parts {
+		if t, ok := p.(Text); ok {
+			b.WriteString(string(t))
+		}
+	}
+	return b.String()
+}
+
+// A ChatGenerator generates messages in a multi-turn conversation.
+// Unlike a [ContentGenerator], which only sees a single prompt,
+// a ChatGenerator sees the entire conversation so far,
+// so that it can answer follow-up questions.
+//
+// See [EchoChatGenerator] for a generator, useful for testing, that
+// always responds with a deterministic message derived from the conversation.
+type ChatGenerator interface {
+	// Model returns the name of the generative model
+	// used by this ChatGenerator.
+	Model() string
+	// GenerateChat generates the next message in the conversation msgs,
+	// which is ordinarily a message with role [RoleAssistant].
+	// If the JSON schema is non-nil, the message text is JSON
+	// matching the schema.
+	GenerateChat(ctx context.Context, schema *Schema, msgs []Message) (*Message, error)
+	// StreamChat is like GenerateChat but returns an iterator over
+	// pieces of the text of the next message as they are generated,
+	// with the same conventions as [ContentStreamer.StreamContent].
+	StreamChat(ctx context.Context, schema *Schema, msgs []Message) iter.Seq2[*Chunk, error]
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Errors reported by LLM backends, for use with [errors.Is].
+// Backends wrap them in an [*Error] giving the details.
+var (
+	// ErrModelNotFound means the model does not exist or is not available
+	// on the server (for example, it has not been pulled).
+	ErrModelNotFound = errors.New("model not found")
+	// ErrContextLength means the input is too long for the model's context.
+	ErrContextLength = errors.New("context length exceeded")
+	// ErrOverloaded means the server is busy or has rate limited the request.
+	// Requests failing with ErrOverloaded can be retried later; see [IsTransient].
+	ErrOverloaded = errors.New("server overloaded or rate limited")
+	// ErrInvalidSchema means the server rejected the response schema.
+	ErrInvalidSchema = errors.New("invalid schema")
+	// ErrCanceled means the request was canceled or its deadline passed
+	// before the server responded.
+	ErrCanceled = errors.New("request canceled")
+)
+
+// An Error is a failure reported by an LLM backend.
+//
+// An Error matches its Kind and its underlying Err with [errors.Is],
+// so callers can branch on the sentinel errors, such as
+// errors.Is(err, llm.ErrModelNotFound), or use [errors.As]
+// to obtain the details.
+type Error struct {
+	Backend string // backend name, such as "ollama"
+	Model   string // model name, if known
+	Status  int    // HTTP status code, or 0 if there was no HTTP response
+	Kind    error  // one of the sentinel errors above, or nil if unclassified
+	Message string // error message from the server, if any
+	Err     error  // underlying error, if any
+}
+
+func (e *Error) Error() string {
+	s := e.Backend
+	if e.Model != "" {
+		s += " " + e.Model
+	}
+	if e.Kind != nil {
+		s += ": " + e.Kind.Error()
+	}
+	if e.Status != 0 {
+		s += fmt.Sprintf(": status %d", e.Status)
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// Unwrap returns e.Kind and e.Err.
+func (e *Error) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// Transient reports whether the failed request can be retried:
+// the server was overloaded, failed with a 5xx status,
+// or a deadline (not a cancellation) cut the request short.
+// [IsTransient] and thus [Retry] consult this method.
+func (e *Error) Transient() bool {
+	switch {
+	case e.Kind == ErrOverloaded:
+		return true
+	case e.Kind == ErrCanceled:
+		return errors.Is(e.Err, context.DeadlineExceeded)
+	case e.Kind == nil:
+		return e.Status >= 500 || (e.Status == 0 && IsTransient(e.Err))
+	}
+	return false
+}
+
+// CanceledError returns an [*Error] of kind [ErrCanceled] for the
+// backend and model if ctx is done, or nil if it is not.
+// Backends call it after a failed request to report cancellations consistently.
+func CanceledError(ctx context.Context, backend, model string) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return &Error{Backend: backend, Model: model, Kind: ErrCanceled, Err: context.Cause(ctx)}
+}
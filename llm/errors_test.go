@@ -0,0 +1,59 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	for _, tc := range []struct {
+		err       *Error
+		str       string
+		is        []error
+		transient bool
+	}{
+		{&Error{Backend: "b", Model: "m", Status: 404, Kind: ErrModelNotFound, Message: "no such model"},
+			"b m: model not found: status 404: no such model", []error{ErrModelNotFound}, false},
+		{&Error{Backend: "b", Status: 503, Kind: ErrOverloaded},
+			"b: server overloaded or rate limited: status 503", []error{ErrOverloaded}, true},
+		{&Error{Backend: "b", Status: 500, Message: "oops"},
+			"b: status 500: oops", nil, true},
+		{&Error{Backend: "b", Status: 400, Kind: ErrContextLength},
+			"b: context length exceeded: status 400", []error{ErrContextLength}, false},
+		{&Error{Backend: "b", Kind: ErrCanceled, Err: context.Canceled},
+			"b: request canceled: context canceled", []error{ErrCanceled, context.Canceled}, false},
+		{&Error{Backend: "b", Kind: ErrCanceled, Err: context.DeadlineExceeded},
+			"b: request canceled: context deadline exceeded", []error{ErrCanceled, context.DeadlineExceeded}, true},
+		{&Error{Backend: "b", Err: io.ErrUnexpectedEOF},
+			"b: unexpected EOF", []error{io.ErrUnexpectedEOF}, true},
+	} {
+		if got := tc.err.Error(); got != tc.str {
+			t.Errorf("Error() = %q, want %q", got, tc.str)
+		}
+		for _, target := range tc.is {
+			if !errors.Is(tc.err, target) {
+				t.Errorf("errors.Is(%q, %v) = false, want true", tc.str, target)
+			}
+		}
+		if got := IsTransient(tc.err); got != tc.transient {
+			t.Errorf("IsTransient(%q) = %v, want %v", tc.str, got, tc.transient)
+		}
+	}
+}
+
+func TestCanceledError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := CanceledError(ctx, "b", "m"); err != nil {
+		t.Errorf("CanceledError(live ctx) = %v, want nil", err)
+	}
+	cancel()
+	if err := CanceledError(ctx, "b", "m"); !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("CanceledError(canceled ctx) = %v, want %v", err, ErrCanceled)
+	}
+}
@@ -5,6 +5,8 @@
 package llm
 
 import (
+	"math"
+	"math/rand/v2"
 	"slices"
 	"testing"
 )
@@ -16,6 +18,9 @@ func TestVector(t *testing.T) {
 	if dot != -46200 {
 		t.Errorf("%v.Dot(%v) = %v, want -46200", v1, v2, dot)
 	}
+	if dot := v1.Dot32(v2); dot != -46200 {
+		t.Errorf("%v.Dot32(%v) = %v, want -46200", v1, v2, dot)
+	}
 
 	enc := v1.Encode()
 	var v3 Vector
@@ -24,3 +29,33 @@ func TestVector(t *testing.T) {
 		t.Errorf("Decode(Encode(%v)) = %v, want %v", v1, v3, v1)
 	}
 }
+
+func TestDot32(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, n := range []int{0, 1, 7, 8, 9, 768, 1024, 1027} {
+		for range 10 {
+			v, w := randUnit(r, n), randUnit(r, n)
+			if d, d32 := v.Dot(w), v.Dot32(w); math.Abs(d-float64(d32)) > 1e-6 {
+				t.Errorf("n=%d: Dot = %v, Dot32 = %v", n, d, d32)
+			}
+			if d, d32 := v.Dot(w[:n/2]), v.Dot32(w[:n/2]); math.Abs(d-float64(d32)) > 1e-6 {
+				t.Errorf("n=%d: short Dot = %v, Dot32 = %v", n, d, d32)
+			}
+		}
+	}
+}
+
+func BenchmarkDot(b *testing.B) {
+	r := rand.New(rand.NewPCG(1, 2))
+	v, w := randUnit(r, 1024), randUnit(r, 1024)
+	b.Run("float64", func(b *testing.B) {
+		for range b.N {
+			v.Dot(w)
+		}
+	})
+	b.Run("float32", func(b *testing.B) {
+		for range b.N {
+			v.Dot32(w)
+		}
+	})
+}
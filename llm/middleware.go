@@ -0,0 +1,323 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// A Call describes a single call to a wrapped [Embedder] or
+// [ContentGenerator], for use by a [Middleware].
+type Call struct {
+	Op    string // "EmbedDocs" or "GenerateContent"
+	Model string // model name, if known
+	Size  int    // number of documents or prompt parts
+}
+
+// A Middleware runs a call to a wrapped [Embedder] or [ContentGenerator].
+// It must call next zero or more times to make the underlying call,
+// returning the resulting error, or an error of its own.
+// It may pass next a context derived from ctx.
+//
+// Use [WrapEmbedder] and [WrapGenerator] to apply middleware.
+type Middleware func(ctx context.Context, c *Call, next func(context.Context) error) error
+
+// WrapEmbedder returns an [Embedder] that runs each call to e.EmbedDocs
+// through the middleware. The first middleware is the outermost.
+//
+// If e.EmbedDocs returns a prefix of the vectors along with an error
+// and a middleware such as [Retry] calls next again, the retried call
+// embeds only the remaining documents.
+//
+// The result has a Model method returning the model of e,
+// if e has a Model method, or else "".
+func WrapEmbedder(e Embedder, mws ...Middleware) Embedder {
+	return &wrappedEmbedder{e: e, mw: chain(mws)}
+}
+
+type wrappedEmbedder struct {
+	e  Embedder
+	mw Middleware
+}
+
+// Model returns the model of the wrapped embedder,
+// or "" if it does not report one.
+func (w *wrappedEmbedder) Model() string {
+	if m, ok := w.e.(interface{ Model() string }); ok {
+		return m.Model()
+	}
+	return ""
+}
+
+// EmbedDocs implements [Embedder.EmbedDocs].
+func (w *wrappedEmbedder) EmbedDocs(ctx context.Context, docs []EmbedDoc) ([]Vector, error) {
+	c := &Call{Op: "EmbedDocs", Model: w.Model(), Size: len(docs)}
+	var vecs []Vector
+	err := w.mw(ctx, c, func(ctx context.Context) error {
+		v, err := w.e.EmbedDocs(ctx, docs[len(vecs):])
+		vecs = append(vecs, v...)
+		return err
+	})
+	return vecs, err
+}
+
+// WrapGenerator returns a [ContentGenerator] that runs each call to
+// g.GenerateContent through the middleware. The first middleware is the outermost.
+//
+// The result implements [ResponseGenerator], so that the model reported
+// by a wrapped [Router] is preserved, and passes through the
+// Models and DefaultOptions methods (see [Models] and [DefaultOptions]).
+// It does not implement [ContentStreamer]: [Stream] on the result
+// generates the whole response through the middleware and returns it as one chunk.
+func WrapGenerator(g ContentGenerator, mws ...Middleware) ContentGenerator {
+	return &wrappedGenerator{g: g, mw: chain(mws)}
+}
+
+type wrappedGenerator struct {
+	g  ContentGenerator
+	mw Middleware
+}
+
+// Model implements [ContentGenerator.Model].
+func (w *wrappedGenerator) Model() string { return w.g.Model() }
+
+// Models returns the models of the wrapped generator.
+func (w *wrappedGenerator) Models() []string { return Models(w.g) }
+
+// DefaultOptions returns the default options of the wrapped generator.
+func (w *wrappedGenerator) DefaultOptions(model string) *Options { return DefaultOptions(w.g, model) }
+
+// GenerateContent implements [ContentGenerator.GenerateContent].
+func (w *wrappedGenerator) GenerateContent(ctx context.Context, schema *Schema, parts []Part) (string, error) {
+	resp, err := w.GenerateResponse(ctx, schema, parts)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
+
+// GenerateResponse implements [ResponseGenerator.GenerateResponse].
+func (w *wrappedGenerator) GenerateResponse(ctx context.Context, schema *Schema, parts []Part) (*Response, error) {
+	c := &Call{Op: "GenerateContent", Model: w.g.Model(), Size: len(parts)}
+	var resp *Response
+	err := w.mw(ctx, c, func(ctx context.Context) error {
+		var err error
+		resp, err = Generate(ctx, w.g, schema, parts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// chain returns a single middleware running mws in order.
+func chain(mws []Middleware) Middleware {
+	return func(ctx context.Context, c *Call, next func(context.Context) error) error {
+		for i := len(mws) - 1; i >= 0; i-- {
+			mw, inner := mws[i], next
+			next = func(ctx context.Context) error { return mw(ctx, c, inner) }
+		}
+		return next(ctx)
+	}
+}
+
+// A RetryPolicy configures [Retry].
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first.
+	// If MaxAttempts is zero, Retry makes up to 3 attempts.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry.
+	// Each later retry doubles the delay, up to MaxBackoff.
+	// The actual delay is chosen at random between half
+	// the computed delay and the full delay.
+	// If MinBackoff is zero, it defaults to 1 second.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum delay between attempts.
+	// If MaxBackoff is zero, it defaults to 30 seconds.
+	MaxBackoff time.Duration
+	// Transient reports whether a failed call can be retried.
+	// If Transient is nil, Retry uses [IsTransient].
+	Transient func(error) bool
+}
+
+// Retry returns a [Middleware] that retries calls that fail with
+// transient errors, with exponential backoff between attempts.
+// Retry stops early if ctx is done.
+func Retry(p RetryPolicy) Middleware {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.MinBackoff == 0 {
+		p.MinBackoff = 1 * time.Second
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 30 * time.Second
+	}
+	if p.Transient == nil {
+		p.Transient = IsTransient
+	}
+	return func(ctx context.Context, c *Call, next func(context.Context) error) error {
+		delay := p.MinBackoff
+		for attempt := 1; ; attempt++ {
+			err := next(ctx)
+			if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil || !p.Transient(err) {
+				return err
+			}
+			d := delay/2 + rand.N(delay/2+1)
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return err
+			}
+			delay = min(2*delay, p.MaxBackoff)
+		}
+	}
+}
+
+// IsTransient reports whether err is likely to be a temporary failure,
+// so that the call that failed is worth retrying.
+// Transient errors include [ErrOverloaded], network timeouts, refused or
+// reset connections, truncated responses, deadlines set by [Timeout] for a
+// single call, and errors with a Temporary or Transient method that returns true,
+// such as an [*Error] with a 5xx status.
+// Cancellation is never transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrOverloaded) {
+		return true
+	}
+	var t interface{ Transient() bool }
+	if errors.As(err, &t) {
+		return t.Transient()
+	}
+	var tmp interface{ Temporary() bool }
+	if errors.As(err, &tmp) && tmp.Temporary() {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// Timeout returns a [Middleware] that limits each call to the duration d.
+// Placed inside [Retry], it limits each attempt; outside, it limits all attempts together.
+func Timeout(d time.Duration) Middleware {
+	return func(ctx context.Context, c *Call, next func(context.Context) error) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return next(ctx)
+	}
+}
+
+// RateLimit returns a [Middleware] that limits calls to an average of
+// perSecond calls per second, with bursts of up to burst calls,
+// using a token bucket shared by all calls through the middleware.
+// A call waiting for a token fails if ctx is done first.
+func RateLimit(perSecond float64, burst int) Middleware {
+	if perSecond <= 0 || burst <= 0 {
+		panic(fmt.Sprintf("llm.RateLimit: invalid rate %v, burst %d", perSecond, burst))
+	}
+	b := &bucket{rate: perSecond, burst: float64(burst), tokens: float64(burst)}
+	return func(ctx context.Context, c *Call, next func(context.Context) error) error {
+		if err := b.wait(ctx); err != nil {
+			return err
+		}
+		return next(ctx)
+	}
+}
+
+// A bucket is a token bucket.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64 // maximum tokens
+	tokens float64 // current tokens, negative if reserved by waiters
+	last   time.Time
+}
+
+// wait takes a token from the bucket, waiting until one is available.
+func (b *bucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens--
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// Return the reserved token.
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// MaxConcurrent returns a [Middleware] that allows at most n calls
+// through the middleware at once. A call waiting for its turn fails
+// if ctx is done first.
+func MaxConcurrent(n int) Middleware {
+	if n <= 0 {
+		panic(fmt.Sprintf("llm.MaxConcurrent: invalid limit %d", n))
+	}
+	sem := make(chan struct{}, n)
+	return func(ctx context.Context, c *Call, next func(context.Context) error) error {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-sem }()
+		return next(ctx)
+	}
+}
+
+// Log returns a [Middleware] that logs each call to lg,
+// along with its duration and error, if any.
+// Placed inside [Retry], it logs each attempt.
+func Log(lg *slog.Logger) Middleware {
+	return func(ctx context.Context, c *Call, next func(context.Context) error) error {
+		start := time.Now()
+		err := next(ctx)
+		attrs := []any{"op", c.Op, "model", c.Model, "size", c.Size, "duration", time.Since(start)}
+		if err != nil {
+			lg.Warn("llm call failed", append(attrs, "err", err)...)
+		} else {
+			lg.Debug("llm call", attrs...)
+		}
+		return err
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+// flakyGenerator returns a generator that fails with err
+// on its first fails calls, and counts its calls in *calls.
+func flakyGenerator(fails int, err error, calls *int) ContentGenerator {
+	return TestContentGenerator("flaky", func(ctx context.Context, schema *Schema, parts []Part) (string, error) {
+		*calls++
+		if *calls <= fails {
+			return "", err
+		}
+		return EchoContentGenerator().GenerateContent(ctx, schema, parts)
+	})
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	prompt := []Part{Text("hi")}
+
+	for _, tc := range []struct {
+		name      string
+		fails     int
+		err       error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"ok", 0, nil, 1, false},
+		{"transient", 2, io.ErrUnexpectedEOF, 3, false},
+		{"too many", 5, io.ErrUnexpectedEOF, 3, true},
+		{"permanent", 1, errors.New("bad request"), 1, true},
+		{"canceled", 1, context.Canceled, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			g := WrapGenerator(flakyGenerator(tc.fails, tc.err, &calls), Retry(fastRetry))
+			out, err := g.GenerateContent(ctx, nil, prompt)
+			if calls != tc.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tc.wantCalls)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("GenerateContent() err = %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && out != EchoTextResponse(prompt...) {
+				t.Errorf("GenerateContent() = %q, want echo", out)
+			}
+		})
+	}
+}
+
+// prefixEmbedder embeds at most n documents per call, returning a
+// transient error if there are more, and records the size of each call.
+type prefixEmbedder struct {
+	n     int
+	sizes []int
+}
+
+func (e *prefixEmbedder) EmbedDocs(ctx context.Context, docs []EmbedDoc) ([]Vector, error) {
+	e.sizes = append(e.sizes, len(docs))
+	vecs, _ := QuoteEmbedder().EmbedDocs(ctx, docs[:min(e.n, len(docs))])
+	if len(docs) > e.n {
+		return vecs, io.ErrUnexpectedEOF
+	}
+	return vecs, nil
+}
+
+func TestRetryEmbedderResumes(t *testing.T) {
+	e := &prefixEmbedder{n: 2}
+	var docs []EmbedDoc
+	for i := range 5 {
+		docs = append(docs, EmbedDoc{Text: fmt.Sprint("doc", i)})
+	}
+	vecs, err := WrapEmbedder(e, Retry(fastRetry)).EmbedDocs(context.Background(), docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(e.sizes) != "[5 3 1]" {
+		t.Errorf("call sizes = %v, want [5 3 1]", e.sizes)
+	}
+	if len(vecs) != len(docs) {
+		t.Fatalf("got %d vectors, want %d", len(vecs), len(docs))
+	}
+	for i, v := range vecs {
+		if got := UnquoteVector(v); got != docs[i].Text {
+			t.Errorf("vecs[%d] = %q, want %q", i, got, docs[i].Text)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	calls := 0
+	slow := TestContentGenerator("slow", func(ctx context.Context, _ *Schema, _ []Part) (string, error) {
+		calls++
+		if calls == 1 {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "ok", nil
+	})
+
+	// Timeout alone fails.
+	_, err := WrapGenerator(slow, Timeout(time.Millisecond)).GenerateContent(context.Background(), nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GenerateContent() err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// Timeout inside Retry retries the timed-out attempt.
+	calls = 0
+	out, err := WrapGenerator(slow, Retry(fastRetry), Timeout(time.Millisecond)).GenerateContent(context.Background(), nil, nil)
+	if err != nil || out != "ok" || calls != 2 {
+		t.Errorf("GenerateContent() = %q, %v after %d calls, want ok after 2 calls", out, err, calls)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	g := WrapGenerator(EchoContentGenerator(), RateLimit(100, 2))
+	ctx := context.Background()
+	start := time.Now()
+	for range 4 {
+		if _, err := g.GenerateContent(ctx, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 2 calls burst immediately; the next 2 wait 10ms each.
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Errorf("4 calls took %v, want at least 15ms", d)
+	}
+
+	// A canceled context stops the wait.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	slowly := WrapGenerator(EchoContentGenerator(), RateLimit(0.001, 1))
+	slowly.GenerateContent(ctx, nil, nil) // uses the burst token
+	if _, err := slowly.GenerateContent(ctx, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateContent() err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	var cur, peak atomic.Int32
+	g := TestContentGenerator("count", func(context.Context, *Schema, []Part) (string, error) {
+		n := cur.Add(1)
+		defer cur.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		return "", nil
+	})
+	w := WrapGenerator(g, MaxConcurrent(3))
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.GenerateContent(context.Background(), nil, nil)
+		}()
+	}
+	wg.Wait()
+	if p := peak.Load(); p > 3 || p == 0 {
+		t.Errorf("peak concurrency = %d, want 1..3", p)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	lg := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	calls := 0
+	g := WrapGenerator(flakyGenerator(1, io.ErrUnexpectedEOF, &calls), Retry(fastRetry), Log(lg))
+	if _, err := g.GenerateContent(context.Background(), nil, []Part{Text("x")}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`level=WARN msg="llm call failed" op=GenerateContent model=flaky size=1`,
+		`level=DEBUG msg="llm call" op=GenerateContent model=flaky size=1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWrapRouter(t *testing.T) {
+	r := Router(slog.Default(), "router", Route{Generators: []ContentGenerator{EchoContentGenerator()}})
+	g := WrapGenerator(r, Timeout(time.Minute))
+	resp, err := Generate(context.Background(), g, nil, []Part{Text("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Model != "echo" {
+		t.Errorf("Generate() model = %q, want echo", resp.Model)
+	}
+	if got := Models(g); len(got) != 1 || got[0] != "echo" {
+		t.Errorf("Models() = %q, want [echo]", got)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options are generation options for a single request to a [ContentGenerator].
+// Unset (nil or zero) fields leave the choice to the backend or model default.
+// Backends ignore options they do not support.
+//
+// Options travel with the request's context: see [WithOptions].
+type Options struct {
+	Temperature *float32       `json:",omitempty"` // sampling temperature
+	TopP        *float32       `json:",omitempty"` // nucleus sampling probability
+	Seed        *int           `json:",omitempty"` // random seed, for reproducible output
+	NumCtx      int            `json:",omitempty"` // context window size in tokens
+	Stop        []string       `json:",omitempty"` // stop sequences
+	KeepAlive   *time.Duration `json:",omitempty"` // how long the server should keep the model loaded; negative means forever
+}
+
+// Ptr returns a pointer to v,
+// for setting optional fields such as [Options.Temperature].
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Merge returns the options o overridden by the options set in p.
+// Either may be nil.
+func (o *Options) Merge(p *Options) *Options {
+	var m Options
+	if o != nil {
+		m = *o
+	}
+	if p == nil {
+		return &m
+	}
+	if p.Temperature != nil {
+		m.Temperature = p.Temperature
+	}
+	if p.TopP != nil {
+		m.TopP = p.TopP
+	}
+	if p.Seed != nil {
+		m.Seed = p.Seed
+	}
+	if p.NumCtx != 0 {
+		m.NumCtx = p.NumCtx
+	}
+	if p.Stop != nil {
+		m.Stop = p.Stop
+	}
+	if p.KeepAlive != nil {
+		m.KeepAlive = p.KeepAlive
+	}
+	return &m
+}
+
+type optionsKey struct{}
+
+// WithOptions returns a copy of ctx carrying the generation options opts,
+// merged over any options ctx already carries (see [Options.Merge]).
+// Generators apply the options to requests made with the returned context,
+// without affecting concurrent requests made with other contexts.
+func WithOptions(ctx context.Context, opts *Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, OptionsFromContext(ctx).Merge(opts))
+}
+
+// OptionsFromContext returns the generation options carried by ctx,
+// or nil if there are none.
+// The caller must not modify the result.
+func OptionsFromContext(ctx context.Context) *Options {
+	o, _ := ctx.Value(optionsKey{}).(*Options)
+	return o
+}
+
+// DefaultOptions returns the default generation options that g applies
+// to requests answered by model, before the options in the request's context,
+// or nil if there are none.
+// If g has a method DefaultOptions(model string) *Options, as the clients
+// configured by [NewBackend] and a [Router] do, DefaultOptions returns its result.
+// Otherwise, DefaultOptions returns nil.
+//
+// Callers caching responses should include the defaults in the cache key,
+// so that changing a configured default does not return stale responses.
+func DefaultOptions(g ContentGenerator, model string) *Options {
+	if d, ok := g.(interface{ DefaultOptions(string) *Options }); ok {
+		return d.DefaultOptions(model)
+	}
+	return nil
+}
+
+// ParseOptions parses generation options from string key-value pairs,
+// as found in a configuration file. The keys are
+// "temperature", "top_p", "seed", "num_ctx", "stop" (a comma-separated list)
+// and "keep_alive" (a duration such as "10m").
+func ParseOptions(kv map[string]string) (*Options, error) {
+	o := new(Options)
+	for k, v := range kv {
+		var err error
+		switch k {
+		case "temperature", "top_p":
+			var f float64
+			if f, err = strconv.ParseFloat(v, 32); err == nil {
+				if k == "temperature" {
+					o.Temperature = Ptr(float32(f))
+				} else {
+					o.TopP = Ptr(float32(f))
+				}
+			}
+		case "seed":
+			var n int
+			if n, err = strconv.Atoi(v); err == nil {
+				o.Seed = &n
+			}
+		case "num_ctx":
+			o.NumCtx, err = strconv.Atoi(v)
+		case "stop":
+			o.Stop = strings.Split(v, ",")
+		case "keep_alive":
+			var d time.Duration
+			if d, err = time.ParseDuration(v); err == nil {
+				o.KeepAlive = &d
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q", k)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad option %s=%q", k, v)
+		}
+	}
+	return o, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+func TestWithOptions(t *testing.T) {
+	ctx := context.Background()
+	if o := OptionsFromContext(ctx); o != nil {
+		t.Errorf("OptionsFromContext(Background) = %+v, want nil", o)
+	}
+
+	base := WithOptions(ctx, &Options{Temperature: Ptr[float32](0.5), NumCtx: 2048})
+	ctx1 := WithOptions(base, &Options{Temperature: Ptr[float32](0), Stop: []string{"END"}})
+	want := &Options{Temperature: Ptr[float32](0), NumCtx: 2048, Stop: []string{"END"}}
+	if diff := cmp.Diff(want, OptionsFromContext(ctx1)); diff != "" {
+		t.Errorf("merged options mismatch (-want +got):\n%s", diff)
+	}
+	// The outer context is unchanged.
+	want = &Options{Temperature: Ptr[float32](0.5), NumCtx: 2048}
+	if diff := cmp.Diff(want, OptionsFromContext(base)); diff != "" {
+		t.Errorf("base options mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	got, err := ParseOptions(map[string]string{
+		"temperature": "0.7",
+		"top_p":       "0.9",
+		"seed":        "42",
+		"num_ctx":     "8192",
+		"stop":        "</s>,END",
+		"keep_alive":  "10m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Options{
+		Temperature: Ptr[float32](0.7),
+		TopP:        Ptr[float32](0.9),
+		Seed:        Ptr(42),
+		NumCtx:      8192,
+		Stop:        []string{"</s>", "END"},
+		KeepAlive:   Ptr(10 * time.Minute),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseOptions() mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, kv := range []map[string]string{
+		{"color": "blue"},
+		{"temperature": "hot"},
+		{"keep_alive": "forever"},
+	} {
+		if _, err := ParseOptions(kv); err == nil {
+			t.Errorf("ParseOptions(%v) succeeded, want error", kv)
+		}
+	}
+}
+
+// A defaultsGen is a ContentGenerator with default options.
+type defaultsGen struct {
+	modelGen
+	opts *Options
+}
+
+func (g *defaultsGen) DefaultOptions(string) *Options { return g.opts }
+
+func TestDefaultOptions(t *testing.T) {
+	lg := testutil.Slogger(t)
+	a := &defaultsGen{modelGen{name: "a"}, &Options{Seed: Ptr(1)}}
+	b := &modelGen{name: "b"}
+	r := WrapGenerator(Router(lg, "router", Route{Name: "all", Generators: []ContentGenerator{a, b}}))
+	for _, tt := range []struct {
+		g     ContentGenerator
+		model string
+		want  *Options
+	}{
+		{a, "a", a.opts},
+		{b, "b", nil},
+		{r, "a", a.opts},
+		{r, "b", nil},
+		{r, "c", nil},
+	} {
+		if got := DefaultOptions(tt.g, tt.model); got != tt.want {
+			t.Errorf("DefaultOptions(%s, %s) = %v, want %v", tt.g.Model(), tt.model, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// randUnit returns a random unit vector of length n.
+func randUnit(r *rand.Rand, n int) Vector {
+	v := make(Vector, n)
+	d := 0.0
+	for i := range v {
+		v[i] = float32(r.NormFloat64())
+		d += float64(v[i]) * float64(v[i])
+	}
+	d = 1 / math.Sqrt(d)
+	for i := range v {
+		v[i] = float32(float64(v[i]) * d)
+	}
+	return v
+}
+
+func TestQuantize(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	const N = 768
+	for _, tt := range []struct {
+		p      Precision
+		size   int     // encoded size
+		maxErr float64 // maximum observed dot product error for random unit vectors
+	}{
+		{Float32, 4 * N, 1e-6},
+		{Int16, 4 + 2*N, 1e-4},
+		{Int8, 4 + N, 0.01},
+	} {
+		t.Run(tt.p.String(), func(t *testing.T) {
+			worst := 0.0
+			for range 100 {
+				v, w := randUnit(r, N), randUnit(r, N)
+				q := tt.p.Quantize(v)
+				if q.Precision() != tt.p || q.Len() != N {
+					t.Fatalf("Quantize: Precision, Len = %v, %d, want %v, %d", q.Precision(), q.Len(), tt.p, N)
+				}
+				enc := q.Encode()
+				if len(enc) != tt.size {
+					t.Fatalf("len(Encode()) = %d, want %d", len(enc), tt.size)
+				}
+				q2, err := tt.p.Decode(enc)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !slices.Equal(q2.Vector(), q.Vector()) {
+					t.Fatalf("Decode(Encode()) = %v, want %v", q2.Vector(), q.Vector())
+				}
+				if d, d2 := q.Dot(w), q.Vector().Dot(w); math.Abs(d-d2) > 1e-5 {
+					t.Fatalf("Dot = %v, but Vector().Dot = %v", d, d2)
+				}
+				worst = max(worst, math.Abs(q.Dot(w)-v.Dot(w)))
+			}
+			if worst > tt.maxErr {
+				t.Errorf("worst dot product error %v > %v", worst, tt.maxErr)
+			}
+			t.Logf("worst dot product error %v", worst)
+		})
+	}
+}
+
+func TestQuantizeEdges(t *testing.T) {
+	for _, p := range []Precision{Int16, Int8} {
+		q := p.Quantize(Vector{0, 0})
+		if v := q.Vector(); !slices.Equal(v, Vector{0, 0}) {
+			t.Errorf("%v: Quantize(zero).Vector() = %v", p, v)
+		}
+		v := Vector{2, -4, 1}
+		if got := p.Quantize(v).Vector(); got[1] != -4 || math.Abs(float64(got[0]-2)) > 0.05 {
+			t.Errorf("%v: Quantize(%v).Vector() = %v", p, v, got)
+		}
+		if _, err := p.Decode([]byte{1, 2, 3}); err == nil {
+			t.Errorf("%v: Decode(short) succeeded", p)
+		}
+	}
+	if _, err := Float32.Decode([]byte{1, 2, 3}); err == nil {
+		t.Errorf("Float32: Decode(short) succeeded")
+	}
+	if _, err := Precision(99).Decode(nil); err == nil {
+		t.Errorf("Decode with invalid precision succeeded")
+	}
+
+	for _, p := range []Precision{Float32, Int16, Int8} {
+		if q, err := ParsePrecision(p.String()); q != p || err != nil {
+			t.Errorf("ParsePrecision(%q) = %v, %v, want %v, nil", p.String(), q, err, p)
+		}
+	}
+	if p, err := ParsePrecision(""); p != Float32 || err != nil {
+		t.Errorf(`ParsePrecision("") = %v, %v, want float32, nil`, p, err)
+	}
+	if _, err := ParsePrecision("int4"); err == nil {
+		t.Errorf(`ParsePrecision("int4") succeeded`)
+	}
+}
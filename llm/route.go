@@ -0,0 +1,181 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// A Request describes a single request to a [Router],
+// for matching against the [Route] rules.
+type Request struct {
+	Schema *Schema // JSON schema of the response; nil for plain text
+	Parts  []Part  // prompt parts
+	Task   string  // task tag of the request's context (see [WithTask])
+}
+
+// Size returns the total size in bytes of the request's prompt parts.
+func (r *Request) Size() int {
+	n := 0
+	for _, p := range r.Parts {
+		switch p := p.(type) {
+		case Text:
+			n += len(p)
+		case Blob:
+			n += len(p.Data)
+		}
+	}
+	return n
+}
+
+// HasSchema matches requests with a JSON schema.
+func HasSchema(r *Request) bool {
+	return r.Schema != nil
+}
+
+// LargerThan returns a matcher for requests whose [Request.Size]
+// is larger than n bytes.
+func LargerThan(n int) func(*Request) bool {
+	return func(r *Request) bool { return r.Size() > n }
+}
+
+// ForTask returns a matcher for requests tagged with any of the tasks.
+func ForTask(tasks ...string) func(*Request) bool {
+	return func(r *Request) bool { return slices.Contains(tasks, r.Task) }
+}
+
+type taskKey struct{}
+
+// WithTask returns a copy of ctx tagged with the given task name,
+// such as "post_and_comments".
+// Generators like [Router] can use the task to decide how to handle
+// requests made with the context.
+func WithTask(ctx context.Context, task string) context.Context {
+	return context.WithValue(ctx, taskKey{}, task)
+}
+
+// Task returns the task name tagging ctx, or "" if ctx has no task.
+func Task(ctx context.Context) string {
+	t, _ := ctx.Value(taskKey{}).(string)
+	return t
+}
+
+// A Route is a routing rule for a [Router].
+type Route struct {
+	// Name identifies the route in logs and errors.
+	Name string
+	// Match reports whether the route handles the request.
+	// A nil Match matches every request.
+	Match func(*Request) bool
+	// Generators are tried in order until one succeeds.
+	Generators []ContentGenerator
+	// Timeout, if positive, limits how long to wait for each
+	// generator before failing over to the next.
+	Timeout time.Duration
+}
+
+// Router returns a [ResponseGenerator] with the given name that
+// sends each request to the first of the routes that matches it.
+// The route's generators are tried in order: if one fails or
+// times out, the request fails over to the next one.
+// The [Response] reports the model that actually responded.
+//
+// If no route matches, or all the generators of the matching route fail,
+// GenerateContent returns an error.
+// Failing over stops early if the caller's context is done.
+//
+// The returned generator's Models method returns the models of
+// all the routes' generators, for use with [Models],
+// and its DefaultOptions method returns the defaults of the
+// generator using a given model, for use with [DefaultOptions].
+func Router(lg *slog.Logger, name string, routes ...Route) ResponseGenerator {
+	return &router{slog: lg, name: name, routes: routes}
+}
+
+type router struct {
+	slog   *slog.Logger
+	name   string
+	routes []Route
+}
+
+// Model returns the router's name.
+func (r *router) Model() string { return r.name }
+
+// Models returns the distinct models of the routes' generators,
+// in the order they appear in the routes.
+func (r *router) Models() []string {
+	var models []string
+	for _, rt := range r.routes {
+		for _, g := range rt.Generators {
+			for _, m := range Models(g) {
+				if !slices.Contains(models, m) {
+					models = append(models, m)
+				}
+			}
+		}
+	}
+	return models
+}
+
+// DefaultOptions returns the default options of the first
+// of the routes' generators that uses model (see [DefaultOptions]).
+func (r *router) DefaultOptions(model string) *Options {
+	for _, rt := range r.routes {
+		for _, g := range rt.Generators {
+			if slices.Contains(Models(g), model) {
+				return DefaultOptions(g, model)
+			}
+		}
+	}
+	return nil
+}
+
+// GenerateContent implements [ContentGenerator.GenerateContent].
+func (r *router) GenerateContent(ctx context.Context, schema *Schema, parts []Part) (string, error) {
+	resp, err := r.GenerateResponse(ctx, schema, parts)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
+
+// GenerateResponse implements [ResponseGenerator.GenerateResponse].
+func (r *router) GenerateResponse(ctx context.Context, schema *Schema, parts []Part) (*Response, error) {
+	req := &Request{Schema: schema, Parts: parts, Task: Task(ctx)}
+	i := slices.IndexFunc(r.routes, func(rt Route) bool { return rt.Match == nil || rt.Match(req) })
+	if i < 0 {
+		return nil, fmt.Errorf("llm.Router %s: no route for request (task %q, %d bytes)", r.name, req.Task, req.Size())
+	}
+	rt := &r.routes[i]
+
+	var errs []error
+	for _, g := range rt.Generators {
+		resp, err := r.try(ctx, rt, g, schema, parts)
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", g.Model(), err))
+		if ctx.Err() != nil {
+			break
+		}
+		r.slog.Warn("llm.Router failover", "router", r.name, "route", rt.Name, "model", g.Model(), "err", err)
+	}
+	return nil, fmt.Errorf("llm.Router %s: route %s failed: %w", r.name, rt.Name, errors.Join(errs...))
+}
+
+// try generates a response with g, using the route's timeout.
+func (r *router) try(ctx context.Context, rt *Route, g ContentGenerator, schema *Schema, parts []Part) (*Response, error) {
+	if rt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rt.Timeout)
+		defer cancel()
+	}
+	return Generate(ctx, g, schema, parts)
+}
@@ -0,0 +1,137 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/superryanguo/ryai/testutil"
+)
+
+// modelGen is a ContentGenerator that answers with its own name,
+// after an optional delay, or fails with err.
+type modelGen struct {
+	name  string
+	delay time.Duration
+	err   error
+}
+
+func (g *modelGen) Model() string { return g.name }
+
+func (g *modelGen) GenerateContent(ctx context.Context, schema *Schema, parts []Part) (string, error) {
+	if g.delay > 0 {
+		select {
+		case <-time.After(g.delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	if g.err != nil {
+		return "", g.err
+	}
+	return g.name, nil
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	lg := testutil.Slogger(t)
+
+	big := &modelGen{name: "big"}
+	small := &modelGen{name: "small"}
+	json := &modelGen{name: "json"}
+	busy := &modelGen{name: "busy", err: errors.New("busy")}
+	slow := &modelGen{name: "slow", delay: time.Minute}
+
+	r := Router(lg, "router",
+		Route{Name: "schema", Match: HasSchema, Generators: []ContentGenerator{busy, json}},
+		Route{Name: "large", Match: LargerThan(10), Generators: []ContentGenerator{slow, big}, Timeout: 10 * time.Millisecond},
+		Route{Name: "summary", Match: ForTask("summary"), Generators: []ContentGenerator{small}},
+		Route{Name: "default", Generators: []ContentGenerator{big, small}},
+	)
+
+	if got, want := r.Model(), "router"; got != want {
+		t.Errorf("Model() = %q, want %q", got, want)
+	}
+	if got, want := Models(r), []string{"busy", "json", "slow", "big", "small"}; !slices.Equal(got, want) {
+		t.Errorf("Models() = %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		ctx    context.Context
+		schema *Schema
+		parts  []Part
+		want   string
+	}{
+		{"schema failover", ctx, &Schema{Type: TypeString}, []Part{Text("x")}, "json"},
+		{"timeout failover", ctx, nil, []Part{Text("a long prompt")}, "big"},
+		{"task", WithTask(ctx, "summary"), nil, []Part{Text("x")}, "small"},
+		{"default", WithTask(ctx, "other"), nil, []Part{Text("x")}, "big"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := Generate(tc.ctx, r, tc.schema, tc.parts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Model != tc.want || resp.Text != tc.want {
+				t.Errorf("Generate() = %+v, want model %q", resp, tc.want)
+			}
+		})
+	}
+}
+
+func TestRouterErrors(t *testing.T) {
+	ctx := context.Background()
+	lg := testutil.Slogger(t)
+
+	busy := &modelGen{name: "busy", err: errors.New("busy")}
+	down := &modelGen{name: "down", err: errors.New("down")}
+
+	t.Run("all fail", func(t *testing.T) {
+		r := Router(lg, "router", Route{Name: "all", Generators: []ContentGenerator{busy, down}})
+		_, err := r.GenerateContent(ctx, nil, []Part{Text("x")})
+		if err == nil || !strings.Contains(err.Error(), "busy: busy") || !strings.Contains(err.Error(), "down: down") {
+			t.Errorf("GenerateContent() err = %v, want both failures", err)
+		}
+	})
+
+	t.Run("no route", func(t *testing.T) {
+		r := Router(lg, "router", Route{Name: "schema", Match: HasSchema, Generators: []ContentGenerator{busy}})
+		_, err := r.GenerateContent(ctx, nil, []Part{Text("x")})
+		if err == nil || !strings.Contains(err.Error(), "no route") {
+			t.Errorf("GenerateContent() err = %v, want no route", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		slow := &modelGen{name: "slow", delay: time.Minute}
+		ok := &modelGen{name: "ok"}
+		r := Router(lg, "router", Route{Name: "all", Generators: []ContentGenerator{slow, ok}})
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err := r.GenerateContent(ctx, nil, []Part{Text("x")})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("GenerateContent() err = %v, want %v without failover", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestGenerate(t *testing.T) {
+	resp, err := Generate(context.Background(), EchoContentGenerator(), nil, []Part{Text("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Text != EchoTextResponse(Text("hi")) || resp.Model != "echo" {
+		t.Errorf("Generate() = %+v, want echo response from echo", resp)
+	}
+	if resp.Usage == nil || resp.Usage.Latency <= 0 {
+		t.Errorf("Generate() usage = %+v, want measured latency", resp.Usage)
+	}
+}
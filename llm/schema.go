@@ -60,3 +60,60 @@ const (
 	// TypeObject means object type.
 	TypeObject Type = 6
 )
+
+// jsonTypes maps each [Type] to its JSON Schema type name.
+var jsonTypes = [...]string{
+	TypeString:  "string",
+	TypeNumber:  "number",
+	TypeInteger: "integer",
+	TypeBoolean: "boolean",
+	TypeArray:   "array",
+	TypeObject:  "object",
+}
+
+// JSONSchema returns s converted to a [JSON Schema] object,
+// suitable for marshaling with [encoding/json].
+// Backends that accept a JSON Schema to constrain their output
+// (such as Ollama's "format" request field) can use JSONSchema
+// to translate the schema passed to [ContentGenerator.GenerateContent].
+//
+// A nil schema converts to nil.
+//
+// [JSON Schema]: https://json-schema.org/
+func (s *Schema) JSONSchema() map[string]any {
+	if s == nil {
+		return nil
+	}
+	js := make(map[string]any)
+	if s.Type > TypeUnspecified && int(s.Type) < len(jsonTypes) {
+		t := jsonTypes[s.Type]
+		if s.Nullable {
+			js["type"] = []string{t, "null"}
+		} else {
+			js["type"] = t
+		}
+	}
+	if s.Format != "" && s.Format != "enum" {
+		js["format"] = s.Format
+	}
+	if s.Description != "" {
+		js["description"] = s.Description
+	}
+	if len(s.Enum) > 0 {
+		js["enum"] = s.Enum
+	}
+	if s.Items != nil {
+		js["items"] = s.Items.JSONSchema()
+	}
+	if len(s.Properties) > 0 {
+		props := make(map[string]any)
+		for name, p := range s.Properties {
+			props[name] = p.JSONSchema()
+		}
+		js["properties"] = props
+	}
+	if len(s.Required) > 0 {
+		js["required"] = s.Required
+	}
+	return js
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	s := &Schema{
+		Type: TypeObject,
+		Properties: map[string]*Schema{
+			"name": {Type: TypeString, Description: "The name."},
+			"tags": {Type: TypeArray, Items: &Schema{Type: TypeString, Enum: []string{"A", "B"}, Format: "enum"}},
+			"size": {Type: TypeNumber, Format: "float", Nullable: true},
+		},
+		Required: []string{"name"},
+	}
+	js, err := json.Marshal(s.JSONSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"properties":{"name":{"description":"The name.","type":"string"},"size":{"format":"float","type":["number","null"]},"tags":{"items":{"enum":["A","B"],"type":"string"},"type":"array"}},"required":["name"],"type":"object"}`
+	if string(js) != want {
+		t.Errorf("JSONSchema() =\n%s\nwant\n%s", js, want)
+	}
+
+	var nilSchema *Schema
+	if js := nilSchema.JSONSchema(); js != nil {
+		t.Errorf("nil.JSONSchema() = %v, want nil", js)
+	}
+}
@@ -6,7 +6,9 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"iter"
 	"math"
 	"strings"
 )
@@ -85,6 +87,7 @@ func UnquoteVector(v Vector) string {
 // EchoContentGenerator returns an implementation
 // of [ContentGenerator] that responds to Generate calls
 // with responses trivially derived from the prompt.
+// The returned generator also implements [ContentStreamer].
 //
 // For testing.
 func EchoContentGenerator() ContentGenerator {
@@ -96,9 +99,6 @@ type echo struct{}
 // Implements [ContentGenerator.Model].
 func (echo) Model() string { return "echo" }
 
-// Implements [ContentGenerator.SetTemperature] as a no-op.
-func (echo) SetTemperature(float32) {}
-
 // GenerateContent echoes the prompts.
 // If the schema is non-nil, the output is wrapped as a JSON object with a
 // single value "prompt", ignoring the actual schema contents (for testing).
@@ -110,23 +110,121 @@ func (echo) GenerateContent(_ context.Context, schema *Schema, promptParts []Par
 	return EchoJSONResponse(promptParts...), nil
 }
 
+// StreamContent echoes the prompts, one chunk per prompt part,
+// followed by an empty final chunk.
+// If the schema is non-nil, the entire JSON-wrapped response is
+// sent in a single chunk.
+// Implements [ContentStreamer.StreamContent].
+func (echo) StreamContent(_ context.Context, schema *Schema, promptParts []Part) iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		if schema != nil {
+			if !yield(&Chunk{Text: EchoJSONResponse(promptParts...)}, nil) {
+				return
+			}
+		} else {
+			for i, p := range promptParts {
+				if !yield(&Chunk{Text: echoPart(i, p)}, nil) {
+					return
+				}
+			}
+		}
+		yield(&Chunk{Done: true, Model: "echo"}, nil)
+	}
+}
+
+// EchoChatGenerator returns an implementation
+// of [ChatGenerator] that responds to each conversation
+// with an assistant message echoing the parts
+// of the conversation's final message.
+//
+// For testing.
+func EchoChatGenerator() ChatGenerator {
+	return echo{}
+}
+
+// GenerateChat echoes the parts of the final message,
+// as GenerateContent would.
+// Implements [ChatGenerator.GenerateChat].
+func (e echo) GenerateChat(ctx context.Context, schema *Schema, msgs []Message) (*Message, error) {
+	text, err := e.GenerateContent(ctx, schema, lastParts(msgs))
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Role: RoleAssistant, Parts: []Part{Text(text)}}, nil
+}
+
+// StreamChat echoes the parts of the final message,
+// as StreamContent would.
+// Implements [ChatGenerator.StreamChat].
+func (e echo) StreamChat(ctx context.Context, schema *Schema, msgs []Message) iter.Seq2[*Chunk, error] {
+	return e.StreamContent(ctx, schema, lastParts(msgs))
+}
+
+// lastParts returns the parts of the final message in msgs.
+func lastParts(msgs []Message) []Part {
+	if len(msgs) == 0 {
+		return nil
+	}
+	return msgs[len(msgs)-1].Parts
+}
+
+// EchoToolGenerator returns an implementation
+// of [ToolGenerator] that responds to a conversation
+// ending in a user message by calling each of the offered tools,
+// in order, with the arguments {"text": echo}, where echo is the
+// echo of the final message's parts.
+// It responds to a conversation ending in a [RoleTool] message
+// (or offering no tools) with an assistant message echoing
+// the parts of the final message.
+//
+// For testing.
+func EchoToolGenerator() ToolGenerator {
+	return echo{}
+}
+
+// GenerateToolChat implements [ToolGenerator.GenerateToolChat]
+// as described in [EchoToolGenerator].
+func (e echo) GenerateToolChat(ctx context.Context, tools []*Tool, msgs []Message) (*Message, error) {
+	if len(tools) == 0 || len(msgs) == 0 || msgs[len(msgs)-1].Role == RoleTool {
+		return e.GenerateChat(ctx, nil, msgs)
+	}
+	args, err := json.Marshal(map[string]string{"text": EchoTextResponse(lastParts(msgs)...)})
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{Role: RoleAssistant}
+	for i, t := range tools {
+		msg.Parts = append(msg.Parts, ToolCall{ID: fmt.Sprintf("call%d", i), Name: t.Name, Args: args})
+	}
+	return msg, nil
+}
+
 // EchoTextResponse returns the concatenation of the prompt parts.
 // For testing.
 func EchoTextResponse(promptParts ...Part) string {
 	var echos []string
 	for i, p := range promptParts {
-		switch p := p.(type) {
-		case Text:
-			echos = append(echos, string(p))
-		case Blob:
-			echos = append(echos, fmt.Sprintf("%s%d", p.MIMEType, i))
-		default:
-			panic(fmt.Sprintf("bad type for part: %T; need llm.Text or llm.Blob.", p))
-		}
+		echos = append(echos, echoPart(i, p))
 	}
 	return strings.Join(echos, "")
 }
 
+// echoPart returns the echo of the i'th prompt part p.
+func echoPart(i int, p Part) string {
+	switch p := p.(type) {
+	case Text:
+		return string(p)
+	case Blob:
+		return fmt.Sprintf("%s%d", p.MIMEType, i)
+	case ToolCall:
+		return fmt.Sprintf("%s(%s)", p.Name, p.Args)
+	case ToolResult:
+		return p.Result
+	default:
+		panic(fmt.Sprintf("bad type for part: %T; need llm.Text, llm.Blob, llm.ToolCall or llm.ToolResult.", p))
+	}
+}
+
 // EchoJSONResponse returns the concatenation of the prompt parts,
 // wrapped as a JSON object with a single value "prompt".
 // For testing.
@@ -136,8 +234,8 @@ func EchoJSONResponse(promptParts ...Part) string {
 
 type generateContentFunc func(ctx context.Context, schema *Schema, promptParts []Part) (string, error)
 
-// TestContentGenerator returns a [ContentGenerator] with the given implementations
-// of [GenerateContent].
+// TestContentGenerator returns a [ContentGenerator] with the given model name
+// and implementation of [GenerateContent].
 //
 // This is a convenience function for quickly creating custom test implementations
 // of [ContentGenerator].
@@ -145,7 +243,7 @@ func TestContentGenerator(name string, generateContent generateContentFunc) Cont
 	if generateContent == nil {
 		generateContent = echo{}.GenerateContent
 	}
-	return &generator{generateContent: generateContent}
+	return &generator{model: name, generateContent: generateContent}
 }
 
 // generator is a flexible test implementation of [ContentGenerator].
@@ -162,9 +260,6 @@ func (g *generator) Model() string {
 	return g.model
 }
 
-// SetTemperature implements [ContentGenerator.SetTemperature] as a no-op.
-func (g *generator) SetTemperature(float32) {}
-
 // GenerateContent implements [ContentGenerator.GenerateContent].
 func (g *generator) GenerateContent(ctx context.Context, schema *Schema, promptParts []Part) (string, error) {
 	if g.generateContent == nil {
@@ -172,3 +267,53 @@ func (g *generator) GenerateContent(ctx context.Context, schema *Schema, promptP
 	}
 	return g.generateContent(ctx, schema, promptParts)
 }
+
+type generateToolChatFunc func(ctx context.Context, tools []*Tool, msgs []Message) (*Message, error)
+
+// TestToolGenerator returns a [ToolGenerator] with the given name and
+// implementation of [ToolGenerator.GenerateToolChat].
+// If generateToolChat is nil, the generator behaves like [EchoToolGenerator].
+// The generator's GenerateChat method calls generateToolChat with no tools,
+// and its StreamChat method streams the resulting message text as a single chunk.
+//
+// This is a convenience function for quickly creating custom test implementations
+// of [ToolGenerator], such as scripted models for testing tool loops.
+func TestToolGenerator(name string, generateToolChat generateToolChatFunc) ToolGenerator {
+	if generateToolChat == nil {
+		generateToolChat = echo{}.GenerateToolChat
+	}
+	return &toolGenerator{model: name, generateToolChat: generateToolChat}
+}
+
+// toolGenerator is a flexible test implementation of [ToolGenerator].
+type toolGenerator struct {
+	model            string
+	generateToolChat generateToolChatFunc
+}
+
+// Model implements [ToolGenerator.Model].
+func (g *toolGenerator) Model() string {
+	return g.model
+}
+
+// GenerateChat implements [ToolGenerator.GenerateChat].
+func (g *toolGenerator) GenerateChat(ctx context.Context, _ *Schema, msgs []Message) (*Message, error) {
+	return g.generateToolChat(ctx, nil, msgs)
+}
+
+// StreamChat implements [ToolGenerator.StreamChat].
+func (g *toolGenerator) StreamChat(ctx context.Context, schema *Schema, msgs []Message) iter.Seq2[*Chunk, error] {
+	return func(yield func(*Chunk, error) bool) {
+		msg, err := g.GenerateChat(ctx, schema, msgs)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		yield(&Chunk{Text: msg.Text(), Done: true, Model: g.model}, nil)
+	}
+}
+
+// GenerateToolChat implements [ToolGenerator.GenerateToolChat].
+func (g *toolGenerator) GenerateToolChat(ctx context.Context, tools []*Tool, msgs []Message) (*Message, error) {
+	return g.generateToolChat(ctx, tools, msgs)
+}
@@ -6,6 +6,7 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -52,3 +53,82 @@ func TestEcho(t *testing.T) {
 		t.Errorf("resp  = %q, want %q", resp, want)
 	}
 }
+
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+	parts := []Part{Text("abc"), Blob{MIMEType: "image/jpg"}, Text("123")}
+
+	collect := func(g ContentGenerator, schema *Schema) (chunks []string, last *Chunk) {
+		t.Helper()
+		for c, err := range Stream(ctx, g, schema, parts) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks = append(chunks, c.Text)
+			last = c
+		}
+		return chunks, last
+	}
+
+	t.Run("streamer", func(t *testing.T) {
+		chunks, last := collect(EchoContentGenerator(), nil)
+		if got, want := strings.Join(chunks, "|"), "abc|image/jpg1|123|"; got != want {
+			t.Errorf("Stream() chunks = %q, want %q", got, want)
+		}
+		if !last.Done || last.Model != "echo" {
+			t.Errorf("Stream() last chunk = %+v, want Done, Model=echo", last)
+		}
+		chunks, _ = collect(EchoContentGenerator(), &Schema{Type: TypeString})
+		if got, want := strings.Join(chunks, ""), EchoJSONResponse(parts...); got != want {
+			t.Errorf("Stream(schema) = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		chunks, last := collect(TestContentGenerator("test", nil), nil)
+		if got, want := strings.Join(chunks, "|"), "abcimage/jpg1123"; got != want {
+			t.Errorf("Stream() chunks = %q, want %q", got, want)
+		}
+		if !last.Done || last.Model != "test" {
+			t.Errorf("Stream() last chunk = %+v, want Done, Model=test", last)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		g := TestContentGenerator("test", func(context.Context, *Schema, []Part) (string, error) {
+			return "", errors.New("no model")
+		})
+		for c, err := range Stream(ctx, g, nil, parts) {
+			if c != nil || err == nil {
+				t.Errorf("Stream() = %v, %v, want nil, error", c, err)
+			}
+		}
+	})
+}
+
+func TestEchoChat(t *testing.T) {
+	ctx := context.Background()
+	gen := EchoChatGenerator()
+	msgs := []Message{
+		{Role: RoleSystem, Parts: []Part{Text("be brief")}},
+		{Role: RoleUser, Parts: []Part{Text("abc"), Text("123")}},
+	}
+	msg, err := gen.GenerateChat(ctx, nil, msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Role != RoleAssistant || msg.Text() != "abc123" {
+		t.Errorf("GenerateChat() = %v %q, want %v %q", msg.Role, msg.Text(), RoleAssistant, "abc123")
+	}
+
+	var chunks []string
+	for c, err := range gen.StreamChat(ctx, nil, msgs) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, c.Text)
+	}
+	if got, want := strings.Join(chunks, "|"), "abc|123|"; got != want {
+		t.Errorf("StreamChat() chunks = %q, want %q", got, want)
+	}
+}
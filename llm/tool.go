@@ -0,0 +1,121 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// RoleTool is the role of a message reporting
+// the results of tool calls back to the model.
+// Its parts are ordinarily [ToolResult] values.
+const RoleTool Role = "tool"
+
+// A Tool describes a function that a model can ask to call.
+type Tool struct {
+	Name        string  // name of the function, such as "get_weather"
+	Description string  // what the function does, to help the model decide when to call it
+	Parameters  *Schema // schema of the object of arguments (nil if the function takes no arguments)
+}
+
+// A ToolCall is a [Part] of a model's message asking to call a [Tool].
+type ToolCall struct {
+	ID   string          // call identifier, if the backend assigns one
+	Name string          // name of the tool to call
+	Args json.RawMessage // arguments, as a JSON object matching the tool's Parameters
+}
+
+// A ToolResult is a [Part] reporting the result of a [ToolCall].
+type ToolResult struct {
+	ID     string // identifier of the ToolCall, if any
+	Name   string // name of the tool that was called
+	Result string // result of the call, typically text or JSON
+}
+
+func (ToolCall) isPart()   {}
+func (ToolResult) isPart() {}
+
+// A ToolGenerator is a [ChatGenerator] that can also ask to call tools.
+//
+// See [EchoToolGenerator] and [TestToolGenerator] for implementations
+// useful for testing, and see [RunTools] for running a conversation
+// that calls tools until the model produces a final answer.
+type ToolGenerator interface {
+	ChatGenerator
+	// GenerateToolChat is like GenerateChat, but the model may
+	// call any of the given tools.
+	// If the model wants to call tools, the returned message
+	// contains one or more [ToolCall] parts.
+	// The caller runs the tools and then calls GenerateToolChat again with
+	// the conversation extended by the returned message and a
+	// [RoleTool] message holding the corresponding [ToolResult] parts.
+	GenerateToolChat(ctx context.Context, tools []*Tool, msgs []Message) (*Message, error)
+}
+
+// A ToolFunc implements a [Tool].
+// It receives the arguments of a [ToolCall] and returns the result.
+type ToolFunc func(ctx context.Context, args json.RawMessage) (string, error)
+
+// ToolCalls returns the [ToolCall] parts of m.
+func (m *Message) ToolCalls() []ToolCall {
+	var calls []ToolCall
+	for _, p := range m.Parts {
+		if c, ok := p.(ToolCall); ok {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// RunTools continues the conversation msgs with g, offering g the tools,
+// until g replies with a message that does not call any tools or until
+// g has replied maxSteps times.
+// Each tool call is run using the function in funcs with the tool's name.
+// Calls to unknown tools and calls whose functions return errors are
+// reported back to g as results starting with "error: ", so that g can
+// recover from its mistakes.
+//
+// RunTools returns the conversation extended by all the messages
+// generated by g and by the tool calls; the final message is g's answer.
+// If g fails, or if g is still calling tools after maxSteps replies,
+// RunTools returns the conversation so far and an error.
+func RunTools(ctx context.Context, g ToolGenerator, tools []*Tool, funcs map[string]ToolFunc, msgs []Message, maxSteps int) ([]Message, error) {
+	for range maxSteps {
+		msg, err := g.GenerateToolChat(ctx, tools, msgs)
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, *msg)
+		calls := msg.ToolCalls()
+		if len(calls) == 0 {
+			return msgs, nil
+		}
+		results := Message{Role: RoleTool}
+		for _, c := range calls {
+			results.Parts = append(results.Parts, runTool(ctx, funcs, c))
+		}
+		msgs = append(msgs, results)
+	}
+	return msgs, fmt.Errorf("llm.RunTools: no answer after %d steps", maxSteps)
+}
+
+// runTool runs the tool call c using funcs.
+func runTool(ctx context.Context, funcs map[string]ToolFunc, c ToolCall) ToolResult {
+	r := ToolResult{ID: c.ID, Name: c.Name}
+	f := funcs[c.Name]
+	if f == nil {
+		r.Result = fmt.Sprintf("error: unknown tool %q", c.Name)
+		return r
+	}
+	result, err := f(ctx, c.Args)
+	if err != nil {
+		r.Result = "error: " + err.Error()
+		return r
+	}
+	r.Result = result
+	return r
+}
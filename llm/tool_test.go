@@ -0,0 +1,106 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var upperTool = &Tool{
+	Name:        "upper",
+	Description: "Convert text to upper case.",
+	Parameters: &Schema{
+		Type:       TypeObject,
+		Properties: map[string]*Schema{"text": {Type: TypeString}},
+		Required:   []string{"text"},
+	},
+}
+
+func upper(_ context.Context, args json.RawMessage) (string, error) {
+	var a struct{ Text string }
+	if err := json.Unmarshal(args, &a); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(a.Text), nil
+}
+
+func TestRunToolsEcho(t *testing.T) {
+	ctx := context.Background()
+	msgs := []Message{{Role: RoleUser, Parts: []Part{Text("hello")}}}
+	funcs := map[string]ToolFunc{"upper": upper}
+	out, err := RunTools(ctx, EchoToolGenerator(), []*Tool{upperTool}, funcs, msgs, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// user, assistant (tool call), tool (result), assistant (answer)
+	if len(out) != 4 {
+		t.Fatalf("RunTools() = %d messages, want 4: %v", len(out), out)
+	}
+	calls := out[1].ToolCalls()
+	if len(calls) != 1 || calls[0].Name != "upper" || string(calls[0].Args) != `{"text":"hello"}` {
+		t.Errorf("tool calls = %v, want upper({\"text\":\"hello\"})", calls)
+	}
+	if out[2].Role != RoleTool || len(out[2].Parts) != 1 || out[2].Parts[0] != (ToolResult{ID: "call0", Name: "upper", Result: "HELLO"}) {
+		t.Errorf("tool results = %v, want HELLO", out[2])
+	}
+	if out[3].Role != RoleAssistant || out[3].Text() != "HELLO" {
+		t.Errorf("answer = %s %q, want assistant %q", out[3].Role, out[3].Text(), "HELLO")
+	}
+}
+
+func TestRunToolsScripted(t *testing.T) {
+	ctx := context.Background()
+	var results []ToolResult
+	g := TestToolGenerator("scripted", func(_ context.Context, tools []*Tool, msgs []Message) (*Message, error) {
+		last := msgs[len(msgs)-1]
+		if last.Role != RoleTool {
+			return &Message{Role: RoleAssistant, Parts: []Part{
+				ToolCall{Name: "missing", Args: json.RawMessage(`{}`)},
+				ToolCall{Name: "fail", Args: json.RawMessage(`{}`)},
+			}}, nil
+		}
+		for _, p := range last.Parts {
+			results = append(results, p.(ToolResult))
+		}
+		return &Message{Role: RoleAssistant, Parts: []Part{Text("done")}}, nil
+	})
+	funcs := map[string]ToolFunc{
+		"fail": func(context.Context, json.RawMessage) (string, error) { return "", errors.New("broken") },
+	}
+	msgs := []Message{{Role: RoleUser, Parts: []Part{Text("go")}}}
+	out, err := RunTools(ctx, g, nil, funcs, msgs, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := out[len(out)-1]; last.Text() != "done" {
+		t.Errorf("answer = %q, want %q", last.Text(), "done")
+	}
+	want := []ToolResult{
+		{Name: "missing", Result: `error: unknown tool "missing"`},
+		{Name: "fail", Result: "error: broken"},
+	}
+	if len(results) != len(want) || results[0] != want[0] || results[1] != want[1] {
+		t.Errorf("tool results = %v, want %v", results, want)
+	}
+
+	// A model that never stops calling tools.
+	loop := TestToolGenerator("loop", func(context.Context, []*Tool, []Message) (*Message, error) {
+		return &Message{Role: RoleAssistant, Parts: []Part{ToolCall{Name: "fail"}}}, nil
+	})
+	out, err = RunTools(ctx, loop, nil, funcs, msgs, 3)
+	if err == nil {
+		t.Errorf("RunTools(loop) succeeded, want error")
+	}
+	if len(out) != 1+2*3 {
+		t.Errorf("RunTools(loop) = %d messages, want %d", len(out), 1+2*3)
+	}
+	if m := loop.Model(); m != "loop" {
+		t.Errorf("Model() = %q, want %q", m, "loop")
+	}
+}
@@ -0,0 +1,25 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llm
+
+import "time"
+
+// Usage reports the resources used by a single request to a model.
+// Backends that do not report token counts leave them zero.
+type Usage struct {
+	InputTokens  int           `json:",omitempty"` // tokens in the prompt
+	OutputTokens int           `json:",omitempty"` // tokens generated
+	Latency      time.Duration `json:",omitempty"` // time from sending the request to receiving the whole response
+}
+
+// Add adds the usage v to u. v may be nil.
+func (u *Usage) Add(v *Usage) {
+	if v == nil {
+		return
+	}
+	u.InputTokens += v.InputTokens
+	u.OutputTokens += v.OutputTokens
+	u.Latency += v.Latency
+}
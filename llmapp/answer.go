@@ -0,0 +1,212 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llmapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/storage"
+)
+
+// QuestionAnswer is the output of [Client.Answer].
+type QuestionAnswer struct {
+	Result
+	// The LLM's response, unmarshaled into a Go struct.
+	Output Answer
+}
+
+// Values of [Answer.Status].
+const (
+	// The documents answered the question.
+	Answered = "ANSWERED"
+	// The documents did not contain enough information
+	// to answer the question.
+	InsufficientContext = "INSUFFICIENT_CONTEXT"
+)
+
+// Answer represents the desired JSON structure of the LLM output
+// requested by [Client.Answer].
+// See [answerSchema] for a description of the fields.
+//
+// IMPORTANT: If you add, remove or edit the types or JSON names of
+// fields in this struct, edit [answerSchema] and
+// [answerTestOutput] accordingly.
+type Answer struct {
+	Status  string  `json:"status"`
+	Answer  string  `json:"answer"`
+	Claims  []Claim `json:"claims"`
+	Missing string  `json:"missing"`
+}
+
+// Claim represents the desired JSON structure of the
+// LLM output for a single claim made by an answer.
+type Claim struct {
+	Text      string   `json:"text"`
+	Citations []string `json:"citations"`
+}
+
+// The [*llm.Schema] corresponding to the [Answer] type.
+//
+// IMPORTANT: If you add, remove, or edit the names or types of objects
+// in this schema, edit [Answer] and [answerTestOutput] accordingly.
+var answerSchema = &llm.Schema{
+	Type: llm.TypeObject,
+	Properties: map[string]*llm.Schema{
+		"status": {
+			Type:        llm.TypeString,
+			Enum:        []string{Answered, InsufficientContext},
+			Description: "Whether the documents contain enough information to answer the question.",
+		},
+		"answer": {
+			Type:        llm.TypeString,
+			Description: "The answer to the question, or empty if the context is insufficient.",
+		},
+		"claims": {
+			Type: llm.TypeArray,
+			Items: &llm.Schema{
+				Type: llm.TypeObject,
+				Properties: map[string]*llm.Schema{
+					"text": {
+						Type:        llm.TypeString,
+						Description: "A single factual claim made by the answer.",
+					},
+					"citations": {
+						Type:        llm.TypeArray,
+						Items:       &llm.Schema{Type: llm.TypeString},
+						Description: "The URLs of the documents that support the claim.",
+					},
+				},
+				Required: []string{"text", "citations"},
+			},
+		},
+		"missing": {
+			Type:        llm.TypeString,
+			Description: "If the context is insufficient, the information needed to answer the question.",
+		},
+	},
+	Required: []string{"status", "answer", "claims", "missing"},
+}
+
+// Answer returns a structured, LLM-generated answer to the question,
+// using only the information in the given documents, which are typically
+// the results of a search for the question.
+// Each claim in the answer cites the URLs of the documents supporting it.
+//
+// If the documents do not contain enough information to answer the question,
+// the answer's status is [InsufficientContext]. In particular, if no documents
+// are provided, Answer returns that status without consulting the LLM,
+// and the returned [Result] is empty.
+//
+// Answer returns an error if the question is empty, none of the documents
+// has a URL to cite, the LLM is unable to generate a response,
+// or the response is malformed, for example because it cites a URL
+// that is not one of the documents'. Malformed responses are not cached.
+func (c *Client) Answer(ctx context.Context, question string, docs []*Doc) (*QuestionAnswer, error) {
+	if question == "" {
+		return nil, errors.New("llmapp Answer: no question")
+	}
+	if len(docs) == 0 {
+		return &QuestionAnswer{Output: Answer{Status: InsufficientContext, Missing: "No documents were provided."}}, nil
+	}
+	if !slices.ContainsFunc(docs, func(d *Doc) bool { return d.URL != "" }) {
+		return nil, errors.New("llmapp Answer: no doc has a URL to cite")
+	}
+	// Check the response before it is cached,
+	// so that a malformed response is not returned again.
+	var typed Answer
+	check := func(response string) error {
+		typed = Answer{}
+		if err := json.Unmarshal([]byte(response), &typed); err != nil {
+			return fmt.Errorf("cannot unmarshal response: %w\nresponse: %s", err, response)
+		}
+		if err := checkAnswer(&typed, docs); err != nil {
+			return fmt.Errorf("malformed LLM output (%v)", err)
+		}
+		return nil
+	}
+	result, err := c.checkedOverview(ctx, questionAndDocs, check,
+		&docGroup{label: "documents", docs: docs},
+		&docGroup{label: "question", docs: []*Doc{{Text: question}}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("llmapp Answer: %w", err)
+	}
+	return &QuestionAnswer{Result: *result, Output: typed}, nil
+}
+
+// checkAnswer checks that a is a well-formed answer
+// citing only the URLs of docs.
+func checkAnswer(a *Answer, docs []*Doc) error {
+	switch a.Status {
+	default:
+		return fmt.Errorf("unknown status %q", a.Status)
+	case InsufficientContext:
+		return nil
+	case Answered:
+	}
+	if len(a.Claims) == 0 {
+		return errors.New("answer has no claims")
+	}
+	urls := make(map[string]bool)
+	for _, d := range docs {
+		if d.URL != "" {
+			urls[d.URL] = true
+		}
+	}
+	for i, cl := range a.Claims {
+		if len(cl.Citations) == 0 {
+			return fmt.Errorf("claim %d has no citations", i)
+		}
+		for _, u := range cl.Citations {
+			if !urls[u] {
+				return fmt.Errorf("claim %d cites unknown URL %q", i, u)
+			}
+		}
+	}
+	return nil
+}
+
+// AnswerTestGenerator returns an [llm.ContentGenerator] that can be used
+// in tests of the [Client.Answer] method.
+// The generator answers with status [Answered] and one claim citing each
+// of the URLs, or with status [InsufficientContext] if there are no URLs.
+// The URLs must be those of the documents provided to the [Client.Answer] call.
+//
+// For testing.
+func AnswerTestGenerator(t *testing.T, urls ...string) llm.ContentGenerator {
+	t.Helper()
+
+	raw, _ := answerTestOutput(t, urls...)
+	return llm.TestContentGenerator(
+		"answer-test-generator",
+		func(context.Context, *llm.Schema, []llm.Part) (string, error) {
+			return raw, nil
+		},
+	)
+}
+
+// answerTestOutput returns a JSON string (and its corresponding [Answer] struct) that
+// would be considered valid if output by the LLM call in [Client.Answer].
+// See [AnswerTestGenerator] for a description of urls.
+//
+// For testing.
+func answerTestOutput(t *testing.T, urls ...string) (raw string, typed Answer) {
+	t.Helper()
+
+	a := Answer{Status: InsufficientContext, Claims: []Claim{}, Missing: "missing information"}
+	if len(urls) > 0 {
+		a = Answer{Status: Answered, Answer: "answer"}
+		for _, u := range urls {
+			a.Claims = append(a.Claims, Claim{Text: "claim", Citations: []string{u}})
+		}
+	}
+	return string(storage.JSON(a)), a
+}
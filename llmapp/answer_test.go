@@ -0,0 +1,129 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llmapp
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/storage"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+func TestAnswer(t *testing.T) {
+	ctx := context.Background()
+	lg := testutil.Slogger(t)
+
+	t.Run("basic", func(t *testing.T) {
+		c := New(lg, AnswerTestGenerator(t, doc1.URL), storage.MemDB())
+		got, err := c.Answer(ctx, "why?", []*Doc{doc1, doc2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		promptParts := []llm.Part{llm.Text("documents"), raw1, raw2, llm.Text("question"), llm.Text(`{"text":"why?"}`), llm.Text(questionAndDocs.instructions())}
+		rawOut, out := answerTestOutput(t, doc1.URL)
+		want := &QuestionAnswer{
+			Result: Result{
+				Response: rawOut,
+				Prompt:   promptParts,
+				Schema:   answerSchema,
+				Model:    "answer-test-generator",
+			},
+			Output: out,
+		}
+		if diff := cmp.Diff(want, got, ignoreUsage); diff != "" {
+			t.Errorf("Answer() mismatch (-want +got):\n%s", diff)
+		}
+
+		// The response is cached.
+		got, err = c.Answer(ctx, "why?", []*Doc{doc1, doc2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Cached {
+			t.Error("Answer() = not cached, want cached")
+		}
+	})
+
+	t.Run("insufficient", func(t *testing.T) {
+		c := New(lg, AnswerTestGenerator(t), storage.MemDB())
+		got, err := c.Answer(ctx, "why?", []*Doc{doc1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Output.Status != InsufficientContext || got.Output.Missing == "" {
+			t.Errorf("Answer() = %+v, want insufficient context", got.Output)
+		}
+	})
+
+	t.Run("no docs", func(t *testing.T) {
+		g := llm.TestContentGenerator("fail", func(context.Context, *llm.Schema, []llm.Part) (string, error) {
+			t.Fatal("LLM called with no documents")
+			return "", nil
+		})
+		got, err := New(lg, g, storage.MemDB()).Answer(ctx, "why?", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Output.Status != InsufficientContext {
+			t.Errorf("Answer() status = %q, want %q", got.Output.Status, InsufficientContext)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c := New(lg, AnswerTestGenerator(t, doc1.URL), storage.MemDB())
+		if _, err := c.Answer(ctx, "", []*Doc{doc1}); err == nil {
+			t.Error("Answer() with no question succeeded")
+		}
+		if _, err := c.Answer(ctx, "why?", []*Doc{doc2, doc3}); err == nil {
+			t.Error("Answer() with no doc URLs succeeded")
+		}
+		for _, resp := range []string{
+			`not json`,
+			`{"status":"MAYBE"}`,
+			`{"status":"ANSWERED","answer":"a","claims":[]}`,
+			`{"status":"ANSWERED","answer":"a","claims":[{"text":"c","citations":[]}]}`,
+			`{"status":"ANSWERED","answer":"a","claims":[{"text":"c","citations":["https://example.com/other"]}]}`,
+		} {
+			g := llm.TestContentGenerator("bad", func(context.Context, *llm.Schema, []llm.Part) (string, error) {
+				return resp, nil
+			})
+			if got, err := New(lg, g, storage.MemDB()).Answer(ctx, "why?", []*Doc{doc1, doc2}); err == nil {
+				t.Errorf("Answer() with response %s = %+v, want error", resp, got.Output)
+			}
+		}
+	})
+
+	// A malformed response is not cached.
+	t.Run("not cached", func(t *testing.T) {
+		good, _ := answerTestOutput(t, doc1.URL)
+		calls := 0
+		g := llm.TestContentGenerator("flaky", func(context.Context, *llm.Schema, []llm.Part) (string, error) {
+			calls++
+			if calls == 1 {
+				return `{"status":"ANSWERED","answer":"a","claims":[]}`, nil
+			}
+			return good, nil
+		})
+		c := New(lg, g, storage.MemDB())
+		if _, err := c.Answer(ctx, "why?", []*Doc{doc1}); err == nil {
+			t.Fatal("Answer() with malformed response succeeded")
+		}
+		for i, wantCached := range []bool{false, true} {
+			got, err := c.Answer(ctx, "why?", []*Doc{doc1})
+			if err != nil {
+				t.Fatalf("Answer() #%d after malformed response: %v", i+2, err)
+			}
+			if got.Cached != wantCached || got.Output.Status != Answered {
+				t.Errorf("Answer() #%d = cached %v, status %q, want cached %v, %q", i+2, got.Cached, got.Output.Status, wantCached, Answered)
+			}
+		}
+		if calls != 2 {
+			t.Errorf("generator called %d times, want 2", calls)
+		}
+	})
+}
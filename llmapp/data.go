@@ -27,4 +27,6 @@ type Result struct {
 	Cached   bool        // whether the response was cached
 	Schema   *llm.Schema // the JSON schema used to generate the result (nil if none)
 	Prompt   []llm.Part  // the prompt(s) used to generate the result
+	Model    string      // the model that generated the response
+	Usage    *llm.Usage  // resources used to generate the response (nil if cached)
 }
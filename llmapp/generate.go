@@ -14,32 +14,71 @@ import (
 	"rsc.io/ordered"
 )
 
-// generate returns a (possibly cached) response for the prompts.
-func (c *Client) generate(ctx context.Context, schema *llm.Schema, prompts []llm.Part) (string, bool, error) {
-	model := c.g.Model()
-	h := hash(schema, prompts)
-	k := ordered.Encode(generateTextKind, model, h)
-	c.db.Lock(string(k))
-	defer c.db.Unlock(string(k))
+// generate returns a (possibly cached) response for the prompts,
+// as a [Result] with the Response, Cached, Schema, Prompt, Model and Usage
+// fields set, and records its usage (see [Usage]).
+//
+// Responses are cached under the model that generated them
+// and the generation options it used (see [llm.DefaultOptions]).
+// If c.g may use several models (see [llm.Models]), as an [llm.Router] does,
+// a response cached for any of them is a cache hit.
+func (c *Client) generate(ctx context.Context, schema *llm.Schema, prompts []llm.Part) (*Result, error) {
+	return c.generateChecked(ctx, schema, prompts, nil)
+}
+
+// generateChecked is like generate, but if check is non-nil,
+// it only returns and caches responses for which check returns nil.
+// If a new response fails the check, generateChecked returns the check's error.
+// A cached response that fails the check, perhaps cached before
+// the check was introduced, is ignored and replaced.
+func (c *Client) generateChecked(ctx context.Context, schema *llm.Schema, prompts []llm.Part, check func(response string) error) (*Result, error) {
+	opts := llm.OptionsFromContext(ctx)
+	lock := string(ordered.Encode(generateTextKind, c.g.Model(), hash(opts, schema, prompts)))
+	c.db.Lock(lock)
+	defer c.db.Unlock(lock)
 
-	r := c.load(k)
-	if r != nil {
-		// cache hit
-		return r.Response, true, nil
+	// Each model's responses are cached under a hash that includes
+	// the model's default options, merged with the options in ctx.
+	modelHash := func(model string) []byte {
+		return hash(llm.DefaultOptions(c.g, model).Merge(opts), schema, prompts)
+	}
+
+	result := &Result{Schema: schema, Prompt: prompts}
+	for _, model := range llm.Models(c.g) {
+		if r := c.load(ordered.Encode(generateTextKind, model, modelHash(model))); r != nil {
+			if check != nil {
+				if err := check(r.Response); err != nil {
+					c.slog.Warn("ignoring invalid cached response", "model", model, "err", err)
+					continue
+				}
+			}
+			// cache hit
+			result.Response, result.Cached, result.Model = r.Response, true, r.Model
+			c.recordUsage(ctx, r.Model, nil)
+			return result, nil
+		}
 	}
 
 	// cache miss
-	result, err := c.g.GenerateContent(ctx, schema, prompts)
+	resp, err := llm.Generate(ctx, c.g, schema, prompts)
 	if err != nil {
-		return "", false, err
+		return nil, err
+	}
+	c.recordUsage(ctx, resp.Model, resp.Usage)
+	if check != nil {
+		if err := check(resp.Text); err != nil {
+			return nil, err
+		}
 	}
 
-	c.db.Set(k, storage.JSON(response{
-		Model:      model,
+	h := modelHash(resp.Model)
+	c.db.Set(ordered.Encode(generateTextKind, resp.Model, h), storage.JSON(response{
+		Model:      resp.Model,
 		PromptHash: h,
-		Response:   result,
+		Response:   resp.Text,
 	}))
-	return result, false, nil
+	result.Response, result.Model, result.Usage = resp.Text, resp.Model, resp.Usage
+	return result, nil
 }
 
 // Cache key context.
@@ -66,15 +105,28 @@ func (c *Client) load(key []byte) *response {
 type response struct {
 	// The generative model used to generate the response.
 	Model string
-	// The SHA-256 hash of the schema and prompts used to generate the response.
+	// The SHA-256 hash of the generation options, schema and prompts
+	// used to generate the response.
 	PromptHash []byte
 	// The raw generated response.
 	Response string
 }
 
-// hash returns the SHA-256 hash of the schema, and the strings or blobs.
-func hash(schema *llm.Schema, parts []llm.Part) []byte {
+// hash returns the SHA-256 hash of the generation options, the schema,
+// and the strings or blobs.
+// Options that do not affect the response (KeepAlive) are not hashed,
+// and neither are empty options, so that requests without options
+// hash the same as before options existed.
+func hash(opts *llm.Options, schema *llm.Schema, parts []llm.Part) []byte {
 	h := sha256.New()
+	if opts != nil {
+		o := *opts
+		o.KeepAlive = nil
+		if js := storage.JSON(o); string(js) != "{}" {
+			h.Write([]byte("options"))
+			h.Write(js)
+		}
+	}
 	if schema != nil {
 		h.Write(storage.JSON(schema))
 	}
@@ -11,6 +11,18 @@
 //
 //	("llmapp.GenerateText", generativeModel, promptHash) -> [response]
 //
+// where generativeModel is the model that actually generated the response,
+// which for an [llm.Router] is one of its underlying models.
+//
+// Each request is tagged (see [llm.WithTask]) with the kind of task,
+// such as "post_and_comments", so that a router can choose a model by task.
+//
+// Usage statistics for each model and task are stored as:
+//
+//	("llmapp.Usage", model, task) -> [UsageStats]
+//
+// See [Usage].
+//
 // Note that currently there is no clear way to clean up old cache values
 // that are no longer relevant, but we might want to add this in the future.
 //
@@ -91,21 +103,19 @@ type docGroup struct {
 // overview returns an error if no documents are provided or the LLM is unable
 // to generate a response.
 func (c *Client) overview(ctx context.Context, kind docsKind, groups ...*docGroup) (*Result, error) {
+	return c.checkedOverview(ctx, kind, nil, groups...)
+}
+
+// checkedOverview is like overview, but only returns and caches
+// responses for which check returns nil (see [Client.generateChecked]).
+func (c *Client) checkedOverview(ctx context.Context, kind docsKind, check func(string) error, groups ...*docGroup) (*Result, error) {
 	if len(groups) == 0 {
 		return nil, errors.New("llmapp overview: no documents")
 	}
 	prompt := prompt(kind, groups)
 	schema := kind.schema()
-	overview, cached, err := c.generate(ctx, schema, prompt)
-	if err != nil {
-		return nil, err
-	}
-	return &Result{
-		Response: overview,
-		Cached:   cached,
-		Schema:   schema,
-		Prompt:   prompt,
-	}, nil
+	ctx = llm.WithTask(ctx, string(kind))
+	return c.generateChecked(ctx, schema, prompt, check)
 }
 
 // prompt converts the given docs into a slice of
@@ -139,6 +149,9 @@ var (
 	// The documents represent a document followed by documents
 	// that are related to it in some way.
 	docAndRelated docsKind = "doc_and_related"
+	// The documents represent search results retrieved
+	// for a question, followed by the question.
+	questionAndDocs docsKind = "question_and_docs"
 )
 
 //go:embed prompts/*.tmpl
@@ -160,8 +173,11 @@ func (k docsKind) instructions() string {
 // schema returns the JSON schema for the given document kind,
 // or nil if there is no corresponding JSON schema.
 func (k docsKind) schema() *llm.Schema {
-	if k == docAndRelated {
+	switch k {
+	case docAndRelated:
 		return relatedSchema
+	case questionAndDocs:
+		return answerSchema
 	}
 	return nil
 }
@@ -7,15 +7,19 @@ package llmapp
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"math/rand/v2"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/superryanguo/ryai/llm"
 	"github.com/superryanguo/ryai/storage"
 	"github.com/superryanguo/ryai/testutil"
+	"rsc.io/ordered"
 )
 
 func TestOverview(t *testing.T) {
@@ -31,8 +35,9 @@ func TestOverview(t *testing.T) {
 		want := &Result{
 			Response: llm.EchoTextResponse(promptParts...),
 			Prompt:   promptParts,
+			Model:    "echo",
 		}
-		if diff := cmp.Diff(want, got); diff != "" {
+		if diff := cmp.Diff(want, got, ignoreUsage); diff != "" {
 			t.Errorf("Overview() mismatch (-want +got):\n%s", diff)
 		}
 	})
@@ -46,8 +51,9 @@ func TestOverview(t *testing.T) {
 		want := &Result{
 			Response: llm.EchoTextResponse(promptParts...),
 			Prompt:   promptParts,
+			Model:    "echo",
 		}
-		if diff := cmp.Diff(want, got); diff != "" {
+		if diff := cmp.Diff(want, got, ignoreUsage); diff != "" {
 			t.Errorf("PostOverview() mismatch (-want +got):\n%s", diff)
 		}
 	})
@@ -61,13 +67,18 @@ func TestOverview(t *testing.T) {
 		want := &Result{
 			Response: llm.EchoTextResponse(promptParts...),
 			Prompt:   promptParts,
+			Model:    "echo",
 		}
-		if diff := cmp.Diff(want, got); diff != "" {
+		if diff := cmp.Diff(want, got, ignoreUsage); diff != "" {
 			t.Errorf("UpdatedPostOverview() mismatch (-want +got):\n%s", diff)
 		}
 	})
 }
 
+// ignoreUsage ignores [Result.Usage], whose latency varies from run to run.
+// TestUsage checks the usage.
+var ignoreUsage = cmpopts.IgnoreFields(Result{}, "Usage")
+
 var (
 	doc1 = &Doc{URL: "https://example.com", Author: "rsc", Title: "title", Text: "some text"}
 	doc2 = &Doc{Text: "some text 2"}
@@ -91,7 +102,7 @@ func TestGenerate(t *testing.T) {
 	t.Run("echo", func(t *testing.T) {
 		c := New(lg, llm.EchoContentGenerator(), db)
 		prompt := []llm.Part{llm.Text("a"), llm.Text("b"), llm.Text("c")}
-		got, cached, err := c.generate(ctx, nil, prompt)
+		got, cached, err := generateText(ctx, c, nil, prompt)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -104,7 +115,7 @@ func TestGenerate(t *testing.T) {
 		}
 
 		// The result should be cached on the second call.
-		got, cached, err = c.generate(ctx, nil, prompt)
+		got, cached, err = generateText(ctx, c, nil, prompt)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -121,7 +132,7 @@ func TestGenerate(t *testing.T) {
 	t.Run("random", func(t *testing.T) {
 		c := New(lg, randomContentGenerator(), db)
 		prompt := []llm.Part{llm.Text("a"), llm.Text("b"), llm.Text("c")}
-		got1, cached, err := c.generate(ctx, nil, prompt)
+		got1, cached, err := generateText(ctx, c, nil, prompt)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -129,7 +140,7 @@ func TestGenerate(t *testing.T) {
 			t.Error("generate() = cached, want not cached")
 		}
 
-		got2, cached, err := c.generate(ctx, nil, prompt)
+		got2, cached, err := generateText(ctx, c, nil, prompt)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -140,6 +151,134 @@ func TestGenerate(t *testing.T) {
 			t.Error("generate() = not cached, want cached")
 		}
 	})
+
+	// With a router, the response is cached under the model
+	// that generated it, not the router.
+	t.Run("router", func(t *testing.T) {
+		db := storage.MemDB()
+		down := llm.TestContentGenerator("down", func(context.Context, *llm.Schema, []llm.Part) (string, error) {
+			return "", errors.New("down")
+		})
+		r := llm.Router(lg, "router", llm.Route{Name: "all", Generators: []llm.ContentGenerator{down, llm.EchoContentGenerator()}})
+		c := New(lg, r, db)
+		prompt := []llm.Part{llm.Text("a"), llm.Text("b"), llm.Text("c")}
+		want := llm.EchoTextResponse(prompt...)
+		got, cached, err := generateText(ctx, c, nil, prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want || cached {
+			t.Errorf("generate() = %q, %v, want %q, false", got, cached, want)
+		}
+		h := hash(nil, nil, prompt)
+		if _, ok := db.Get(ordered.Encode(generateTextKind, "echo", h)); !ok {
+			t.Errorf("response not cached under responding model")
+		}
+		if _, ok := db.Get(ordered.Encode(generateTextKind, "router", h)); ok {
+			t.Errorf("response cached under router model")
+		}
+
+		// The cached response is used by the router
+		// and by a client using the responding model directly.
+		for _, g := range []llm.ContentGenerator{r, llm.EchoContentGenerator()} {
+			got, cached, err := generateText(ctx, New(lg, g, db), nil, prompt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want || !cached {
+				t.Errorf("%s: generate() = %q, %v, want %q, true", g.Model(), got, cached, want)
+			}
+		}
+	})
+}
+
+func TestGenerateOptions(t *testing.T) {
+	ctx := context.Background()
+	c := New(testutil.Slogger(t), randomContentGenerator(), storage.MemDB())
+	prompt := []llm.Part{llm.Text("a")}
+
+	gen := func(ctx context.Context) (string, bool) {
+		t.Helper()
+		out, cached, err := generateText(ctx, c, nil, prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out, cached
+	}
+
+	cold := llm.WithOptions(ctx, &llm.Options{Temperature: llm.Ptr[float32](0)})
+	hot := llm.WithOptions(ctx, &llm.Options{Temperature: llm.Ptr[float32](1)})
+	if _, cached := gen(cold); cached {
+		t.Errorf("generate(temperature 0) cached, want not cached")
+	}
+	if _, cached := gen(hot); cached {
+		t.Errorf("generate(temperature 1) cached after temperature 0, want not cached")
+	}
+	if _, cached := gen(ctx); cached {
+		t.Errorf("generate(no options) cached after temperature 0 and 1, want not cached")
+	}
+	if _, cached := gen(llm.WithOptions(cold, &llm.Options{KeepAlive: llm.Ptr(time.Hour)})); !cached {
+		t.Errorf("generate(temperature 0, keep alive) not cached, want cached")
+	}
+	if _, cached := gen(llm.WithOptions(ctx, &llm.Options{})); !cached {
+		t.Errorf("generate(empty options) not cached, want cached as no options")
+	}
+}
+
+// A defaultsGenerator is a ContentGenerator with default options
+// (see [llm.DefaultOptions]).
+type defaultsGenerator struct {
+	llm.ContentGenerator
+	opts *llm.Options
+}
+
+func (g *defaultsGenerator) DefaultOptions(string) *llm.Options { return g.opts }
+
+// Test that the generator's default options are part of the cache key.
+func TestGenerateDefaultOptions(t *testing.T) {
+	ctx := context.Background()
+	lg := testutil.Slogger(t)
+	db := storage.MemDB()
+	prompt := []llm.Part{llm.Text("a")}
+
+	gen := func(ctx context.Context, g llm.ContentGenerator) bool {
+		t.Helper()
+		_, cached, err := generateText(ctx, New(lg, g, db), nil, prompt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cached
+	}
+
+	cold := &defaultsGenerator{randomContentGenerator(), &llm.Options{Temperature: llm.Ptr[float32](0)}}
+	hot := &defaultsGenerator{randomContentGenerator(), &llm.Options{Temperature: llm.Ptr[float32](1)}}
+	if gen(ctx, cold) {
+		t.Errorf("generate(default temperature 0) cached, want not cached")
+	}
+	if gen(ctx, hot) {
+		t.Errorf("generate(default temperature 1) cached after default temperature 0, want not cached")
+	}
+	if !gen(llm.WithOptions(ctx, &llm.Options{Temperature: llm.Ptr[float32](0)}), randomContentGenerator()) {
+		t.Errorf("generate(temperature 0) not cached after default temperature 0, want cached")
+	}
+	if !gen(llm.WithOptions(ctx, &llm.Options{Temperature: llm.Ptr[float32](0)}), hot) {
+		t.Errorf("generate(temperature 0 overriding default 1) not cached, want cached")
+	}
+
+	// A router uses the defaults of the generator for each model.
+	r := llm.Router(lg, "router", llm.Route{Name: "all", Generators: []llm.ContentGenerator{hot}})
+	if !gen(ctx, r) {
+		t.Errorf("generate(router to default temperature 1) not cached, want cached")
+	}
+}
+
+// generateText calls c.generate and returns the response and whether it was cached.
+func generateText(ctx context.Context, c *Client, schema *llm.Schema, prompts []llm.Part) (string, bool, error) {
+	r, err := c.generate(ctx, schema, prompts)
+	if err != nil {
+		return "", false, err
+	}
+	return r.Response, r.Cached, nil
 }
 
 // randomContentGenerator returns an [llm.ContentGenerator] that ignores
@@ -203,3 +342,45 @@ func TestInstructions(t *testing.T) {
 		}
 	})
 }
+
+func TestUsage(t *testing.T) {
+	ctx := context.Background()
+	db := storage.MemDB()
+	g := llm.TestContentGenerator("counter", func(context.Context, *llm.Schema, []llm.Part) (string, error) {
+		return "overview", nil
+	})
+	c := New(testutil.Slogger(t), g, db)
+
+	r, err := c.Overview(ctx, doc1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Usage == nil || r.Usage.Latency <= 0 {
+		t.Errorf("Overview() usage = %+v, want measured latency", r.Usage)
+	}
+	r, err = c.Overview(ctx, doc1) // cached
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Cached || r.Usage != nil || r.Model != "counter" {
+		t.Errorf("cached Overview() = cached %v, usage %+v, model %q, want cached, nil usage, counter", r.Cached, r.Usage, r.Model)
+	}
+	if _, err := c.PostOverview(ctx, doc1, []*Doc{doc2}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := Usage(db)
+	if len(stats) != 2 {
+		t.Fatalf("Usage() = %d entries, want 2", len(stats))
+	}
+	for _, s := range stats {
+		s.Latency = 0
+	}
+	want := []*UsageStats{
+		{Model: "counter", Task: "documents", Calls: 1, CacheHits: 1},
+		{Model: "counter", Task: "post_and_comments", Calls: 1},
+	}
+	if diff := cmp.Diff(want, stats); diff != "" {
+		t.Errorf("Usage() mismatch (-want +got):\n%s", diff)
+	}
+}
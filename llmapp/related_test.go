@@ -28,10 +28,11 @@ func TestAnalyzeRelated(t *testing.T) {
 				Response: rawOut,
 				Prompt:   promptParts,
 				Schema:   docAndRelated.schema(),
+				Model:    "related-test-generator",
 			},
 			Output: out,
 		}
-		if diff := cmp.Diff(want, got); diff != "" {
+		if diff := cmp.Diff(want, got, ignoreUsage); diff != "" {
 			t.Errorf("AnalyzeRelated() mismatch (-want +got):\n%s", diff)
 		}
 	})
@@ -0,0 +1,64 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package llmapp
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/storage"
+	"rsc.io/ordered"
+)
+
+// UsageStats are the accumulated usage statistics
+// of the LLM calls made for one task using one model.
+type UsageStats struct {
+	Model     string // model that generated the responses
+	Task      string // task, such as "post_and_comments", or "" if untagged (see [llm.WithTask])
+	Calls     int64  // number of responses generated by the model
+	CacheHits int64  // number of responses served from the cache
+	llm.Usage        // total usage of the generated responses
+}
+
+// Usage returns the usage statistics recorded in db by [Client]s using db,
+// ordered by model and then task.
+func Usage(db storage.DB) []*UsageStats {
+	var stats []*UsageStats
+	for _, vf := range db.Scan(ordered.Encode(usageKind), ordered.Encode(usageKind, ordered.Inf)) {
+		var s UsageStats
+		if err := json.Unmarshal(vf(), &s); err != nil {
+			db.Panic("llmapp usage decode", "err", err)
+		}
+		stats = append(stats, &s)
+	}
+	return stats
+}
+
+// Usage key context.
+const usageKind = "llmapp.Usage"
+
+// recordUsage adds a call to the model in the task of ctx to the
+// usage statistics. A nil usage records a cache hit.
+func (c *Client) recordUsage(ctx context.Context, model string, u *llm.Usage) {
+	task := llm.Task(ctx)
+	key := ordered.Encode(usageKind, model, task)
+	c.db.Lock(string(key))
+	defer c.db.Unlock(string(key))
+
+	s := UsageStats{Model: model, Task: task}
+	if val, ok := c.db.Get(key); ok {
+		if err := json.Unmarshal(val, &s); err != nil {
+			c.slog.Error("cannot unmarshal usage stats", "model", model, "task", task, "err", err)
+		}
+	}
+	if u == nil {
+		s.CacheHits++
+	} else {
+		s.Calls++
+		s.Usage.Add(u)
+	}
+	c.db.Set(key, storage.JSON(s))
+}
@@ -0,0 +1,48 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ollama
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/superryanguo/ryai/llm"
+)
+
+// BackendName is the name of the Ollama backend in the [llm.NewBackend] registry,
+// which is the default backend.
+const BackendName = llm.DefaultBackend
+
+func init() {
+	llm.RegisterBackend(BackendName, newBackend)
+}
+
+// newBackend returns an [llm.Backend] using the Ollama server in cfg.
+// Empty models default to [DefaultEmbeddingModel] and [DefaultGenModel].
+// The options are the default generation options; see [llm.ParseOptions].
+func newBackend(lg *slog.Logger, cfg *llm.BackendConfig) (*llm.Backend, error) {
+	embedModel := cfg.EmbedModel
+	if embedModel == "" {
+		embedModel = DefaultEmbeddingModel
+	}
+	genModel := cfg.GenModel
+	if genModel == "" {
+		genModel = DefaultGenModel
+	}
+	e, err := NewClient(lg, cfg.HTTP, cfg.Server, embedModel)
+	if err != nil {
+		return nil, err
+	}
+	g, err := NewClient(lg, cfg.HTTP, cfg.Server, genModel)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := llm.ParseOptions(cfg.Options)
+	if err != nil {
+		return nil, fmt.Errorf("ollama backend: %w", err)
+	}
+	g.opts = opts
+	return &llm.Backend{Embedder: e, Generator: g}, nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+	"time"
+
+	"github.com/superryanguo/ryai/llm"
+)
+
+// Ollama model management endpoints.
+const (
+	TagsUrl   = "/api/tags"
+	ShowUrl   = "/api/show"
+	PullUrl   = "/api/pull"
+	DeleteUrl = "/api/delete"
+)
+
+// A ModelInfo describes a model available on the Ollama server.
+type ModelInfo struct {
+	Name       string       `json:"name"`
+	Model      string       `json:"model"`
+	ModifiedAt time.Time    `json:"modified_at"`
+	Size       int64        `json:"size"` // bytes
+	Digest     string       `json:"digest"`
+	Details    ModelDetails `json:"details"`
+}
+
+// ModelDetails are the details of a model's format and size.
+type ModelDetails struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`     // such as "3.2B"
+	QuantizationLevel string   `json:"quantization_level"` // such as "Q4_K_M"
+}
+
+// A ModelShow is the full description of a model, as returned by [Client.Show].
+type ModelShow struct {
+	Modelfile    string         `json:"modelfile"`
+	Parameters   string         `json:"parameters"`
+	Template     string         `json:"template"`
+	Details      ModelDetails   `json:"details"`
+	ModelInfo    map[string]any `json:"model_info"` // architecture details, such as "llama.context_length"
+	Capabilities []string       `json:"capabilities"`
+	ModifiedAt   time.Time      `json:"modified_at"`
+}
+
+// A PullProgress reports the progress of a [Client.Pull].
+// While a layer is downloading, Digest names the layer, and
+// Completed and Total give the bytes downloaded so far and in all.
+// The final progress report has Status "success".
+type PullProgress struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest,omitempty"`
+	Total     int64  `json:"total,omitempty"`
+	Completed int64  `json:"completed,omitempty"`
+}
+
+// List returns the models available on the server.
+func (c *Client) List(ctx context.Context) ([]*ModelInfo, error) {
+	var resp struct {
+		Models []*ModelInfo `json:"models"`
+	}
+	if err := c.call(ctx, http.MethodGet, TagsUrl, "", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Models, nil
+}
+
+// Show returns the description of the named model.
+// If the model is not on the server, Show returns an error
+// matching [llm.ErrModelNotFound].
+func (c *Client) Show(ctx context.Context, model string) (*ModelShow, error) {
+	var resp ModelShow
+	if err := c.call(ctx, http.MethodPost, ShowUrl, model, modelRequest{Model: model}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Delete deletes the named model from the server.
+// If the model is not on the server, Delete returns an error
+// matching [llm.ErrModelNotFound].
+func (c *Client) Delete(ctx context.Context, model string) error {
+	return c.call(ctx, http.MethodDelete, DeleteUrl, model, modelRequest{Model: model}, nil)
+}
+
+// Pull downloads the named model to the server, returning an
+// iterator over progress reports as the download proceeds.
+// The iteration ends after the report with Status "success",
+// or with an error.
+func (c *Client) Pull(ctx context.Context, model string) iter.Seq2[*PullProgress, error] {
+	return func(yield func(*PullProgress, error) bool) {
+		response, err := post(ctx, c.hc, c.url.JoinPath(PullUrl), modelRequest{Model: model, Stream: true})
+		if err != nil {
+			yield(nil, transportError(ctx, model, err))
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				err = transportError(ctx, model, err)
+			} else {
+				err = responseError(response, model, body)
+			}
+			yield(nil, err)
+			return
+		}
+
+		dec := json.NewDecoder(response.Body)
+		for {
+			var p struct {
+				PullProgress
+				Error string `json:"error"`
+			}
+			if err := dec.Decode(&p); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF // stream ended before success
+				}
+				yield(nil, transportError(ctx, model, err))
+				return
+			}
+			if p.Error != "" {
+				yield(nil, newError(model, 0, p.Error))
+				return
+			}
+			if !yield(&p.PullProgress, nil) || p.Status == "success" {
+				return
+			}
+		}
+	}
+}
+
+// AutoPull arranges for c to pull its model if the model is missing
+// from the server, before c's first request that needs it.
+// If progress is non-nil, it is called with each progress report.
+// AutoPull must be called before c is used.
+func (c *Client) AutoPull(progress func(*PullProgress)) {
+	if progress == nil {
+		progress = func(*PullProgress) {}
+	}
+	c.autoPull = progress
+}
+
+// ensureModel makes sure c's model is on the server,
+// pulling it if c.AutoPull was called and the model is missing.
+func (c *Client) ensureModel(ctx context.Context) error {
+	if c.autoPull == nil {
+		return nil
+	}
+	c.pullMu.Lock()
+	defer c.pullMu.Unlock()
+	if c.ready {
+		return nil
+	}
+	_, err := c.Show(ctx, c.model)
+	if errors.Is(err, llm.ErrModelNotFound) {
+		c.slog.Info("ollama pulling missing model", "model", c.model)
+		err = nil
+		for p, perr := range c.Pull(ctx, c.model) {
+			if perr != nil {
+				err = perr
+				break
+			}
+			c.autoPull(p)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	c.ready = true
+	return nil
+}
+
+// A modelRequest is a request naming a model.
+type modelRequest struct {
+	Model  string `json:"model"`
+	Stream bool   `json:"stream,omitempty"`
+}
+
+// call sends req (if non-nil) to the endpoint with the method and decodes
+// the JSON response into resp (if non-nil).
+// The model is used for error reporting.
+func (c *Client) call(ctx context.Context, method, endpoint, model string, req, resp any) error {
+	response, err := send(ctx, c.hc, method, c.url.JoinPath(endpoint), req)
+	if err != nil {
+		return transportError(ctx, model, err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return transportError(ctx, model, err)
+	}
+	if err := responseError(response, model, body); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(body, resp)
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ollama
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+func TestModels(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/models.httprr", DefaultGenModel)
+
+	// httprr replays identical requests with the same response,
+	// so each request below is different from the others.
+
+	list, err := c.List(ctx)
+	check(err)
+	var names []string
+	for _, m := range list {
+		names = append(names, m.Name)
+	}
+	if want := []string{"mxbai-embed-large:latest", "llama3.2:3b"}; !slices.Equal(names, want) {
+		t.Errorf("List() = %q, want %q", names, want)
+	}
+
+	show, err := c.Show(ctx, DefaultGenModel)
+	check(err)
+	if show.Details.Family != "llama" || show.Details.ParameterSize != "3.2B" || !slices.Contains(show.Capabilities, "tools") {
+		t.Errorf("Show(%q) = %+v, want llama 3.2B with tools", DefaultGenModel, show)
+	}
+	if _, err := c.Show(ctx, "all-minilm"); !errors.Is(err, llm.ErrModelNotFound) {
+		t.Errorf("Show(missing) err = %v, want %v", err, llm.ErrModelNotFound)
+	}
+
+	var progress []*PullProgress
+	for p, err := range c.Pull(ctx, "all-minilm:latest") {
+		check(err)
+		progress = append(progress, p)
+	}
+	if len(progress) < 2 || progress[len(progress)-1].Status != "success" {
+		t.Fatalf("Pull() progress = %v, want ... success", progress)
+	}
+	var sawBytes bool
+	for _, p := range progress {
+		if p.Total > 0 && p.Completed == p.Total {
+			sawBytes = true
+		}
+	}
+	if !sawBytes {
+		t.Errorf("Pull() progress never completed a layer: %v", progress)
+	}
+	if _, err := c.Show(ctx, "all-minilm:latest"); err != nil {
+		t.Errorf("Show() after Pull: %v", err)
+	}
+
+	check(c.Delete(ctx, "all-minilm:latest"))
+	if err := c.Delete(ctx, "all-minilm"); !errors.Is(err, llm.ErrModelNotFound) {
+		t.Errorf("Delete(missing) err = %v, want %v", err, llm.ErrModelNotFound)
+	}
+
+	for _, err := range c.Pull(ctx, "nosuchmodel") {
+		if err == nil {
+			continue
+		}
+		var e *llm.Error
+		if !errors.As(err, &e) || e.Message == "" {
+			t.Errorf("Pull(nosuchmodel) err = %v, want *llm.Error", err)
+		}
+		return
+	}
+	t.Errorf("Pull(nosuchmodel) succeeded, want error")
+}
+
+func TestAutoPull(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/autopull.httprr", "qwen2.5:0.5b")
+	var progress []*PullProgress
+	c.AutoPull(func(p *PullProgress) { progress = append(progress, p) })
+
+	// The first request pulls the model; the second does not.
+	for range 2 {
+		out, err := c.GenerateContent(ctx, nil, []llm.Part{llm.Text("What is the capital of France?")})
+		check(err)
+		if out != "The capital of France is Paris." {
+			t.Errorf("GenerateContent() = %q", out)
+		}
+	}
+	if len(progress) == 0 || progress[len(progress)-1].Status != "success" {
+		t.Errorf("auto pull progress = %v, want ... success", progress)
+	}
+}
@@ -1,20 +1,33 @@
 // Package ollama implements access to offline Ollama model.
 //
-// [Client] implements [llm.Embedder]. Use [NewClient] to connect.
+// [Client] implements [llm.Embedder], [llm.ContentGenerator],
+// [llm.ContentStreamer], [llm.ResponseGenerator], [llm.ChatGenerator]
+// and [llm.ToolGenerator].
+// Image [llm.Blob] parts in prompts and messages are sent to
+// Ollama vision models as images.
+//
+// Client also manages the models on the server:
+// see [Client.List], [Client.Show], [Client.Pull] and [Client.Delete],
+// and [Client.AutoPull] to pull a missing model on first use.
+// Use [NewClient] to connect.
 package ollama
 
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/superryanguo/ryai/llm"
 )
@@ -24,6 +37,7 @@ const (
 	EmbedUrl              = "/api/embed"
 	DefaultGenModel       = "llama3.2:3b"
 	GenUrl                = "/api/generate"
+	ChatUrl               = "/api/chat"
 	DefaultGenModel2      = "llama3"
 	maxBatch              = 512 // default physical batch size in ollama
 )
@@ -34,26 +48,70 @@ type Client struct {
 	hc    *http.Client
 	url   *url.URL // url of the ollama server
 	model string
+	opts  *llm.Options // default generation options; nil means the model defaults
+
+	// automatic pulling of a missing model; see [Client.AutoPull]
+	pullMu   sync.Mutex
+	autoPull func(*PullProgress)
+	ready    bool // model is known to be present
 }
 
+// A Response is a (possibly partial) response from the
+// Ollama generate or chat endpoint.
+// Generate responses set Response, and chat responses set Message.
 type Response struct {
-	Model     string `json:"model"`
-	CreatedAt string `json:"created_at"`
-	Response  string `json:"response"`
-	Done      bool   `json:"done"`
+	Model     string       `json:"model"`
+	CreatedAt string       `json:"created_at"`
+	Response  string       `json:"response"`
+	Message   *chatMessage `json:"message,omitempty"`
+	Done      bool         `json:"done"`
+	Error     string       `json:"error,omitempty"` // set by Ollama for errors mid-stream
+
+	// Statistics, set in the final response.
+	TotalDuration      int64 `json:"total_duration,omitempty"`       // nanoseconds
+	LoadDuration       int64 `json:"load_duration,omitempty"`        // nanoseconds
+	PromptEvalCount    int   `json:"prompt_eval_count,omitempty"`    // tokens in the prompt
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"` // nanoseconds
+	EvalCount          int   `json:"eval_count,omitempty"`           // tokens generated
+	EvalDuration       int64 `json:"eval_duration,omitempty"`        // nanoseconds
+}
+
+// usage returns the usage statistics in the final response r.
+// The latency is Ollama's total duration for the request.
+func (r *Response) usage() *llm.Usage {
+	return &llm.Usage{
+		InputTokens:  r.PromptEvalCount,
+		OutputTokens: r.EvalCount,
+		Latency:      time.Duration(r.TotalDuration),
+	}
 }
 
+// text returns the generated text in r.
+func (r *Response) text() string {
+	if r.Message != nil {
+		return r.Message.Content
+	}
+	return r.Response
+}
+
+// AssembleRsp assembles the text of the streamed NDJSON response d,
+// as returned by [Client.Prompt].
+// If the stream contains an error object, AssembleRsp returns
+// the text up to that point along with the error, as an [*llm.Error].
 func AssembleRsp(d []byte) (string, error) {
 	var s string
-	var err error
-
 	lines := strings.Split(string(d), "\n")
 	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
 		var resp Response
-		err = json.Unmarshal([]byte(line), &resp)
-		if err != nil {
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
 			return s, err
 		}
+		if resp.Error != "" {
+			return s, newError(resp.Model, 0, resp.Error)
+		}
 		s += resp.Response
 	}
 	return s, nil
@@ -61,8 +119,9 @@ func AssembleRsp(d []byte) (string, error) {
 
 // NewClient returns a connection to Ollama server. If empty, the
 // server is assumed to be hosted at http://127.0.0.1:11434.
-// The model is the model name to use for embedding.
-// A typical model for embedding is "mxbai-embed-large".
+// The model is the model name to use for embedding or generation.
+// A typical model for embedding is "mxbai-embed-large",
+// and a typical model for generation is "llama3.2:3b".
 func NewClient(lg *slog.Logger, hc *http.Client, server string, model string) (*Client, error) {
 	if server == "" {
 		host := os.Getenv("OLLAMA_HOST")
@@ -81,6 +140,9 @@ func NewClient(lg *slog.Logger, hc *http.Client, server string, model string) (*
 // EmbedDocs returns the vector embeddings for the docs,
 // implementing [llm.Embedder].
 func (c *Client) EmbedDocs(ctx context.Context, docs []llm.EmbedDoc) ([]llm.Vector, error) {
+	if err := c.ensureModel(ctx); err != nil {
+		return nil, err
+	}
 	embedURL := c.url.JoinPath(EmbedUrl) // ollama embed endpoint
 	var vecs []llm.Vector
 	for docs := range slices.Chunk(docs, maxBatch) {
@@ -92,13 +154,471 @@ func (c *Client) EmbedDocs(ctx context.Context, docs []llm.EmbedDoc) ([]llm.Vect
 		}
 		vs, err := embed(ctx, c.hc, embedURL, inputs, c.model)
 		if err != nil {
-			return nil, err
+			return vecs, err
 		}
 		vecs = append(vecs, vs...)
 	}
 	return vecs, nil
 }
 
+// Model returns the name of the model used by c,
+// implementing [llm.ContentGenerator].
+func (c *Client) Model() string {
+	return c.model
+}
+
+// DefaultOptions returns c's default generation options,
+// for use with [llm.DefaultOptions].
+// c sends every request to its own model, so model is ignored.
+func (c *Client) DefaultOptions(model string) *llm.Options {
+	return c.opts
+}
+
+// GenerateContent returns the model's response to the prompt parts,
+// implementing [llm.ContentGenerator].
+// The generation options in ctx (see [llm.WithOptions]) are sent
+// to Ollama as model options, overriding the client's defaults.
+// If schema is non-nil, it is passed to Ollama as the "format" of the
+// response, so that the model is constrained to generate JSON matching
+// the schema.
+func (c *Client) GenerateContent(ctx context.Context, schema *llm.Schema, parts []llm.Part) (string, error) {
+	resp, err := c.GenerateResponse(ctx, schema, parts)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
+
+// GenerateResponse is like [Client.GenerateContent] but also returns
+// the token counts and duration reported by Ollama,
+// implementing [llm.ResponseGenerator].
+func (c *Client) GenerateResponse(ctx context.Context, schema *llm.Schema, parts []llm.Part) (*llm.Response, error) {
+	if err := c.ensureModel(ctx); err != nil {
+		return nil, err
+	}
+	req, err := c.newGenerateRequest(ctx, schema, parts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := generate(ctx, c.hc, c.url.JoinPath(GenUrl), c.model, req)
+	if err != nil {
+		return nil, err
+	}
+	return &llm.Response{Text: resp.Response, Model: c.model, Usage: resp.usage()}, nil
+}
+
+// StreamContent returns an iterator over pieces of the model's response
+// to the prompt parts as Ollama generates them,
+// implementing [llm.ContentStreamer].
+// The schema is handled as in [Client.GenerateContent].
+func (c *Client) StreamContent(ctx context.Context, schema *llm.Schema, parts []llm.Part) iter.Seq2[*llm.Chunk, error] {
+	return func(yield func(*llm.Chunk, error) bool) {
+		if err := c.ensureModel(ctx); err != nil {
+			yield(nil, err)
+			return
+		}
+		req, err := c.newGenerateRequest(ctx, schema, parts)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		req.Stream = true
+		streamChunks(ctx, c.hc, c.url.JoinPath(GenUrl), c.model, req, yield)
+	}
+}
+
+// GenerateChat returns the model's next message in the conversation msgs,
+// implementing [llm.ChatGenerator].
+// The schema is handled as in [Client.GenerateContent].
+func (c *Client) GenerateChat(ctx context.Context, schema *llm.Schema, msgs []llm.Message) (*llm.Message, error) {
+	if err := c.ensureModel(ctx); err != nil {
+		return nil, err
+	}
+	req, err := c.newChatRequest(ctx, schema, msgs)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := generate(ctx, c.hc, c.url.JoinPath(ChatUrl), c.model, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Message == nil {
+		return nil, fmt.Errorf("ollama chat: response has no message")
+	}
+	return resp.Message.toLLM(), nil
+}
+
+// GenerateToolChat returns the model's next message in the conversation msgs,
+// allowing the model to call the tools, implementing [llm.ToolGenerator].
+// Ollama does not assign IDs to tool calls, so the returned
+// [llm.ToolCall] parts have empty IDs.
+func (c *Client) GenerateToolChat(ctx context.Context, tools []*llm.Tool, msgs []llm.Message) (*llm.Message, error) {
+	if err := c.ensureModel(ctx); err != nil {
+		return nil, err
+	}
+	req, err := c.newChatRequest(ctx, nil, msgs)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tools {
+		params := t.Parameters.JSONSchema()
+		if params == nil {
+			params = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		req.Tools = append(req.Tools, &tool{
+			Type:     "function",
+			Function: toolFunction{Name: t.Name, Description: t.Description, Parameters: params},
+		})
+	}
+	resp, err := generate(ctx, c.hc, c.url.JoinPath(ChatUrl), c.model, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Message == nil {
+		return nil, fmt.Errorf("ollama chat: response has no message")
+	}
+	return resp.Message.toLLM(), nil
+}
+
+// StreamChat returns an iterator over pieces of the model's next message
+// in the conversation msgs as Ollama generates them,
+// implementing [llm.ChatGenerator].
+// The schema is handled as in [Client.GenerateContent].
+func (c *Client) StreamChat(ctx context.Context, schema *llm.Schema, msgs []llm.Message) iter.Seq2[*llm.Chunk, error] {
+	return func(yield func(*llm.Chunk, error) bool) {
+		if err := c.ensureModel(ctx); err != nil {
+			yield(nil, err)
+			return
+		}
+		req, err := c.newChatRequest(ctx, schema, msgs)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		req.Stream = true
+		streamChunks(ctx, c.hc, c.url.JoinPath(ChatUrl), c.model, req, yield)
+	}
+}
+
+// streamChunks sends the streaming request req to u and
+// yields the text of each response as an [llm.Chunk].
+func streamChunks(ctx context.Context, hc *http.Client, u *url.URL, model string, req any, yield func(*llm.Chunk, error) bool) {
+	for resp, err := range stream(ctx, hc, u, model, req) {
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		chunk := &llm.Chunk{Text: resp.text(), Done: resp.Done}
+		if resp.Done {
+			chunk.Model = resp.Model
+			chunk.Usage = resp.usage()
+		}
+		if !yield(chunk, nil) || resp.Done {
+			return
+		}
+	}
+}
+
+// newGenerateRequest returns a new (non-streaming) request
+// for the schema and prompt parts, with the options in ctx.
+func (c *Client) newGenerateRequest(ctx context.Context, schema *llm.Schema, parts []llm.Part) (*generateRequest, error) {
+	prompt, images, err := promptText(parts)
+	if err != nil {
+		return nil, err
+	}
+	req := &generateRequest{
+		Model:  c.model,
+		Prompt: prompt,
+		Images: images,
+		Format: schema.JSONSchema(),
+	}
+	req.Options, req.KeepAlive = c.options(ctx)
+	return req, nil
+}
+
+// newChatRequest returns a new (non-streaming) chat request
+// for the schema and messages, with the options in ctx.
+func (c *Client) newChatRequest(ctx context.Context, schema *llm.Schema, msgs []llm.Message) (*chatRequest, error) {
+	req := &chatRequest{
+		Model:  c.model,
+		Format: schema.JSONSchema(),
+	}
+	for _, m := range msgs {
+		cms, err := chatMessages(m)
+		if err != nil {
+			return nil, err
+		}
+		req.Messages = append(req.Messages, cms...)
+	}
+	req.Options, req.KeepAlive = c.options(ctx)
+	return req, nil
+}
+
+// options returns the Ollama model options and keep-alive duration
+// for a request with the context ctx: the client's default options,
+// overridden by any options in ctx.
+func (c *Client) options(ctx context.Context) (map[string]any, string) {
+	o := c.opts.Merge(llm.OptionsFromContext(ctx))
+	m := make(map[string]any)
+	if o.Temperature != nil {
+		m["temperature"] = *o.Temperature
+	}
+	if o.TopP != nil {
+		m["top_p"] = *o.TopP
+	}
+	if o.Seed != nil {
+		m["seed"] = *o.Seed
+	}
+	if o.NumCtx != 0 {
+		m["num_ctx"] = o.NumCtx
+	}
+	if o.Stop != nil {
+		m["stop"] = o.Stop
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	var keepAlive string
+	if o.KeepAlive != nil {
+		keepAlive = o.KeepAlive.String()
+	}
+	return m, keepAlive
+}
+
+// chatMessages converts m to Ollama chat messages.
+// Ollama expects one message per tool result, so a
+// [llm.RoleTool] message with several [llm.ToolResult] parts
+// converts to several messages.
+func chatMessages(m llm.Message) ([]*chatMessage, error) {
+	var cms []*chatMessage
+	cm := &chatMessage{Role: string(m.Role)}
+	var texts []string
+	for _, p := range m.Parts {
+		switch p := p.(type) {
+		case llm.Text:
+			texts = append(texts, string(p))
+		case llm.Blob:
+			img, err := encodeImage(p)
+			if err != nil {
+				return nil, err
+			}
+			cm.Images = append(cm.Images, img)
+		case llm.ToolCall:
+			tc := &toolCall{}
+			tc.Function.Name = p.Name
+			tc.Function.Arguments = p.Args
+			cm.ToolCalls = append(cm.ToolCalls, tc)
+		case llm.ToolResult:
+			cms = append(cms, &chatMessage{Role: string(llm.RoleTool), Content: p.Result, ToolName: p.Name})
+		default:
+			return nil, fmt.Errorf("ollama: unsupported message part %T", p)
+		}
+	}
+	cm.Content = strings.Join(texts, "\n\n")
+	if cm.Content != "" || len(cm.Images) > 0 || len(cm.ToolCalls) > 0 || len(cms) == 0 {
+		cms = append([]*chatMessage{cm}, cms...)
+	}
+	return cms, nil
+}
+
+// toLLM converts the Ollama chat message m to an [llm.Message].
+func (m *chatMessage) toLLM() *llm.Message {
+	msg := &llm.Message{Role: llm.Role(m.Role)}
+	if m.Content != "" || len(m.ToolCalls) == 0 {
+		msg.Parts = append(msg.Parts, llm.Text(m.Content))
+	}
+	for _, tc := range m.ToolCalls {
+		msg.Parts = append(msg.Parts, llm.ToolCall{Name: tc.Function.Name, Args: tc.Function.Arguments})
+	}
+	return msg
+}
+
+// promptText converts the prompt parts into a single prompt string
+// and a list of base64-encoded images, one for each image [llm.Blob].
+func promptText(parts []llm.Part) (string, []string, error) {
+	var texts, images []string
+	for _, p := range parts {
+		switch p := p.(type) {
+		case llm.Text:
+			texts = append(texts, string(p))
+		case llm.Blob:
+			img, err := encodeImage(p)
+			if err != nil {
+				return "", nil, err
+			}
+			images = append(images, img)
+		default:
+			return "", nil, fmt.Errorf("ollama: unsupported prompt part %T", p)
+		}
+	}
+	return strings.Join(texts, "\n\n"), images, nil
+}
+
+// imageTypes are the MIME types of the images that Ollama vision models accept.
+var imageTypes = []string{"image/png", "image/jpeg"}
+
+// encodeImage returns the base64 encoding of the image in b.
+// It returns an error if b does not hold an image that Ollama accepts.
+func encodeImage(b llm.Blob) (string, error) {
+	if !slices.Contains(imageTypes, b.MIMEType) {
+		return "", fmt.Errorf("ollama: unsupported blob MIME type %q (want one of %s)", b.MIMEType, strings.Join(imageTypes, ", "))
+	}
+	return base64.StdEncoding.EncodeToString(b.Data), nil
+}
+
+// A generateRequest is a request to the Ollama generate endpoint.
+type generateRequest struct {
+	Model     string         `json:"model"`
+	Prompt    string         `json:"prompt"`
+	Images    []string       `json:"images,omitempty"` // base64-encoded images
+	Stream    bool           `json:"stream"`
+	Format    map[string]any `json:"format,omitempty"`     // JSON schema of the response
+	Options   map[string]any `json:"options,omitempty"`    // model parameters, such as "temperature"
+	KeepAlive string         `json:"keep_alive,omitempty"` // how long to keep the model loaded
+}
+
+// A chatRequest is a request to the Ollama chat endpoint.
+type chatRequest struct {
+	Model     string         `json:"model"`
+	Messages  []*chatMessage `json:"messages"`
+	Stream    bool           `json:"stream"`
+	Format    map[string]any `json:"format,omitempty"`     // JSON schema of the response
+	Options   map[string]any `json:"options,omitempty"`    // model parameters, such as "temperature"
+	KeepAlive string         `json:"keep_alive,omitempty"` // how long to keep the model loaded
+	Tools     []*tool        `json:"tools,omitempty"`
+}
+
+// A chatMessage is a single message in a chat request or response.
+type chatMessage struct {
+	Role      string      `json:"role"`
+	Content   string      `json:"content"`
+	Images    []string    `json:"images,omitempty"` // base64-encoded images
+	ToolCalls []*toolCall `json:"tool_calls,omitempty"`
+	ToolName  string      `json:"tool_name,omitempty"` // for role "tool"
+}
+
+// A tool is a tool definition in a chat request.
+type tool struct {
+	Type     string       `json:"type"` // always "function"
+	Function toolFunction `json:"function"`
+}
+
+// A toolFunction describes the function implementing a tool.
+type toolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"` // JSON schema of the arguments
+}
+
+// A toolCall is a request from the model to call a tool.
+type toolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// generate sends the non-streaming request req for model to the generate
+// or chat endpoint u and returns the decoded response.
+func generate(ctx context.Context, hc *http.Client, u *url.URL, model string, req any) (*Response, error) {
+	response, err := post(ctx, hc, u, req)
+	if err != nil {
+		return nil, transportError(ctx, model, err)
+	}
+	defer response.Body.Close()
+
+	genResp, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, transportError(ctx, model, err)
+	}
+	if err := responseError(response, model, genResp); err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.Unmarshal(genResp, &resp); err != nil {
+		return nil, &llm.Error{Backend: "ollama", Model: model, Err: err}
+	}
+	if resp.Error != "" {
+		return nil, newError(model, 0, resp.Error)
+	}
+	return &resp, nil
+}
+
+// stream sends the streaming request req to the generate or chat endpoint u
+// and returns an iterator over the responses, decoding each one
+// as soon as it arrives.
+// An error object in the stream ends the iteration with that error.
+func stream(ctx context.Context, hc *http.Client, u *url.URL, model string, req any) iter.Seq2[*Response, error] {
+	return func(yield func(*Response, error) bool) {
+		response, err := post(ctx, hc, u, req)
+		if err != nil {
+			yield(nil, transportError(ctx, model, err))
+			return
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				err = transportError(ctx, model, err)
+			} else {
+				err = responseError(response, model, body)
+			}
+			yield(nil, err)
+			return
+		}
+
+		// Ollama streams newline-delimited JSON objects.
+		dec := json.NewDecoder(response.Body)
+		for {
+			var resp Response
+			if err := dec.Decode(&resp); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF // stream ended before Done
+				}
+				yield(nil, transportError(ctx, model, err))
+				return
+			}
+			if resp.Error != "" {
+				yield(nil, newError(model, 0, resp.Error))
+				return
+			}
+			if !yield(&resp, nil) || resp.Done {
+				return
+			}
+		}
+	}
+}
+
+// post sends req, marshaled as JSON, to u.
+// The caller must close the response body.
+func post(ctx context.Context, hc *http.Client, u *url.URL, req any) (*http.Response, error) {
+	return send(ctx, hc, http.MethodPost, u, req)
+}
+
+// send sends an HTTP request with the method to u, with req, marshaled
+// as JSON, as the body. If req is nil, the request has no body.
+// The caller must close the response body.
+func send(ctx context.Context, hc *http.Client, method string, u *url.URL, req any) (*http.Response, error) {
+	var body io.Reader
+	if req != nil {
+		erj, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(erj)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	return hc.Do(request)
+}
+
 func (c *Client) Prompt(ctx context.Context, input string) ([]byte, error) {
 	u := c.url.JoinPath(GenUrl)
 	rsp, err := prompt(ctx, c.hc, u, input, c.model)
@@ -166,37 +686,80 @@ func embed(ctx context.Context, hc *http.Client, embedURL *url.URL, inputs []str
 
 	response, err := hc.Do(request)
 	if err != nil {
-		return nil, err
+		return nil, transportError(ctx, model, err)
 	}
 	defer response.Body.Close()
 
 	embResp, err := io.ReadAll(response.Body)
 	if err != nil {
-		return nil, err
+		return nil, transportError(ctx, model, err)
 	}
 
-	if err := embedError(response, embResp); err != nil {
+	if err := responseError(response, model, embResp); err != nil {
 		return nil, err
 	}
 	return embeddings(embResp)
 }
 
-// embedError extracts error from ollama's response, if any.
-func embedError(resp *http.Response, embResp []byte) error {
-	if resp.StatusCode == 200 {
+// responseError extracts the error from ollama's response for model, if any.
+func responseError(resp *http.Response, model string, body []byte) error {
+	if resp.StatusCode == http.StatusOK {
 		return nil
 	}
-	if resp.StatusCode == 400 {
-		var e struct {
-			Error string `json:"error"`
-		}
-		// ollama returns JSON with error field set for bad requests.
-		if err := json.Unmarshal(embResp, &e); err != nil {
-			return err
-		}
-		return fmt.Errorf("ollama response error: %s", e.Error)
+	// ollama returns JSON with the error field set for most errors.
+	var e struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &e); err != nil || e.Error == "" {
+		e.Error = strings.TrimSpace(string(body))
+	}
+	return newError(model, resp.StatusCode, e.Error)
+}
+
+// newError returns an [*llm.Error] for the error message msg
+// that ollama returned for model with the HTTP status code
+// (0 for errors reported mid-stream), classifying it by status and message.
+func newError(model string, status int, msg string) error {
+	return &llm.Error{
+		Backend: "ollama",
+		Model:   model,
+		Status:  status,
+		Kind:    errorKind(status, msg),
+		Message: msg,
+	}
+}
+
+// errorKind returns the [llm] sentinel error matching the
+// ollama HTTP status code and error message, or nil.
+func errorKind(status int, msg string) error {
+	m := strings.ToLower(msg)
+	switch {
+	case status == http.StatusNotFound,
+		strings.Contains(m, "not found") && strings.Contains(m, "model"):
+		return llm.ErrModelNotFound
+	case status == http.StatusTooManyRequests,
+		status == http.StatusServiceUnavailable,
+		strings.Contains(m, "server busy"):
+		return llm.ErrOverloaded
+	case strings.Contains(m, "context length"),
+		strings.Contains(m, "context window"):
+		return llm.ErrContextLength
+	case strings.Contains(m, "invalid format"),
+		strings.Contains(m, "schema"):
+		return llm.ErrInvalidSchema
+	case status == 499: // client closed request
+		return llm.ErrCanceled
+	}
+	return nil
+}
+
+// transportError returns the error for a request for model that failed
+// without a response from ollama, reporting cancellation as [llm.ErrCanceled].
+func transportError(ctx context.Context, model string, err error) error {
+	if cerr := llm.CanceledError(ctx, "ollama", model); cerr != nil {
+		return cerr
 	}
-	return fmt.Errorf("ollama response error: %s", resp.Status)
+	return &llm.Error{Backend: "ollama", Model: model, Err: err}
 }
 
 func embeddings(embResp []byte) ([]llm.Vector, error) {
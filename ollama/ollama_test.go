@@ -7,9 +7,16 @@ package ollama
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"image"
+	"image/png"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/superryanguo/ryai/httprr"
 	"github.com/superryanguo/ryai/llm"
@@ -37,14 +44,14 @@ func init() {
 	}
 }
 
-func newTestClient(t *testing.T, rrfile string) *Client {
+func newTestClient(t *testing.T, rrfile, model string) *Client {
 	check := testutil.Checker(t)
 	lg := testutil.Slogger(t)
 
 	rr, err := httprr.Open(rrfile, http.DefaultTransport)
 	check(err)
 
-	c, err := NewClient(lg, rr.Client(), "", "mxbai-embed-large")
+	c, err := NewClient(lg, rr.Client(), "", model)
 	check(err)
 
 	return c
@@ -53,7 +60,7 @@ func newTestClient(t *testing.T, rrfile string) *Client {
 func TestEmbedBatch(t *testing.T) {
 	ctx := context.Background()
 	check := testutil.Checker(t)
-	c := newTestClient(t, "testdata/embedbatch.httprr")
+	c := newTestClient(t, "testdata/embedbatch.httprr", DefaultEmbeddingModel)
 	vecs, err := c.EmbedDocs(ctx, docs)
 	check(err)
 	if len(vecs) != len(docs) {
@@ -89,7 +96,7 @@ func TestEmbedBatch(t *testing.T) {
 func TestBigBatch(t *testing.T) {
 	ctx := context.Background()
 	check := testutil.Checker(t)
-	c := newTestClient(t, "testdata/bigbatch.httprr")
+	c := newTestClient(t, "testdata/bigbatch.httprr", DefaultEmbeddingModel)
 	var docs []llm.EmbedDoc
 
 	for i := range 1025 {
@@ -102,3 +109,347 @@ func TestBigBatch(t *testing.T) {
 		t.Fatalf("len(vecs) = %d, but len(docs) = %d", len(vecs), len(docs))
 	}
 }
+
+func TestGenerateContent(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/generate.httprr", DefaultGenModel)
+
+	if m := c.Model(); m != DefaultGenModel {
+		t.Errorf("Model() = %q, want %q", m, DefaultGenModel)
+	}
+
+	t.Run("text", func(t *testing.T) {
+		resp, err := c.GenerateResponse(ctx, nil, []llm.Part{llm.Text("What is the capital of France?")})
+		check(err)
+		const want = "The capital of France is Paris."
+		if resp.Text != want || resp.Model != DefaultGenModel {
+			t.Errorf("GenerateResponse() = %q from %q, want %q from %q", resp.Text, resp.Model, want, DefaultGenModel)
+		}
+		wantUsage := llm.Usage{InputTokens: 16, OutputTokens: 8, Latency: 512345678 * time.Nanosecond}
+		if resp.Usage == nil || *resp.Usage != wantUsage {
+			t.Errorf("GenerateResponse() usage = %+v, want %+v", resp.Usage, wantUsage)
+		}
+	})
+
+	t.Run("schema", func(t *testing.T) {
+		schema := &llm.Schema{
+			Type: llm.TypeObject,
+			Properties: map[string]*llm.Schema{
+				"name": {Type: llm.TypeString},
+				"age":  {Type: llm.TypeInteger},
+			},
+			Required: []string{"name", "age"},
+		}
+		ctx := llm.WithOptions(ctx, &llm.Options{Temperature: llm.Ptr[float32](0)})
+		resp, err := c.GenerateContent(ctx, schema, []llm.Part{llm.Text("Alice is 30 years old."), llm.Text("Describe Alice as JSON.")})
+		check(err)
+		var person struct {
+			Name string `json:"name"`
+			Age  int    `json:"age"`
+		}
+		if err := json.Unmarshal([]byte(resp), &person); err != nil {
+			t.Fatalf("GenerateContent() = %q, not JSON: %v", resp, err)
+		}
+		if person.Name != "Alice" || person.Age != 30 {
+			t.Errorf("GenerateContent() = %+v, want {Alice 30}", person)
+		}
+	})
+
+	t.Run("blob", func(t *testing.T) {
+		_, err := c.GenerateContent(ctx, nil, []llm.Part{llm.Blob{MIMEType: "video/mp4"}})
+		if err == nil {
+			t.Errorf("GenerateContent(blob) succeeded, want error")
+		}
+	})
+}
+
+func TestStreamContent(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, "testdata/stream.httprr", DefaultGenModel)
+
+	var chunks []string
+	var last *llm.Chunk
+	for chunk, err := range c.StreamContent(ctx, nil, []llm.Part{llm.Text("What is the capital of France?")}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, chunk.Text)
+		last = chunk
+	}
+	if len(chunks) < 2 {
+		t.Errorf("StreamContent() returned %d chunks, want several", len(chunks))
+	}
+	const want = "The capital of France is Paris."
+	if got := strings.Join(chunks, ""); got != want {
+		t.Errorf("StreamContent() = %q, want %q", got, want)
+	}
+	if last == nil || !last.Done || last.Model != DefaultGenModel {
+		t.Errorf("StreamContent() last chunk = %+v, want Done, Model=%s", last, DefaultGenModel)
+	}
+	if last != nil && (last.Usage == nil || last.Usage.InputTokens != 16 || last.Usage.OutputTokens != 8) {
+		t.Errorf("StreamContent() last chunk usage = %+v, want 16 input, 8 output tokens", last.Usage)
+	}
+}
+
+func TestChat(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/chat.httprr", DefaultGenModel)
+
+	msgs := []llm.Message{
+		{Role: llm.RoleSystem, Parts: []llm.Part{llm.Text("You are a concise geography assistant.")}},
+		{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("What is the capital of France?")}},
+	}
+	msg, err := c.GenerateChat(ctx, nil, msgs)
+	check(err)
+	if want := "The capital of France is Paris."; msg.Role != llm.RoleAssistant || msg.Text() != want {
+		t.Fatalf("GenerateChat() = %s %q, want %s %q", msg.Role, msg.Text(), llm.RoleAssistant, want)
+	}
+
+	// The follow-up question only makes sense with the history.
+	msgs = append(msgs, *msg, llm.Message{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("And what is its population?")}})
+	var chunks []string
+	for chunk, err := range c.StreamChat(ctx, nil, msgs) {
+		check(err)
+		chunks = append(chunks, chunk.Text)
+	}
+	if got, want := strings.Join(chunks, ""), "Paris has about 2.1 million inhabitants."; got != want {
+		t.Errorf("StreamChat() = %q, want %q", got, want)
+	}
+}
+
+func TestToolChat(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/tools.httprr", DefaultGenModel)
+
+	weather := &llm.Tool{
+		Name:        "get_weather",
+		Description: "Get the current weather in a city.",
+		Parameters: &llm.Schema{
+			Type:       llm.TypeObject,
+			Properties: map[string]*llm.Schema{"city": {Type: llm.TypeString, Description: "The name of the city."}},
+			Required:   []string{"city"},
+		},
+	}
+	var cities []string
+	funcs := map[string]llm.ToolFunc{
+		"get_weather": func(_ context.Context, args json.RawMessage) (string, error) {
+			var a struct{ City string }
+			if err := json.Unmarshal(args, &a); err != nil {
+				return "", err
+			}
+			cities = append(cities, a.City)
+			return "18°C and sunny", nil
+		},
+	}
+	msgs := []llm.Message{{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("What is the weather like in Paris?")}}}
+	out, err := llm.RunTools(ctx, c, []*llm.Tool{weather}, funcs, msgs, 3)
+	check(err)
+	if len(cities) != 1 || cities[0] != "Paris" {
+		t.Errorf("get_weather called for %v, want [Paris]", cities)
+	}
+	const want = "It is 18°C and sunny in Paris right now."
+	if got := out[len(out)-1].Text(); got != want {
+		t.Errorf("answer = %q, want %q", got, want)
+	}
+}
+
+func TestImages(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/images.httprr", "llava")
+
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	var buf bytes.Buffer
+	check(png.Encode(&buf, img))
+	screenshot := llm.Blob{MIMEType: "image/png", Data: buf.Bytes()}
+	const want = "The dashboard shows a latency spike at 14:00 (1 image)."
+
+	resp, err := c.GenerateContent(ctx, nil, []llm.Part{llm.Text("What does this dashboard show?"), screenshot})
+	check(err)
+	if resp != want {
+		t.Errorf("GenerateContent() = %q, want %q", resp, want)
+	}
+
+	msg, err := c.GenerateChat(ctx, nil, []llm.Message{{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("What does this dashboard show?"), screenshot}}})
+	check(err)
+	if msg.Text() != want {
+		t.Errorf("GenerateChat() = %q, want %q", msg.Text(), want)
+	}
+
+	gif := llm.Blob{MIMEType: "image/gif", Data: []byte("GIF89a")}
+	if _, err := c.GenerateContent(ctx, nil, []llm.Part{gif}); err == nil || !strings.Contains(err.Error(), "image/gif") {
+		t.Errorf("GenerateContent(gif) error = %v, want unsupported MIME type", err)
+	}
+	if _, err := c.GenerateChat(ctx, nil, []llm.Message{{Role: llm.RoleUser, Parts: []llm.Part{gif}}}); err == nil {
+		t.Errorf("GenerateChat(gif) succeeded, want error")
+	}
+}
+
+func TestBackend(t *testing.T) {
+	lg := testutil.Slogger(t)
+	b, err := llm.NewBackend(lg, BackendName, &llm.BackendConfig{
+		GenModel: "llava",
+		Options:  map[string]string{"temperature": "0.5"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := b.Embedder.(*Client).model; m != DefaultEmbeddingModel {
+		t.Errorf("embedding model = %q, want %q", m, DefaultEmbeddingModel)
+	}
+	g := b.Generator.(*Client)
+	if g.Model() != "llava" || g.opts.Temperature == nil || *g.opts.Temperature != 0.5 {
+		t.Errorf("generator model = %q, options %+v, want llava, temperature 0.5", g.Model(), g.opts)
+	}
+
+	_, err = llm.NewBackend(lg, BackendName, &llm.BackendConfig{Options: map[string]string{"color": "blue"}})
+	if err == nil {
+		t.Errorf("NewBackend with unknown option succeeded, want error")
+	}
+}
+
+func TestOptions(t *testing.T) {
+	c := newTestClient(t, "testdata/generate.httprr", DefaultGenModel) // no requests sent
+	c.opts = &llm.Options{Temperature: llm.Ptr[float32](0.5), NumCtx: 4096}
+	ctx := context.Background()
+
+	req, err := c.newGenerateRequest(ctx, nil, []llm.Part{llm.Text("hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(marshal(t, req)), `{"model":"llama3.2:3b","prompt":"hi","stream":false,"options":{"num_ctx":4096,"temperature":0.5}}`; got != want {
+		t.Errorf("default options request:\n%s\nwant:\n%s", got, want)
+	}
+
+	ctx = llm.WithOptions(ctx, &llm.Options{
+		Temperature: llm.Ptr[float32](0),
+		Seed:        llm.Ptr(42),
+		Stop:        []string{"\n\n"},
+		KeepAlive:   llm.Ptr(10 * time.Minute),
+	})
+	creq, err := c.newChatRequest(ctx, nil, []llm.Message{{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("hi")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(marshal(t, creq)), `{"model":"llama3.2:3b","messages":[{"role":"user","content":"hi"}],"stream":false,"options":{"num_ctx":4096,"seed":42,"stop":["\n\n"],"temperature":0},"keep_alive":"10m0s"}`; got != want {
+		t.Errorf("context options request:\n%s\nwant:\n%s", got, want)
+	}
+	if *c.opts.Temperature != 0.5 {
+		t.Errorf("request options changed client defaults")
+	}
+}
+
+func marshal(t *testing.T, x any) []byte {
+	js, err := json.Marshal(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return js
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	lg := testutil.Slogger(t)
+
+	rr, err := httprr.Open("testdata/errors.httprr", http.DefaultTransport)
+	check(err)
+	client := func(model string) *Client {
+		c, err := NewClient(lg, rr.Client(), "", model)
+		check(err)
+		return c
+	}
+	prompt := []llm.Part{llm.Text("What is the capital of France?")}
+
+	for _, tc := range []struct {
+		name   string
+		call   func() error
+		kind   error
+		status int
+	}{
+		{"not found", func() error {
+			_, err := client("missing").GenerateContent(ctx, nil, prompt)
+			return err
+		}, llm.ErrModelNotFound, http.StatusNotFound},
+		{"overloaded", func() error {
+			_, err := client("busy").GenerateChat(ctx, nil, []llm.Message{{Role: llm.RoleUser, Parts: prompt}})
+			return err
+		}, llm.ErrOverloaded, http.StatusServiceUnavailable},
+		{"context length", func() error {
+			_, err := client("tiny-ctx").EmbedDocs(ctx, docs)
+			return err
+		}, llm.ErrContextLength, http.StatusBadRequest},
+		{"invalid schema", func() error {
+			_, err := client(DefaultGenModel).GenerateContent(ctx, &llm.Schema{Description: "no type"}, prompt)
+			return err
+		}, llm.ErrInvalidSchema, http.StatusBadRequest},
+		{"in stream", func() error {
+			for _, err := range client(DefaultGenModel).StreamContent(ctx, nil, []llm.Part{llm.Text("crash the model")}) {
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.call()
+			var e *llm.Error
+			if !errors.As(err, &e) {
+				t.Fatalf("err = %v, want *llm.Error", err)
+			}
+			if tc.kind != nil && !errors.Is(err, tc.kind) {
+				t.Errorf("err = %v, want %v", err, tc.kind)
+			}
+			if e.Kind != tc.kind || e.Status != tc.status || e.Backend != "ollama" || e.Message == "" {
+				t.Errorf("err = %#v, want kind %v, status %d", e, tc.kind, tc.status)
+			}
+		})
+	}
+
+	t.Run("bad response", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "not json")
+		}))
+		defer srv.Close()
+		c, err := NewClient(lg, srv.Client(), srv.URL, DefaultGenModel)
+		check(err)
+		_, err = c.GenerateContent(ctx, nil, prompt)
+		var e *llm.Error
+		if !errors.As(err, &e) || e.Backend != "ollama" || e.Model != DefaultGenModel {
+			t.Errorf("err = %#v, want *llm.Error", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		// Use a real transport: httprr ignores cancellation.
+		c, err := NewClient(lg, http.DefaultClient, "http://127.0.0.1:1", DefaultGenModel)
+		check(err)
+		_, err = c.GenerateContent(ctx, nil, prompt)
+		if !errors.Is(err, llm.ErrCanceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want %v and %v", err, llm.ErrCanceled, context.Canceled)
+		}
+		if llm.IsTransient(err) {
+			t.Errorf("IsTransient(%v) = true, want false", err)
+		}
+	})
+}
+
+func TestAssembleRsp(t *testing.T) {
+	ok := `{"model":"m","response":"Hello, "}` + "\n" + `{"model":"m","response":"world","done":true}` + "\n"
+	s, err := AssembleRsp([]byte(ok))
+	if err != nil || s != "Hello, world" {
+		t.Errorf("AssembleRsp() = %q, %v, want %q, nil", s, err, "Hello, world")
+	}
+
+	bad := `{"model":"m","response":"Hello, "}` + "\n" + `{"error":"model \"m\" not found, try pulling it first"}` + "\n"
+	s, err = AssembleRsp([]byte(bad))
+	if s != "Hello, " || !errors.Is(err, llm.ErrModelNotFound) {
+		t.Errorf("AssembleRsp() = %q, %v, want %q, %v", s, err, "Hello, ", llm.ErrModelNotFound)
+	}
+}
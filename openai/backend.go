@@ -0,0 +1,44 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/superryanguo/ryai/llm"
+)
+
+// BackendName is the name of the OpenAI backend in the [llm.NewBackend] registry.
+const BackendName = "openai"
+
+func init() {
+	llm.RegisterBackend(BackendName, newBackend)
+}
+
+// newBackend returns an [llm.Backend] using the OpenAI-compatible server in cfg.
+// OpenAI-compatible servers have no common default models,
+// so both models must be set.
+// The options are the default generation options; see [llm.ParseOptions].
+func newBackend(lg *slog.Logger, cfg *llm.BackendConfig) (*llm.Backend, error) {
+	if cfg.EmbedModel == "" || cfg.GenModel == "" {
+		return nil, errors.New("openai backend: embedding and generative models must be set")
+	}
+	e, err := NewClient(lg, cfg.Secret, cfg.HTTP, cfg.Server, cfg.EmbedModel)
+	if err != nil {
+		return nil, err
+	}
+	g, err := NewClient(lg, cfg.Secret, cfg.HTTP, cfg.Server, cfg.GenModel)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := llm.ParseOptions(cfg.Options)
+	if err != nil {
+		return nil, fmt.Errorf("openai backend: %w", err)
+	}
+	g.opts = opts
+	return &llm.Backend{Embedder: e, Generator: g}, nil
+}
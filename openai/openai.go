@@ -0,0 +1,485 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package openai implements access to servers speaking the OpenAI API,
+// such as OpenAI itself, vLLM and the llama.cpp server.
+//
+// [Client] implements [llm.Embedder], [llm.ContentGenerator], [llm.ResponseGenerator],
+// [llm.ContentStreamer] and [llm.ChatGenerator],
+// using the /v1/embeddings and /v1/chat/completions endpoints.
+// Use [NewClient] to connect.
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/secret"
+)
+
+const (
+	DefaultServer  = "https://api.openai.com"
+	EmbedUrl       = "/v1/embeddings"
+	ChatUrl        = "/v1/chat/completions"
+	maxBatch       = 512 // maximum number of inputs per embeddings request
+	maxStreamToken = 1 << 20
+)
+
+// A Client represents a connection to an OpenAI-compatible server.
+type Client struct {
+	slog  *slog.Logger
+	hc    *http.Client
+	url   *url.URL // url of the server, without the /v1 path
+	key   string   // API key; empty if the server needs none
+	model string
+	opts  *llm.Options // default generation options; nil means the server defaults
+}
+
+// NewClient returns a connection to the OpenAI-compatible server.
+// If server is empty, it is assumed to be [DefaultServer].
+// The model is the model name to use for embedding or generation,
+// such as "text-embedding-3-small" or "gpt-4o-mini".
+//
+// The API key is the secret in sdb named by the server's host (and port),
+// such as "api.openai.com" or "127.0.0.1:8000".
+// If the secret has the form "user:key", as secrets read from .netrc do,
+// only the key is used.
+// Servers like vLLM and llama.cpp need no key by default,
+// so a missing secret is not an error.
+func NewClient(lg *slog.Logger, sdb secret.DB, hc *http.Client, server, model string) (*Client, error) {
+	if server == "" {
+		server = DefaultServer
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := sdb.Get(u.Host)
+	if ok {
+		// If key is from .netrc, ignore the user name.
+		if _, pass, found := strings.Cut(key, ":"); found {
+			key = pass
+		}
+	} else {
+		lg.Debug("openai: no API key", "host", u.Host)
+	}
+	return &Client{slog: lg, hc: hc, url: u, key: key, model: model}, nil
+}
+
+// EmbedDocs returns the vector embeddings for the docs,
+// implementing [llm.Embedder].
+func (c *Client) EmbedDocs(ctx context.Context, docs []llm.EmbedDoc) ([]llm.Vector, error) {
+	var vecs []llm.Vector
+	for docs := range slices.Chunk(docs, maxBatch) {
+		req := &embedRequest{Model: c.model}
+		for _, doc := range docs {
+			// The embeddings endpoint has no separate title.
+			req.Input = append(req.Input, doc.Title+"\n\n"+doc.Text)
+		}
+		var resp embedResponse
+		if err := c.call(ctx, EmbedUrl, req, &resp); err != nil {
+			return vecs, err
+		}
+		if len(resp.Data) != len(docs) {
+			return vecs, fmt.Errorf("openai embed: got %d embeddings for %d inputs", len(resp.Data), len(docs))
+		}
+		// The embeddings are not necessarily in input order.
+		slices.SortFunc(resp.Data, func(x, y embedding) int { return x.Index - y.Index })
+		for _, e := range resp.Data {
+			vecs = append(vecs, e.Embedding)
+		}
+	}
+	return vecs, nil
+}
+
+// Model returns the name of the model used by c,
+// implementing [llm.ContentGenerator].
+func (c *Client) Model() string {
+	return c.model
+}
+
+// DefaultOptions returns c's default generation options,
+// for use with [llm.DefaultOptions].
+// c sends every request to its own model, so model is ignored.
+func (c *Client) DefaultOptions(model string) *llm.Options {
+	return c.opts
+}
+
+// GenerateContent returns the model's response to the prompt parts,
+// implementing [llm.ContentGenerator].
+// The parts are sent as a single user message.
+// If schema is non-nil, it is sent as a JSON schema response format,
+// so that the model is constrained to generate JSON matching the schema.
+func (c *Client) GenerateContent(ctx context.Context, schema *llm.Schema, parts []llm.Part) (string, error) {
+	resp, err := c.GenerateResponse(ctx, schema, parts)
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
+
+// GenerateResponse is like [Client.GenerateContent] but also returns
+// the model that responded and the token counts reported by the server,
+// implementing [llm.ResponseGenerator].
+// The server does not report the latency, which [llm.Generate] measures.
+func (c *Client) GenerateResponse(ctx context.Context, schema *llm.Schema, parts []llm.Part) (*llm.Response, error) {
+	resp, err := c.chat(ctx, schema, []llm.Message{{Role: llm.RoleUser, Parts: parts}})
+	if err != nil {
+		return nil, err
+	}
+	model := resp.Model
+	if model == "" {
+		model = c.model
+	}
+	return &llm.Response{
+		Text:  resp.Choices[0].Message.Content,
+		Model: model,
+		Usage: &llm.Usage{InputTokens: resp.Usage.PromptTokens, OutputTokens: resp.Usage.CompletionTokens},
+	}, nil
+}
+
+// StreamContent returns an iterator over pieces of the model's response
+// to the prompt parts as the server generates them,
+// implementing [llm.ContentStreamer].
+// The parts and schema are handled as in [Client.GenerateContent].
+func (c *Client) StreamContent(ctx context.Context, schema *llm.Schema, parts []llm.Part) iter.Seq2[*llm.Chunk, error] {
+	return c.StreamChat(ctx, schema, []llm.Message{{Role: llm.RoleUser, Parts: parts}})
+}
+
+// GenerateChat returns the model's next message in the conversation msgs,
+// implementing [llm.ChatGenerator].
+// The schema is handled as in [Client.GenerateContent].
+func (c *Client) GenerateChat(ctx context.Context, schema *llm.Schema, msgs []llm.Message) (*llm.Message, error) {
+	resp, err := c.chat(ctx, schema, msgs)
+	if err != nil {
+		return nil, err
+	}
+	m := resp.Choices[0].Message
+	return &llm.Message{Role: llm.Role(m.Role), Parts: []llm.Part{llm.Text(m.Content)}}, nil
+}
+
+// chat sends a non-streaming chat request and returns the response,
+// which has at least one choice.
+func (c *Client) chat(ctx context.Context, schema *llm.Schema, msgs []llm.Message) (*chatResponse, error) {
+	req, err := c.newChatRequest(ctx, schema, msgs)
+	if err != nil {
+		return nil, err
+	}
+	var resp chatResponse
+	if err := c.call(ctx, ChatUrl, req, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("openai chat: response has no choices")
+	}
+	return &resp, nil
+}
+
+// StreamChat returns an iterator over pieces of the model's next message
+// in the conversation msgs as the server generates them,
+// implementing [llm.ChatGenerator].
+// The schema is handled as in [Client.GenerateContent].
+func (c *Client) StreamChat(ctx context.Context, schema *llm.Schema, msgs []llm.Message) iter.Seq2[*llm.Chunk, error] {
+	return func(yield func(*llm.Chunk, error) bool) {
+		req, err := c.newChatRequest(ctx, schema, msgs)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		req.Stream = true
+		response, err := c.post(ctx, ChatUrl, req)
+		if err != nil {
+			yield(nil, c.transportError(ctx, err))
+			return
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				err = c.transportError(ctx, err)
+			} else {
+				err = c.responseError(response, body)
+			}
+			yield(nil, err)
+			return
+		}
+
+		// The server sends server-sent events, one per line,
+		// each holding a JSON chunk, followed by "data: [DONE]".
+		model := c.model
+		scan := bufio.NewScanner(response.Body)
+		scan.Buffer(nil, maxStreamToken)
+		for scan.Scan() {
+			data, ok := strings.CutPrefix(scan.Text(), "data:")
+			if !ok {
+				continue // blank line or comment
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				yield(&llm.Chunk{Done: true, Model: model}, nil)
+				return
+			}
+			var resp chatResponse
+			if err := json.Unmarshal([]byte(data), &resp); err != nil {
+				yield(nil, &llm.Error{Backend: "openai", Model: c.model, Err: fmt.Errorf("decoding stream: %w", err)})
+				return
+			}
+			if resp.Model != "" {
+				model = resp.Model
+			}
+			if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+				continue
+			}
+			if !yield(&llm.Chunk{Text: resp.Choices[0].Delta.Content}, nil) {
+				return
+			}
+		}
+		err = scan.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF // stream ended before [DONE]
+		}
+		yield(nil, c.transportError(ctx, err))
+	}
+}
+
+// newChatRequest returns a new (non-streaming) chat request
+// for the schema and messages, with the client's default options
+// overridden by the options in ctx.
+// The server has no equivalent of the NumCtx and KeepAlive options,
+// which are ignored.
+func (c *Client) newChatRequest(ctx context.Context, schema *llm.Schema, msgs []llm.Message) (*chatRequest, error) {
+	o := c.opts.Merge(llm.OptionsFromContext(ctx))
+	req := &chatRequest{
+		Model:       c.model,
+		Temperature: o.Temperature,
+		TopP:        o.TopP,
+		Seed:        o.Seed,
+		Stop:        o.Stop,
+	}
+	if schema != nil {
+		req.ResponseFormat = &responseFormat{
+			Type:       "json_schema",
+			JSONSchema: &jsonSchema{Name: "response", Schema: schema.JSONSchema()},
+		}
+	}
+	for _, m := range msgs {
+		cm, err := newChatMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		req.Messages = append(req.Messages, cm)
+	}
+	return req, nil
+}
+
+// newChatMessage converts m to an OpenAI chat message.
+// A message with only text parts has a string content;
+// a message with images has a list of content parts.
+func newChatMessage(m llm.Message) (*chatMessage, error) {
+	var texts []string
+	var content []contentPart
+	for _, p := range m.Parts {
+		switch p := p.(type) {
+		case llm.Text:
+			texts = append(texts, string(p))
+			content = append(content, contentPart{Type: "text", Text: string(p)})
+		case llm.Blob:
+			if !strings.HasPrefix(p.MIMEType, "image/") {
+				return nil, fmt.Errorf("openai: unsupported blob MIME type %q (want an image)", p.MIMEType)
+			}
+			u := "data:" + p.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+			content = append(content, contentPart{Type: "image_url", ImageURL: &imageURL{URL: u}})
+		default:
+			return nil, fmt.Errorf("openai: unsupported message part %T", p)
+		}
+	}
+	cm := &chatMessage{Role: string(m.Role)}
+	if len(content) == len(texts) {
+		cm.Content = strings.Join(texts, "\n\n")
+	} else {
+		cm.Content = content
+	}
+	return cm, nil
+}
+
+// An embedRequest is a request to the embeddings endpoint.
+type embedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// An embedResponse is a response from the embeddings endpoint.
+type embedResponse struct {
+	Data []embedding `json:"data"`
+}
+
+// An embedding is a single embedding in an [embedResponse].
+type embedding struct {
+	Index     int        `json:"index"` // index of the input
+	Embedding llm.Vector `json:"embedding"`
+}
+
+// A chatRequest is a request to the chat completions endpoint.
+type chatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []*chatMessage  `json:"messages"`
+	Stream         bool            `json:"stream,omitempty"`
+	Temperature    *float32        `json:"temperature,omitempty"`
+	TopP           *float32        `json:"top_p,omitempty"`
+	Seed           *int            `json:"seed,omitempty"`
+	Stop           []string        `json:"stop,omitempty"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+// A chatMessage is a single message in a chat request or response.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"` // string or []contentPart
+}
+
+// A contentPart is part of the content of a multimodal [chatMessage].
+type contentPart struct {
+	Type     string    `json:"type"` // "text" or "image_url"
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+// An imageURL is the location (usually a data: URL) of an image.
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+// A responseFormat constrains the format of the response.
+type responseFormat struct {
+	Type       string      `json:"type"` // "json_schema"
+	JSONSchema *jsonSchema `json:"json_schema,omitempty"`
+}
+
+// A jsonSchema is a named JSON schema for a [responseFormat].
+type jsonSchema struct {
+	Name   string         `json:"name"`
+	Schema map[string]any `json:"schema"`
+}
+
+// A chatResponse is a response from the chat completions endpoint,
+// or a chunk of a streamed response.
+type chatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"` // set in streamed chunks
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// call sends req to the endpoint and decodes the JSON response into resp.
+func (c *Client) call(ctx context.Context, endpoint string, req, resp any) error {
+	response, err := c.post(ctx, endpoint, req)
+	if err != nil {
+		return c.transportError(ctx, err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return c.transportError(ctx, err)
+	}
+	if err := c.responseError(response, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, resp)
+}
+
+// post sends req, marshaled as JSON, to the endpoint.
+// The caller must close the response body.
+func (c *Client) post(ctx context.Context, endpoint string, req any) (*http.Response, error) {
+	js, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url.JoinPath(endpoint).String(), bytes.NewReader(js))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	if c.key != "" {
+		request.Header.Set("Authorization", "Bearer "+c.key)
+	}
+	return c.hc.Do(request)
+}
+
+// responseError extracts the error from the server's response, if any,
+// as an [*llm.Error].
+func (c *Client) responseError(resp *http.Response, body []byte) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	// OpenAI-compatible servers return JSON with an error object.
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    any    `json:"code"` // string for OpenAI, number for some servers
+		} `json:"error"`
+	}
+	msg := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &e); err == nil && e.Error.Message != "" {
+		msg = e.Error.Message
+	}
+	code, _ := e.Error.Code.(string)
+	return &llm.Error{
+		Backend: "openai",
+		Model:   c.model,
+		Status:  resp.StatusCode,
+		Kind:    errorKind(resp.StatusCode, code, msg),
+		Message: msg,
+	}
+}
+
+// errorKind returns the [llm] sentinel error matching the
+// HTTP status code, error code and message, or nil.
+func errorKind(status int, code, msg string) error {
+	m := strings.ToLower(msg)
+	switch {
+	case code == "model_not_found", status == http.StatusNotFound:
+		return llm.ErrModelNotFound
+	case code == "context_length_exceeded", strings.Contains(m, "context length"):
+		return llm.ErrContextLength
+	case status == http.StatusTooManyRequests, status == http.StatusServiceUnavailable:
+		return llm.ErrOverloaded
+	case strings.Contains(m, "response_format"), strings.Contains(m, "json_schema"):
+		return llm.ErrInvalidSchema
+	}
+	return nil
+}
+
+// transportError returns the error for a request that failed
+// without a response from the server,
+// reporting cancellation as [llm.ErrCanceled].
+func (c *Client) transportError(ctx context.Context, err error) error {
+	if cerr := llm.CanceledError(ctx, "openai", c.model); cerr != nil {
+		return cerr
+	}
+	return &llm.Error{Backend: "openai", Model: c.model, Err: err}
+}
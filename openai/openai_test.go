@@ -0,0 +1,276 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/superryanguo/ryai/httprr"
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/secret"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+// testServer is the OpenAI-compatible server used to record the tests.
+const testServer = "http://127.0.0.1:8000"
+
+func newTestClient(t *testing.T, rrfile, model string) *Client {
+	return newTestClientKey(t, rrfile, model, "user:sk-test")
+}
+
+// newTestClientKey is like newTestClient but uses the given API key.
+func newTestClientKey(t *testing.T, rrfile, model, key string) *Client {
+	check := testutil.Checker(t)
+	lg := testutil.Slogger(t)
+
+	rr, err := httprr.Open(rrfile, http.DefaultTransport)
+	check(err)
+	// Keep API keys out of the recordings.
+	rr.ScrubReq(func(req *http.Request) error {
+		req.Header.Del("Authorization")
+		return nil
+	})
+
+	sdb := secret.Map{"127.0.0.1:8000": key}
+	c, err := NewClient(lg, sdb, rr.Client(), testServer, model)
+	check(err)
+	return c
+}
+
+func TestNewClient(t *testing.T) {
+	lg := testutil.Slogger(t)
+	c, err := NewClient(lg, secret.Map{"api.openai.com": "sk-secret"}, http.DefaultClient, "", "gpt-4o-mini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.url.String() != DefaultServer || c.key != "sk-secret" {
+		t.Errorf("NewClient() url=%s key=%q, want %s %q", c.url, c.key, DefaultServer, "sk-secret")
+	}
+	c, err = NewClient(lg, secret.Empty(), http.DefaultClient, "http://localhost:8080", "llama")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.key != "" {
+		t.Errorf("NewClient() without secret key=%q, want empty", c.key)
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/embed.httprr", "text-embedding-3-small")
+
+	docs := []llm.EmbedDoc{{Text: "for loops"}, {Title: "Go", Text: "goroutines"}, {Text: "x"}}
+	vecs, err := c.EmbedDocs(ctx, docs)
+	check(err)
+	if len(vecs) != len(docs) {
+		t.Fatalf("len(vecs) = %d, but len(docs) = %d", len(vecs), len(docs))
+	}
+	// The test server returns the embeddings in reverse order,
+	// with the input length as the first element.
+	for i, d := range docs {
+		if n := len(d.Title + "\n\n" + d.Text); vecs[i][0] != float32(n) {
+			t.Errorf("vecs[%d][0] = %v, want %d", i, vecs[i][0], n)
+		}
+	}
+}
+
+func TestGenerateContent(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/generate.httprr", "llama-3.2-3b-instruct")
+
+	r, err := c.GenerateResponse(ctx, nil, []llm.Part{llm.Text("What is the capital of France?")})
+	check(err)
+	if want := "The capital of France is Paris."; r.Text != want {
+		t.Errorf("GenerateResponse() = %q, want %q", r.Text, want)
+	}
+	if r.Model != "llama-3.2-3b-instruct" || r.Usage.InputTokens != 17 || r.Usage.OutputTokens != 6 {
+		t.Errorf("GenerateResponse() model %q usage %+v, want llama-3.2-3b-instruct, 17 input, 6 output tokens", r.Model, r.Usage)
+	}
+
+	schema := &llm.Schema{
+		Type: llm.TypeObject,
+		Properties: map[string]*llm.Schema{
+			"name": {Type: llm.TypeString},
+			"age":  {Type: llm.TypeInteger},
+		},
+		Required: []string{"name", "age"},
+	}
+	ctx = llm.WithOptions(ctx, &llm.Options{Temperature: llm.Ptr[float32](0)})
+	resp, err := c.GenerateContent(ctx, schema, []llm.Part{llm.Text("Describe Alice, who is 30, as JSON.")})
+	check(err)
+	var person struct {
+		Name string `json:"name"`
+		Age  int    `json:"age"`
+	}
+	if err := json.Unmarshal([]byte(resp), &person); err != nil {
+		t.Fatalf("GenerateContent() = %q, not JSON: %v", resp, err)
+	}
+	if person.Name != "Alice" || person.Age != 30 {
+		t.Errorf("GenerateContent() = %+v, want {Alice 30}", person)
+	}
+
+	msg, err := c.GenerateChat(ctx, nil, []llm.Message{{Role: llm.RoleUser, Parts: []llm.Part{
+		llm.Text("What does this dashboard show?"),
+		llm.Blob{MIMEType: "image/png", Data: []byte("\x89PNG")},
+	}}})
+	check(err)
+	if want := "I see 2 parts including an image."; msg.Text() != want {
+		t.Errorf("GenerateChat(image) = %q, want %q", msg.Text(), want)
+	}
+
+	if _, err := c.GenerateContent(ctx, nil, []llm.Part{llm.Blob{MIMEType: "application/pdf"}}); err == nil {
+		t.Errorf("GenerateContent(pdf) succeeded, want error")
+	}
+}
+
+func TestStreamChat(t *testing.T) {
+	ctx := context.Background()
+	check := testutil.Checker(t)
+	c := newTestClient(t, "testdata/stream.httprr", "llama-3.2-3b-instruct")
+
+	msgs := []llm.Message{
+		{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("What is the capital of France?")}},
+		{Role: llm.RoleAssistant, Parts: []llm.Part{llm.Text("The capital of France is Paris.")}},
+		{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("And what is its population?")}},
+	}
+	var chunks []string
+	var last *llm.Chunk
+	for chunk, err := range c.StreamChat(ctx, nil, msgs) {
+		check(err)
+		chunks = append(chunks, chunk.Text)
+		last = chunk
+	}
+	if len(chunks) < 2 {
+		t.Errorf("StreamChat() returned %d chunks, want several", len(chunks))
+	}
+	if got, want := strings.Join(chunks, ""), "Paris has about 2.1 million inhabitants."; got != want {
+		t.Errorf("StreamChat() = %q, want %q", got, want)
+	}
+	if last == nil || !last.Done || last.Model != "llama-3.2-3b-instruct" {
+		t.Errorf("StreamChat() last chunk = %+v, want Done", last)
+	}
+}
+
+// Test that a stream that fails after it starts
+// reports a canceled context or a bad chunk as typed errors.
+func TestStreamChatErrors(t *testing.T) {
+	lg := testutil.Slogger(t)
+	msgs := []llm.Message{{Role: llm.RoleUser, Parts: []llm.Part{llm.Text("hi")}}}
+	stream := func(t *testing.T, ctx context.Context, handler http.HandlerFunc) iter.Seq2[*llm.Chunk, error] {
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+		c, err := NewClient(lg, secret.Empty(), srv.Client(), srv.URL, "m")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.StreamChat(ctx, nil, msgs)
+	}
+	const hello = "data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n"
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var last error
+		for chunk, err := range stream(t, ctx, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, hello)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}) {
+			if err != nil {
+				last = err
+				break
+			}
+			if chunk.Text != "Hello" {
+				t.Fatalf("StreamChat() chunk = %+v, want Hello", chunk)
+			}
+			cancel()
+		}
+		if !errors.Is(last, llm.ErrCanceled) || !errors.Is(last, context.Canceled) {
+			t.Errorf("StreamChat() canceled mid-stream: err = %v, want %v", last, llm.ErrCanceled)
+		}
+	})
+
+	t.Run("bad chunk", func(t *testing.T) {
+		var last error
+		for _, err := range stream(t, context.Background(), func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, hello+"data: {bad\n\n")
+		}) {
+			last = err
+		}
+		var e *llm.Error
+		if !errors.As(last, &e) || e.Backend != "openai" {
+			t.Errorf("StreamChat() with bad chunk: err = %#v, want *llm.Error", last)
+		}
+	})
+}
+
+func TestError(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClientKey(t, "testdata/error.httprr", "gpt-4o-mini", "sk-wrong")
+	_, err := c.GenerateContent(ctx, nil, []llm.Part{llm.Text("hello")})
+	if err == nil || !strings.Contains(err.Error(), "Incorrect API key") {
+		t.Errorf("GenerateContent() with bad key error = %v, want Incorrect API key", err)
+	}
+	var e *llm.Error
+	if !errors.As(err, &e) || e.Status != http.StatusUnauthorized || e.Kind != nil {
+		t.Errorf("GenerateContent() with bad key error = %#v, want unclassified *llm.Error with status 401", err)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		code   string
+		msg    string
+		want   error
+	}{
+		{404, "model_not_found", "The model `gpt-9` does not exist", llm.ErrModelNotFound},
+		{400, "context_length_exceeded", "This model's maximum context length is 8192 tokens.", llm.ErrContextLength},
+		{400, "", "Input length exceeds the model's context length (vLLM)", llm.ErrContextLength},
+		{429, "rate_limit_exceeded", "Rate limit reached", llm.ErrOverloaded},
+		{400, "", "Invalid schema for response_format 'response'", llm.ErrInvalidSchema},
+		{500, "", "internal error", nil},
+	} {
+		if got := errorKind(tc.status, tc.code, tc.msg); got != tc.want {
+			t.Errorf("errorKind(%d, %q, %q) = %v, want %v", tc.status, tc.code, tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestBackend(t *testing.T) {
+	lg := testutil.Slogger(t)
+	b, err := llm.NewBackend(lg, BackendName, &llm.BackendConfig{
+		Server:     testServer,
+		EmbedModel: "bge-m3",
+		GenModel:   "qwen2.5",
+		Secret:     secret.Map{"127.0.0.1:8000": "sk-test"},
+		Options:    map[string]string{"temperature": "0.2", "seed": "7"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := b.Embedder.(*Client)
+	if e.model != "bge-m3" || e.key != "sk-test" {
+		t.Errorf("embedder = %q key %q, want bge-m3 key sk-test", e.model, e.key)
+	}
+	g := b.Generator.(*Client)
+	if g.model != "qwen2.5" || *g.opts.Temperature != 0.2 || *g.opts.Seed != 7 {
+		t.Errorf("generator = %q options %+v, want qwen2.5 temperature 0.2 seed 7", g.model, g.opts)
+	}
+
+	if _, err := llm.NewBackend(lg, BackendName, &llm.BackendConfig{GenModel: "qwen2.5"}); err == nil {
+		t.Errorf("NewBackend without embedding model succeeded, want error")
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package search implements hybrid retrieval over a document corpus,
+// combining vector similarity search in a [storage.VectorDB]
+// with keyword search in a [lexical.Index].
+//
+// The two rankings are fused either by reciprocal rank fusion,
+// which uses only the rank of each document in each ranking,
+// or by a weighted sum of normalized scores.
+// The fused results can then be diversified using
+// maximal marginal relevance (MMR), so that many near-identical
+// documents, such as overlapping chunks of a single document,
+// do not crowd out everything else.
+package search
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+
+	"github.com/superryanguo/ryai/lexical"
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/storage"
+)
+
+// A Searcher searches a vector database and a lexical index
+// containing the same document IDs.
+type Searcher struct {
+	slog  *slog.Logger
+	embed llm.Embedder
+	vdb   storage.VectorDB
+	ix    *lexical.Index
+}
+
+// New returns a new Searcher.
+// The embedder embed must be the one used to create the vectors in vdb.
+// Either vdb or ix may be nil, in which case Search uses only the other.
+func New(lg *slog.Logger, embed llm.Embedder, vdb storage.VectorDB, ix *lexical.Index) *Searcher {
+	return &Searcher{slog: lg, embed: embed, vdb: vdb, ix: ix}
+}
+
+// A Fusion is a method for combining the vector and lexical rankings.
+type Fusion int
+
+const (
+	// RRF is reciprocal rank fusion: the fused score of a document is
+	// the sum over the rankings of weight / (K + rank),
+	// where rank is the document's 1-based rank in that ranking.
+	// Because it ignores the scores, RRF needs no calibration
+	// between the very different vector and BM25 score scales.
+	RRF Fusion = iota
+
+	// Weighted fuses the scores directly: the fused score of a document
+	// is the weighted sum of its scores in each ranking,
+	// after scaling each ranking's scores to the range [0, 1].
+	Weighted
+)
+
+func (f Fusion) String() string {
+	switch f {
+	case RRF:
+		return "rrf"
+	case Weighted:
+		return "weighted"
+	}
+	return fmt.Sprintf("Fusion(%d)", int(f))
+}
+
+// Options are options for [Searcher.Search].
+// The zero value (or a nil *Options) selects the defaults.
+type Options struct {
+	// Limit is the number of hits to return.
+	// The default is 10.
+	Limit int
+
+	// Candidates is the number of results to request from each ranking.
+	// The default is 5 × Limit, but at least 50.
+	Candidates int
+
+	// Fusion is the method for fusing the rankings.
+	// The default is RRF.
+	Fusion Fusion
+
+	// K is the RRF rank constant. The default is 60.
+	K float64
+
+	// VectorWeight and LexicalWeight weight the rankings.
+	// If both are zero, the rankings are weighted equally.
+	VectorWeight  float64
+	LexicalWeight float64
+
+	// Lexical, if non-empty, is a query in [lexical.Index.Search] syntax
+	// to use for the lexical ranking instead of the words of the query text.
+	Lexical string
+
+	// Diversity, between 0 and 1, enables MMR diversification.
+	// Each hit is chosen to maximize
+	//
+	//	(1 - Diversity) × relevance - Diversity × similarity
+	//
+	// where relevance is the hit's fused score scaled to [0, 1]
+	// and similarity is its largest cosine similarity to an
+	// already chosen hit.
+	// The default 0 disables MMR, returning hits in fused score order.
+	Diversity float64
+}
+
+// A Hit is a single search result.
+type Hit struct {
+	ID      string  // document ID
+	Score   float64 // fused score; higher is better
+	Vector  Signal  // vector search signal
+	Lexical Signal  // lexical search signal
+}
+
+// A Signal records how a document ranked in a single ranking,
+// for debugging and tuning.
+type Signal struct {
+	Rank  int     // 1-based rank; 0 if not in the ranking
+	Score float64 // score in the ranking; 0 if not in the ranking
+}
+
+// Search returns the documents that best match query,
+// fusing the vector and lexical rankings as directed by opts.
+// The query text is embedded for the vector search,
+// and its words are used for the lexical search
+// (see [lexical.Index.SearchWords]).
+func (s *Searcher) Search(ctx context.Context, query string, opts *Options) ([]Hit, error) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Limit <= 0 {
+		o.Limit = 10
+	}
+	if o.Candidates <= 0 {
+		o.Candidates = max(5*o.Limit, 50)
+	}
+	if o.K <= 0 {
+		o.K = 60
+	}
+	if o.VectorWeight == 0 && o.LexicalWeight == 0 {
+		o.VectorWeight, o.LexicalWeight = 1, 1
+	}
+	if o.Diversity < 0 || o.Diversity > 1 {
+		return nil, fmt.Errorf("search: diversity %v out of range [0, 1]", o.Diversity)
+	}
+
+	hits := make(map[string]*Hit)
+	hit := func(id string) *Hit {
+		h := hits[id]
+		if h == nil {
+			h = &Hit{ID: id}
+			hits[id] = h
+		}
+		return h
+	}
+
+	if s.vdb != nil {
+		vecs, err := s.embed.EmbedDocs(ctx, []llm.EmbedDoc{{Text: query}})
+		if err != nil {
+			return nil, fmt.Errorf("search: embedding query: %w", err)
+		}
+		if len(vecs) != 1 {
+			return nil, fmt.Errorf("search: embedding query: got %d vectors, want 1", len(vecs))
+		}
+		rs, err := s.vdb.Search(vecs[0], o.Candidates)
+		if err != nil {
+			return nil, fmt.Errorf("search: %w", err)
+		}
+		scores := make([]float64, len(rs))
+		for i, r := range rs {
+			scores[i] = r.Score
+		}
+		fuse(&o, o.VectorWeight, scores, func(i int, fused float64) {
+			h := hit(rs[i].ID)
+			h.Vector = Signal{Rank: i + 1, Score: rs[i].Score}
+			h.Score += fused
+		})
+	}
+
+	if s.ix != nil {
+		var rs []lexical.Result
+		var err error
+		if o.Lexical != "" {
+			rs, err = s.ix.Search(o.Lexical, o.Candidates)
+		} else {
+			rs, err = s.ix.SearchWords(query, o.Candidates)
+		}
+		if err != nil {
+			return nil, err
+		}
+		scores := make([]float64, len(rs))
+		for i, r := range rs {
+			scores[i] = r.Score
+		}
+		fuse(&o, o.LexicalWeight, scores, func(i int, fused float64) {
+			h := hit(rs[i].ID)
+			h.Lexical = Signal{Rank: i + 1, Score: rs[i].Score}
+			h.Score += fused
+		})
+	}
+
+	var list []Hit
+	for _, h := range hits {
+		list = append(list, *h)
+	}
+	slices.SortFunc(list, func(x, y Hit) int {
+		if c := cmp.Compare(y.Score, x.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(x.ID, y.ID)
+	})
+	s.slog.Debug("search.Search", "query", query, "vector", s.vdb != nil, "lexical", s.ix != nil, "hits", len(list))
+
+	if o.Diversity > 0 && s.vdb != nil {
+		return s.mmr(list, o.Limit, o.Diversity), nil
+	}
+	return list[:min(len(list), o.Limit)], nil
+}
+
+// fuse computes the fused score of each entry in a ranking
+// with the given scores (in rank order) and weight,
+// calling add(i, fused) for each entry i.
+func fuse(o *Options, weight float64, scores []float64, add func(i int, fused float64)) {
+	if weight == 0 || len(scores) == 0 {
+		return
+	}
+	switch o.Fusion {
+	default: // RRF
+		for i := range scores {
+			add(i, weight/(o.K+float64(i+1)))
+		}
+	case Weighted:
+		lo, hi := slices.Min(scores), slices.Max(scores)
+		for i, score := range scores {
+			norm := 1.0
+			if hi > lo {
+				norm = (score - lo) / (hi - lo)
+			}
+			add(i, weight*norm)
+		}
+	}
+}
+
+// mmr returns up to n hits from list, which is sorted by decreasing score,
+// chosen greedily by maximal marginal relevance with the given diversity.
+// Hits without a stored vector are treated as dissimilar to all others.
+func (s *Searcher) mmr(list []Hit, n int, diversity float64) []Hit {
+	if len(list) == 0 {
+		return list
+	}
+	top := list[0].Score
+	vecs := make([]llm.Vector, len(list))
+	for i, h := range list {
+		if v, ok := s.vdb.Get(h.ID); ok {
+			vecs[i] = unit(v)
+		}
+	}
+
+	// maxSim[i] is the largest similarity of list[i]
+	// to any chosen hit.
+	maxSim := make([]float64, len(list))
+	chosen := make([]bool, len(list))
+	var out []Hit
+	for len(out) < min(n, len(list)) {
+		best, bestScore := -1, math.Inf(-1)
+		for i, h := range list {
+			if chosen[i] {
+				continue
+			}
+			rel := 0.0
+			if top > 0 {
+				rel = h.Score / top
+			}
+			score := (1-diversity)*rel - diversity*maxSim[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		chosen[best] = true
+		out = append(out, list[best])
+		if vecs[best] == nil {
+			continue
+		}
+		for i := range list {
+			if !chosen[i] && vecs[i] != nil {
+				maxSim[i] = max(maxSim[i], vecs[i].Dot(vecs[best]))
+			}
+		}
+	}
+	return out
+}
+
+// unit returns v scaled to unit length.
+func unit(v llm.Vector) llm.Vector {
+	d := math.Sqrt(v.Dot(v))
+	if d == 0 {
+		return v
+	}
+	u := make(llm.Vector, len(v))
+	for i, x := range v {
+		u[i] = float32(float64(x) / d)
+	}
+	return u
+}
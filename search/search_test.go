@@ -0,0 +1,170 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package search
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/superryanguo/ryai/docs"
+	"github.com/superryanguo/ryai/lexical"
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/storage"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+// A testEmbedder embeds the texts in its map as the given vectors
+// and all other texts as (0, 0, 1).
+type testEmbedder map[string]llm.Vector
+
+func (e testEmbedder) EmbedDocs(ctx context.Context, docs []llm.EmbedDoc) ([]llm.Vector, error) {
+	var vecs []llm.Vector
+	for _, d := range docs {
+		v, ok := e[d.Text]
+		if !ok {
+			v = llm.Vector{0, 0, 1}
+		}
+		vecs = append(vecs, v)
+	}
+	return vecs, nil
+}
+
+var testDocs = []struct {
+	id, text string
+	vec      llm.Vector
+}{
+	// Three near-identical chunks of one document.
+	{"a#1", "timeout timeout retry", llm.Vector{1, 0, 0}},
+	{"a#2", "timeout timeout retry", llm.Vector{0.999, 0.045, 0}},
+	{"a#3", "timeout timeout retry", llm.Vector{0.998, 0.063, 0}},
+	{"b", "ERR_TIMEOUT_42 is a timeout returned after all the retries fail", llm.Vector{0.8, 0.6, 0}},
+	{"c", "cooking with garlic", llm.Vector{0, 1, 0}},
+}
+
+func newTestSearcher(t *testing.T) *Searcher {
+	lg := testutil.Slogger(t)
+	db := storage.MemDB()
+	dc := docs.New(lg, db)
+	vdb := storage.MemVectorDB(db, lg, "")
+	for _, d := range testDocs {
+		dc.Add(d.id, "", d.text)
+		vdb.Set(d.id, d.vec)
+	}
+	ix := lexical.New(lg, db, dc, "")
+	ix.Sync()
+	embed := testEmbedder{"timeout": {1, 0, 0}}
+	return New(lg, embed, vdb, ix)
+}
+
+func ids(hits []Hit) []string {
+	var ids []string
+	for _, h := range hits {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+func TestRRF(t *testing.T) {
+	s := newTestSearcher(t)
+	hits, err := s.Search(context.Background(), "timeout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := ids(hits), []string{"a#1", "a#2", "a#3", "b", "c"}; !slices.Equal(have, want) {
+		t.Fatalf("Search(timeout) = %v, want %v", have, want)
+	}
+	for i, want := range []struct{ vector, lexical int }{{1, 1}, {2, 2}, {3, 3}, {4, 4}, {5, 0}} {
+		h := hits[i]
+		if h.Vector.Rank != want.vector || h.Lexical.Rank != want.lexical {
+			t.Errorf("%s: ranks vector=%d lexical=%d, want %d, %d", h.ID, h.Vector.Rank, h.Lexical.Rank, want.vector, want.lexical)
+		}
+		score := 1 / (60 + float64(want.vector))
+		if want.lexical > 0 {
+			score += 1 / (60 + float64(want.lexical))
+		}
+		if math.Abs(h.Score-score) > 1e-12 {
+			t.Errorf("%s: score %v, want %v", h.ID, h.Score, score)
+		}
+		if (h.Lexical.Score > 0) != (want.lexical > 0) {
+			t.Errorf("%s: signal scores vector=%v lexical=%v", h.ID, h.Vector.Score, h.Lexical.Score)
+		}
+	}
+
+	// An exact identifier that the embedding misses
+	// is found by the lexical ranking.
+	hits, err = s.Search(context.Background(), "ERR_TIMEOUT_42", &Options{Limit: 1})
+	if have, want := ids(hits), []string{"b"}; err != nil || !slices.Equal(have, want) {
+		t.Errorf("Search(ERR_TIMEOUT_42) = %v, %v, want %v", have, err, want)
+	}
+
+	// Weighting the lexical ranking reorders the results.
+	hits, err = s.Search(context.Background(), "timeout", &Options{Lexical: "garlic OR ERR_TIMEOUT_42", LexicalWeight: 1, VectorWeight: 0.1})
+	if have, want := ids(hits), []string{"c", "b", "a#1", "a#2", "a#3"}; err != nil || !slices.Equal(have, want) {
+		t.Errorf("Search(timeout) weighted = %v, %v, want %v", have, err, want)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	s := newTestSearcher(t)
+	hits, err := s.Search(context.Background(), "timeout", &Options{Fusion: Weighted, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := ids(hits), []string{"a#1", "a#2", "a#3"}; !slices.Equal(have, want) {
+		t.Fatalf("Search(timeout) = %v, want %v", have, want)
+	}
+	// a#1 has the best score in both rankings.
+	if hits[0].Score != 2 {
+		t.Errorf("%s: score %v, want 2", hits[0].ID, hits[0].Score)
+	}
+	for _, h := range hits {
+		if h.Score <= 0 || h.Score > 2 {
+			t.Errorf("%s: score %v out of range", h.ID, h.Score)
+		}
+	}
+}
+
+func TestMMR(t *testing.T) {
+	s := newTestSearcher(t)
+	hits, err := s.Search(context.Background(), "timeout", &Options{Limit: 3, Diversity: 0.3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := ids(hits), []string{"a#1", "b", "a#2"}; !slices.Equal(have, want) {
+		t.Errorf("Search(timeout) with MMR = %v, want %v", have, want)
+	}
+	hits, err = s.Search(context.Background(), "timeout", &Options{Limit: 2, Diversity: 1})
+	if have, want := ids(hits), []string{"a#1", "c"}; err != nil || !slices.Equal(have, want) {
+		t.Errorf("Search(timeout) with full diversity = %v, %v, want %v", have, err, want)
+	}
+	if _, err := s.Search(context.Background(), "timeout", &Options{Diversity: 2}); err == nil {
+		t.Errorf("Search with Diversity 2 succeeded")
+	}
+}
+
+func TestSingleSignal(t *testing.T) {
+	s := newTestSearcher(t)
+	vec := New(s.slog, s.embed, s.vdb, nil)
+	hits, err := vec.Search(context.Background(), "ERR_TIMEOUT_42", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range hits {
+		if h.Lexical.Rank != 0 {
+			t.Errorf("vector-only search has lexical rank for %s", h.ID)
+		}
+	}
+
+	lex := New(s.slog, nil, nil, s.ix)
+	hits, err = lex.Search(context.Background(), "ERR_TIMEOUT_42", &Options{Diversity: 0.5})
+	if have, want := ids(hits), []string{"b"}; err != nil || !slices.Equal(have, want) {
+		t.Errorf("lexical-only Search = %v, %v, want %v", have, err, want)
+	}
+	if _, err := lex.Search(context.Background(), "x", &Options{Lexical: "(x"}); err == nil {
+		t.Errorf("Search with bad lexical query succeeded")
+	}
+}
@@ -0,0 +1,680 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/superryanguo/ryai/llm"
+	"rsc.io/ordered"
+)
+
+// A diskDB is a VectorDB storing its vectors in an append-only,
+// memory-mapped file and its ID index and metadata in a DB.
+type diskDB struct {
+	storage   DB
+	slog      *slog.Logger
+	namespace string
+	file      string // vector files are file.1, file.2, ...
+	cfg       vectorConfig
+
+	// wmu serializes writes and compactions.
+	// A write appends its records past end without holding mu
+	// and then holds mu to commit them.
+	// Only a goroutine holding wmu modifies the fields protected by mu,
+	// so it can read them without holding mu.
+	wmu        sync.Mutex
+	deadBytes  int64 // total size of dead records in f
+	compacting bool  // compaction has been started
+	closed     bool  // Close has been called; no new compactions start
+	compactWG  sync.WaitGroup
+
+	// mu protects the fields below and the ID index in storage,
+	// which must be read and updated together with them.
+	mu   sync.RWMutex
+	gen  int64    // generation of f
+	f    *os.File // current vector file
+	data []byte   // memory mapping of f; may extend past end
+	end  int64    // length of committed records in f
+	dead []int64  // sorted offsets of dead records in f
+}
+
+// A diskState is the state of a diskDB recorded in its DB.
+type diskState struct {
+	Gen int64 // generation of the current vector file
+	End int64 // length of committed records in the file
+}
+
+// diskCompactMin is the minimum total size of dead records
+// that triggers a compaction. It is a variable for testing.
+var diskCompactMin int64 = 16 << 20
+
+// DiskVectorDB returns a VectorDB that stores its vectors in an append-only
+// file and its ID index and metadata in db.
+// Unlike [MemVectorDB], it does not read the vectors into memory:
+// Search memory-maps the file and streams over it in a brute-force scan,
+// leaving caching to the operating system,
+// so opening even a large DiskVectorDB is nearly instant.
+//
+// The vectors are stored in files named file.1, file.2, and so on.
+// Set and Batch.Apply append new records to the current file,
+// and Delete and replacing Set mark the old records dead.
+// Once dead records make up half of the file (and at least 16MB),
+// a background compaction copies the live records to the next file
+// and switches to it. Writes wait for a compaction in progress;
+// searches do not.
+//
+// Writes are crash-safe: each write syncs its records to the file
+// before recording them, along with the new committed length of the file,
+// in db. Opening the database discards any records past the committed
+// length, which were appended by an interrupted write,
+// as well as any files left by an interrupted compaction.
+//
+// DiskVectorDB compares vectors as configured by opts, recording the
+// configuration in db for later calls with nil opts, like [NewMemVectorDB].
+// DiskVectorDB supports only [llm.Float32] precision.
+// Changing the metric of an existing database to [Cosine]
+// rewrites the file with vectors scaled to unit length.
+//
+// The db keys used by a DiskVectorDB have the forms
+//
+//	ordered.Encode("llm.DiskVector", namespace, "id", id)
+//	ordered.Encode("llm.DiskVector", namespace, "meta", id)
+//	ordered.Encode("llm.DiskVector", namespace, "dead", offset)
+//	ordered.Encode("llm.DiskVector", namespace, "state")
+//	ordered.Encode("llm.DiskVector", namespace, "config")
+//
+// The "id" key holds the offset of the id's record in the current file,
+// the "meta" key holds the JSON-encoded metadata,
+// the "dead" key holds the size of the dead record at offset,
+// the "state" key holds the current file generation and committed length,
+// and the "config" key holds the configuration.
+//
+// Each record in a vector file is the ID length and vector length
+// as big-endian uint32s, followed by the ID and the vector
+// in the encoding of [llm.Vector.Encode].
+//
+// The returned VectorDB also implements [io.Closer].
+// Its Close method waits for any background compaction to finish
+// and then unmaps and closes the vector file, without closing db.
+func DiskVectorDB(db DB, lg *slog.Logger, namespace, file string, opts *VectorOptions) (VectorDB, error) {
+	if opts != nil && opts.Precision != llm.Float32 {
+		return nil, fmt.Errorf("DiskVectorDB: unsupported precision %v", opts.Precision)
+	}
+	vdb := &diskDB{
+		storage:   db,
+		slog:      lg,
+		namespace: namespace,
+		file:      file,
+	}
+	cfg, stored := loadVectorConfig(db, vdb.key("config"))
+	vdb.cfg = *cfg
+	if opts != nil {
+		vdb.cfg = vectorConfig{Metric: opts.Metric, Dim: opts.Dim}
+		if !stored || vdb.cfg != *cfg {
+			db.Set(vdb.key("config"), JSON(&vdb.cfg))
+		}
+	}
+
+	st := diskState{Gen: 1}
+	if enc, ok := db.Get(vdb.key("state")); ok {
+		if err := json.Unmarshal(enc, &st); err != nil {
+			// unreachable except data corruption
+			db.Panic("DiskVectorDB decode state", "namespace", namespace, "err", err)
+		}
+	}
+	vdb.gen, vdb.end = st.Gen, st.End
+	name := vdb.fileName(st.Gen)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() < st.End {
+		f.Close()
+		return nil, fmt.Errorf("DiskVectorDB: %s has %d bytes, want %d", name, info.Size(), st.End)
+	}
+	if info.Size() > st.End {
+		lg.Warn("discarding uncommitted vectors", "file", name, "bytes", info.Size()-st.End)
+		if err := f.Truncate(st.End); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	vdb.f = f
+
+	// Remove the files of an interrupted or completed compaction.
+	for _, gen := range []int64{st.Gen - 1, st.Gen + 1} {
+		if err := os.Remove(vdb.fileName(gen)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			lg.Warn("removing old vector file", "err", err)
+		}
+	}
+
+	for key, getVal := range db.Scan(vdb.key("dead"), vdb.key("dead", ordered.Inf)) {
+		var off int64
+		if err := ordered.Decode(key, nil, nil, nil, &off); err != nil {
+			// unreachable except data corruption
+			db.Panic("DiskVectorDB decode key", "key", Fmt(key), "err", err)
+		}
+		vdb.dead = append(vdb.dead, off)
+		vdb.deadBytes += int64(binary.BigEndian.Uint64(getVal()))
+	}
+	if vdb.data, err = mapFile(f, nil, st.End); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	vdb.wmu.Lock()
+	if vdb.cfg.Metric == Cosine && cfg.Metric != Cosine && vdb.end > 0 {
+		vdb.compact()
+	}
+	vdb.maybeCompact()
+	vdb.wmu.Unlock()
+
+	lg.Info("loaded disk vectordb", "namespace", namespace, "file", vdb.fileName(vdb.gen),
+		"bytes", vdb.end, "dead", vdb.deadBytes, "metric", vdb.cfg.Metric, "dim", vdb.cfg.Dim)
+	return vdb, nil
+}
+
+// key returns the key for the given list of values in vdb's namespace.
+func (vdb *diskDB) key(list ...any) []byte {
+	return ordered.Encode(append([]any{"llm.DiskVector", vdb.namespace}, list...)...)
+}
+
+// fileName returns the name of the vector file for generation gen.
+func (vdb *diskDB) fileName(gen int64) string {
+	return fmt.Sprintf("%s.%d", vdb.file, gen)
+}
+
+// offset returns the offset of the record for id in the current file.
+// The caller must hold vdb.mu or vdb.wmu.
+func (vdb *diskDB) offset(id string) (int64, bool) {
+	enc, ok := vdb.storage.Get(vdb.key("id", id))
+	if !ok {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(enc)), true
+}
+
+// record returns the ID and encoded vector of the record at off,
+// along with the offset of the next record.
+// The caller must hold vdb.mu or vdb.wmu.
+func (vdb *diskDB) record(off int64) (id, vec []byte, next int64) {
+	data := vdb.data[:vdb.end]
+	if off < 0 || off+8 > vdb.end {
+		// unreachable except data corruption
+		vdb.storage.Panic("DiskVectorDB bad offset", "file", vdb.fileName(vdb.gen), "offset", off)
+	}
+	idLen := int64(binary.BigEndian.Uint32(data[off:]))
+	vecLen := int64(binary.BigEndian.Uint32(data[off+4:]))
+	next = off + 8 + idLen + 4*vecLen
+	if next > vdb.end {
+		// unreachable except data corruption
+		vdb.storage.Panic("DiskVectorDB bad record", "file", vdb.fileName(vdb.gen), "offset", off)
+	}
+	return data[off+8 : off+8+idLen], data[off+8+idLen : next], next
+}
+
+// appendRecord appends the record for id and vec to buf.
+func appendRecord(buf []byte, id string, vec llm.Vector) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(id)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(vec)))
+	buf = append(buf, id...)
+	for _, f := range vec {
+		buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(f))
+	}
+	return buf
+}
+
+// recordSize returns the size of the record at the start of buf.
+func recordSize(buf []byte) int64 {
+	return 8 + int64(binary.BigEndian.Uint32(buf)) + 4*int64(binary.BigEndian.Uint32(buf[4:]))
+}
+
+// dotBytes returns the dot product of the encoded vector enc and v,
+// along with the squared length of the encoded vector.
+// It ignores entries past the end of the shorter vector.
+func dotBytes(enc []byte, v llm.Vector) (dot, norm2 float64) {
+	v = v[:min(len(v), len(enc)/4)]
+	enc = enc[:4*len(v)] // remove bounds check in loop
+	for i := range v {
+		f := float64(math.Float32frombits(binary.BigEndian.Uint32(enc[4*i:])))
+		dot += f * float64(v[i])
+		norm2 += f * f
+	}
+	return dot, norm2
+}
+
+// be64 returns the 8-byte big-endian encoding of x.
+func be64(x int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(x))
+}
+
+func (vdb *diskDB) Set(id string, vec llm.Vector) error {
+	b := vdb.Batch()
+	if err := b.Set(id, vec); err != nil {
+		return err
+	}
+	b.Apply()
+	return nil
+}
+
+func (vdb *diskDB) Delete(id string) {
+	b := vdb.Batch()
+	b.Delete(id)
+	b.Apply()
+}
+
+func (vdb *diskDB) SetMeta(id string, meta VectorMeta) {
+	b := vdb.Batch()
+	b.SetMeta(id, meta)
+	b.Apply()
+}
+
+func (vdb *diskDB) Meta(id string) VectorMeta {
+	enc, ok := vdb.storage.Get(vdb.key("meta", id))
+	if !ok {
+		return nil
+	}
+	var meta VectorMeta
+	if err := json.Unmarshal(enc, &meta); err != nil {
+		// unreachable except data corruption
+		vdb.storage.Panic("DiskVectorDB decode meta", "id", id, "err", err)
+	}
+	return meta
+}
+
+func (vdb *diskDB) Get(id string) (llm.Vector, bool) {
+	vdb.mu.RLock()
+	defer vdb.mu.RUnlock()
+
+	off, ok := vdb.offset(id)
+	if !ok {
+		return nil, false
+	}
+	_, enc, _ := vdb.record(off)
+	var vec llm.Vector
+	vec.Decode(enc)
+	return vec, true
+}
+
+// All returns all ID-vector pairs in lexicographic order of IDs.
+func (vdb *diskDB) All() iter.Seq2[string, func() llm.Vector] {
+	return func(yield func(key string, val func() llm.Vector) bool) {
+		for key := range vdb.storage.Scan(vdb.key("id"), vdb.key("id", ordered.Inf)) {
+			var id string
+			if err := ordered.Decode(key, nil, nil, nil, &id); err != nil {
+				// unreachable except data corruption
+				vdb.storage.Panic("DiskVectorDB decode key", "key", Fmt(key), "err", err)
+			}
+			// Look up the vector again in case a compaction has moved it.
+			val := func() llm.Vector {
+				vec, _ := vdb.Get(id)
+				return vec
+			}
+			if !yield(id, val) {
+				return
+			}
+		}
+	}
+}
+
+func (vdb *diskDB) Search(target llm.Vector, n int) ([]VectorResult, error) {
+	return vdb.SearchFilter(target, n, nil)
+}
+
+// SearchFilter scans the memory-mapped vector file.
+// If f has an ID list, it reads only the records for those IDs.
+func (vdb *diskDB) SearchFilter(target llm.Vector, n int, f *VectorFilter) ([]VectorResult, error) {
+	if err := checkDim(vdb.namespace, vdb.cfg.Dim, target); err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	m := vdb.cfg.Metric
+	target = m.prepare(target)
+	norm2 := target.Dot(target)
+	match := f.matcher()
+	var prefix []byte
+	if f != nil {
+		prefix = []byte(f.Prefix)
+	}
+
+	vdb.mu.RLock()
+	defer vdb.mu.RUnlock()
+
+	best := newResultHeap(n)
+	consider := func(id, enc []byte) {
+		if len(enc) != 4*len(target) || !bytes.HasPrefix(id, prefix) {
+			return
+		}
+		dot, n2 := dotBytes(enc, target)
+		r := VectorResult{Score: m.sim(dot, n2, norm2)}
+		if best.full() && (r.Score < best[0].Score || r.Score == best[0].Score && string(id) < best[0].ID) {
+			return
+		}
+		r.ID = string(id)
+		var meta VectorMeta
+		if f != nil && f.Match != nil {
+			meta = vdb.Meta(r.ID)
+		}
+		if !match(r.ID, meta) {
+			return
+		}
+		best.add(r)
+	}
+
+	if f != nil && f.IDs != nil {
+		ids := slices.Clone(f.IDs)
+		slices.Sort(ids)
+		for _, id := range slices.Compact(ids) {
+			if off, ok := vdb.offset(id); ok {
+				idb, enc, _ := vdb.record(off)
+				consider(idb, enc)
+			}
+		}
+	} else {
+		dead := vdb.dead
+		for off := int64(0); off < vdb.end; {
+			id, enc, next := vdb.record(off)
+			for len(dead) > 0 && dead[0] < off {
+				dead = dead[1:]
+			}
+			if len(dead) == 0 || dead[0] != off {
+				consider(id, enc)
+			}
+			off = next
+		}
+	}
+
+	return scoreResults(m, best.take()), nil
+}
+
+// Flush flushes the underlying DB.
+// The vector file needs no flushing: every write syncs it.
+func (vdb *diskDB) Flush() {
+	vdb.storage.Flush()
+}
+
+var _ io.Closer = (*diskDB)(nil)
+
+// Close waits for any background compaction to finish
+// and then unmaps and closes the vector file.
+// It does not close the underlying DB.
+// The DiskVectorDB must not be used after Close,
+// except that calling Close again does nothing.
+func (vdb *diskDB) Close() error {
+	vdb.wmu.Lock()
+	vdb.closed = true
+	vdb.wmu.Unlock()
+	vdb.compactWG.Wait()
+
+	vdb.wmu.Lock()
+	defer vdb.wmu.Unlock()
+	vdb.mu.Lock()
+	defer vdb.mu.Unlock()
+	if vdb.f == nil {
+		return nil
+	}
+	err := unmapFile(vdb.data)
+	if cerr := vdb.f.Close(); err == nil {
+		err = cerr
+	}
+	vdb.f, vdb.data, vdb.end, vdb.dead = nil, nil, 0, nil
+	return err
+}
+
+// A diskBatch is a VectorBatch for a diskDB.
+// It records operations to be applied by Apply.
+type diskBatch struct {
+	db   *diskDB
+	ops  []diskOp
+	size int // approximate size of ops in bytes
+}
+
+// A diskOp is a single operation in a diskBatch.
+type diskOp struct {
+	id   string
+	vec  llm.Vector
+	meta VectorMeta
+	op   byte // 's' for set, 'd' for delete, 'm' for set metadata
+}
+
+// diskBatchLimit is the approximate size in bytes at which
+// MaybeApply applies a diskBatch.
+const diskBatchLimit = 16 << 20
+
+func (vdb *diskDB) Batch() VectorBatch {
+	return &diskBatch{db: vdb}
+}
+
+func (b *diskBatch) Set(id string, vec llm.Vector) error {
+	if len(id) == 0 {
+		b.db.storage.Panic("DiskVectorDB batch set: empty ID")
+	}
+	if err := checkDim(b.db.namespace, b.db.cfg.Dim, vec); err != nil {
+		return err
+	}
+	vec = slices.Clone(b.db.cfg.Metric.prepare(vec))
+	b.ops = append(b.ops, diskOp{id: id, vec: vec, op: 's'})
+	b.size += len(id) + 4*len(vec)
+	return nil
+}
+
+func (b *diskBatch) Delete(id string) {
+	b.ops = append(b.ops, diskOp{id: id, op: 'd'})
+	b.size += len(id)
+}
+
+func (b *diskBatch) SetMeta(id string, meta VectorMeta) {
+	if len(id) == 0 {
+		b.db.storage.Panic("DiskVectorDB batch set meta: empty ID")
+	}
+	b.ops = append(b.ops, diskOp{id: id, meta: meta, op: 'm'})
+	b.size += len(id)
+}
+
+func (b *diskBatch) MaybeApply() bool {
+	if b.size < diskBatchLimit {
+		return false
+	}
+	b.Apply()
+	return true
+}
+
+// Apply appends the batch's new records to the vector file,
+// syncs the file, and then records the changes in the DB.
+func (b *diskBatch) Apply() {
+	if len(b.ops) == 0 {
+		return
+	}
+	vdb := b.db
+	vdb.wmu.Lock()
+	defer vdb.wmu.Unlock()
+
+	sb := vdb.storage.Batch()
+	var buf []byte
+	var dead []int64
+	offs := make(map[string]int64) // offsets of IDs set (or -1 if deleted) in this batch
+	kill := func(id string) {
+		off, ok := offs[id]
+		if !ok {
+			off, ok = vdb.offset(id)
+		}
+		if !ok || off < 0 {
+			return
+		}
+		var size int64
+		if off >= vdb.end {
+			size = recordSize(buf[off-vdb.end:])
+		} else {
+			_, _, next := vdb.record(off)
+			size = next - off
+		}
+		sb.Set(vdb.key("dead", off), be64(size))
+		dead = append(dead, off)
+		vdb.deadBytes += size
+	}
+	for _, op := range b.ops {
+		switch op.op {
+		case 's':
+			kill(op.id)
+			off := vdb.end + int64(len(buf))
+			buf = appendRecord(buf, op.id, op.vec)
+			offs[op.id] = off
+			sb.Set(vdb.key("id", op.id), be64(off))
+		case 'd':
+			kill(op.id)
+			offs[op.id] = -1
+			sb.Delete(vdb.key("id", op.id))
+			sb.Delete(vdb.key("meta", op.id))
+		case 'm':
+			if len(op.meta) == 0 {
+				sb.Delete(vdb.key("meta", op.id))
+			} else {
+				sb.Set(vdb.key("meta", op.id), JSON(op.meta))
+			}
+		}
+	}
+	b.ops = nil
+	b.size = 0
+
+	end := vdb.end + int64(len(buf))
+	if len(buf) > 0 {
+		if _, err := vdb.f.WriteAt(buf, vdb.end); err != nil {
+			// unreachable except disk error
+			vdb.storage.Panic("DiskVectorDB write", "file", vdb.fileName(vdb.gen), "err", err)
+		}
+		if err := vdb.f.Sync(); err != nil {
+			// unreachable except disk error
+			vdb.storage.Panic("DiskVectorDB sync", "file", vdb.fileName(vdb.gen), "err", err)
+		}
+	}
+	sb.Set(vdb.key("state"), JSON(diskState{vdb.gen, end}))
+
+	vdb.mu.Lock()
+	sb.Apply()
+	data, err := mapFile(vdb.f, vdb.data, end)
+	if err != nil {
+		// unreachable except disk error
+		vdb.storage.Panic("DiskVectorDB mmap", "file", vdb.fileName(vdb.gen), "err", err)
+	}
+	vdb.data, vdb.end = data, end
+	if len(dead) > 0 {
+		vdb.dead = append(vdb.dead, dead...)
+		slices.Sort(vdb.dead)
+	}
+	vdb.mu.Unlock()
+
+	vdb.maybeCompact()
+}
+
+// maybeCompact starts a background compaction
+// if dead records make up enough of the vector file.
+// The caller must hold vdb.wmu.
+func (vdb *diskDB) maybeCompact() {
+	if vdb.compacting || vdb.closed || vdb.deadBytes < max(diskCompactMin, 1) || 2*vdb.deadBytes < vdb.end {
+		return
+	}
+	vdb.compacting = true
+	vdb.compactWG.Add(1)
+	go func() {
+		defer vdb.compactWG.Done()
+		vdb.wmu.Lock()
+		defer vdb.wmu.Unlock()
+		vdb.compact()
+	}()
+}
+
+// compact copies the live records to the next generation's vector file,
+// scaling them to unit length for the Cosine metric,
+// and then switches to that file.
+// The caller must hold vdb.wmu.
+//
+// The switch updates every ID's offset in a single DB batch,
+// so that an interrupted compaction leaves the old file in use.
+func (vdb *diskDB) compact() {
+	defer func() { vdb.compacting = false }()
+
+	gen := vdb.gen + 1
+	name := vdb.fileName(gen)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		// unreachable except disk error
+		vdb.storage.Panic("DiskVectorDB compact", "file", name, "err", err)
+	}
+	w := bufio.NewWriter(f)
+	sb := vdb.storage.Batch()
+	var end int64
+	var buf []byte
+	var vec llm.Vector
+	n := 0
+	dead := vdb.dead
+	for off := int64(0); off < vdb.end; {
+		id, enc, next := vdb.record(off)
+		for len(dead) > 0 && dead[0] < off {
+			dead = dead[1:]
+		}
+		if len(dead) == 0 || dead[0] != off {
+			vec.Decode(enc)
+			buf = appendRecord(buf[:0], string(id), vdb.cfg.Metric.prepare(vec))
+			w.Write(buf)
+			sb.Set(vdb.key("id", string(id)), be64(end))
+			end += int64(len(buf))
+			n++
+		}
+		off = next
+	}
+	if err := w.Flush(); err != nil {
+		// unreachable except disk error
+		vdb.storage.Panic("DiskVectorDB compact write", "file", name, "err", err)
+	}
+	if err := f.Sync(); err != nil {
+		// unreachable except disk error
+		vdb.storage.Panic("DiskVectorDB compact sync", "file", name, "err", err)
+	}
+	data, err := mapFile(f, nil, end)
+	if err != nil {
+		// unreachable except disk error
+		vdb.storage.Panic("DiskVectorDB mmap", "file", name, "err", err)
+	}
+	sb.DeleteRange(vdb.key("dead"), vdb.key("dead", ordered.Inf))
+	sb.Set(vdb.key("state"), JSON(diskState{gen, end}))
+
+	vdb.mu.Lock()
+	sb.Apply()
+	oldGen, oldF, oldData, oldEnd := vdb.gen, vdb.f, vdb.data, vdb.end
+	vdb.gen, vdb.f, vdb.data, vdb.end, vdb.dead = gen, f, data, end, nil
+	vdb.mu.Unlock()
+
+	// Make sure the DB no longer refers to the old file before removing it.
+	vdb.storage.Flush()
+	unmapFile(oldData)
+	oldF.Close()
+	if err := os.Remove(vdb.fileName(oldGen)); err != nil {
+		vdb.slog.Warn("removing old vector file", "err", err)
+	}
+	vdb.slog.Info("compacted disk vectordb", "namespace", vdb.namespace, "file", name,
+		"n", n, "from", oldEnd, "to", end)
+	vdb.deadBytes = 0
+}
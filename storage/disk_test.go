@@ -0,0 +1,178 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+// openDisk opens a DiskVectorDB, failing the test on error.
+func openDisk(t *testing.T, db DB, file string, opts *VectorOptions) *diskDB {
+	t.Helper()
+	vdb, err := DiskVectorDB(db, testutil.Slogger(t), "", file, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { vdb.(io.Closer).Close() })
+	return vdb.(*diskDB)
+}
+
+func TestDiskVectorDB(t *testing.T) {
+	db := MemDB()
+	file := filepath.Join(t.TempDir(), "vectors")
+	TestVectorDB(t, func() VectorDB { return openDisk(t, db, file, nil) })
+}
+
+func TestDiskVectorDBMetric(t *testing.T) {
+	dir := t.TempDir()
+	files := make(map[DB]string)
+	testMetric(t, func(db DB, opts *VectorOptions) VectorDB {
+		if files[db] == "" {
+			files[db] = filepath.Join(dir, fmt.Sprint(len(files)))
+		}
+		return openDisk(t, db, files[db], opts)
+	})
+}
+
+func TestDiskVectorDBPrecision(t *testing.T) {
+	_, err := DiskVectorDB(MemDB(), testutil.Slogger(t), "", filepath.Join(t.TempDir(), "v"), &VectorOptions{Precision: llm.Int8})
+	if err == nil {
+		t.Fatalf("DiskVectorDB with Int8 precision succeeded")
+	}
+}
+
+// Test that Close waits for a compaction and releases the file.
+func TestDiskVectorDBClose(t *testing.T) {
+	defer func(n int64) { diskCompactMin = n }(diskCompactMin)
+	diskCompactMin = 1
+
+	db := MemDB()
+	file := filepath.Join(t.TempDir(), "vectors")
+	vdb := openDisk(t, db, file, nil)
+	ids, vecs, _ := hnswTestData(100, 16)
+	for i, id := range ids {
+		vdb.Set(id, vecs[i])
+	}
+	for _, id := range ids[:60] {
+		vdb.Delete(id) // starts a compaction
+	}
+	if err := vdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if vdb.compacting {
+		t.Errorf("Close returned during compaction")
+	}
+	if vdb.f != nil || vdb.data != nil {
+		t.Errorf("Close did not release the vector file")
+	}
+	if err := vdb.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+
+	vdb = openDisk(t, db, file, nil)
+	if have := allIDs(vdb); !slices.Equal(have, ids[60:]) {
+		t.Errorf("All() after Close and reopen = %v, want %v", have, ids[60:])
+	}
+}
+
+// Test that records appended by an interrupted write are discarded.
+func TestDiskVectorDBRecover(t *testing.T) {
+	db := MemDB()
+	file := filepath.Join(t.TempDir(), "vectors")
+	vdb := openDisk(t, db, file, nil)
+	vdb.Set("apple3", embed("apple3"))
+	vdb.Set("orange1", embed("orange1"))
+
+	info, err := os.Stat(file + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := info.Size()
+	f, err := os.OpenFile(file+".1", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(appendRecord(nil, "orange2", embed("orange2"))[:20])
+	f.Close()
+
+	vdb = openDisk(t, db, file, nil)
+	if have, want := allIDs(vdb), []string{"apple3", "orange1"}; !slices.Equal(have, want) {
+		t.Errorf("All() after interrupted write = %v, want %v", have, want)
+	}
+	if info, err := os.Stat(file + ".1"); err != nil || info.Size() != size {
+		t.Errorf("file not truncated after interrupted write: size %d, %v, want %d", info.Size(), err, size)
+	}
+	vdb.Set("orange2", embed("orange2"))
+	rs, err := vdb.Search(embed("orange2"), 1)
+	if err != nil || len(rs) != 1 || rs[0].ID != "orange2" {
+		t.Errorf("Search(orange2) = %v, %v, want orange2", rs, err)
+	}
+}
+
+func TestDiskVectorDBCompact(t *testing.T) {
+	defer func(n int64) { diskCompactMin = n }(diskCompactMin)
+	diskCompactMin = 1
+
+	lg := testutil.Slogger(t)
+	ids, vecs, queries := hnswTestData(500, 16)
+	db := MemDB()
+	file := filepath.Join(t.TempDir(), "vectors")
+	vdb := openDisk(t, db, file, nil)
+	exact := MemVectorDB(MemDB(), lg, "")
+	b := vdb.Batch()
+	for i, id := range ids {
+		b.Set(id, vecs[i])
+		b.SetMeta(id, VectorMeta{"n": fmt.Sprint(i)})
+		exact.Set(id, vecs[i])
+	}
+	b.Apply()
+
+	// Delete every other vector and replace every third one,
+	// which leaves more than half of the file dead.
+	for i, id := range ids {
+		switch {
+		case i%2 == 0:
+			vdb.Delete(id)
+			exact.Delete(id)
+		case i%3 == 0:
+			vdb.Set(id, vecs[i-1])
+			exact.Set(id, vecs[i-1])
+		}
+	}
+	vdb.compactWG.Wait()
+
+	if vdb.gen == 1 {
+		t.Fatalf("no compaction")
+	}
+	if _, err := os.Stat(file + ".1"); err == nil {
+		t.Errorf("old vector file not removed")
+	}
+	for _, vdb := range []VectorDB{vdb, openDisk(t, db, file, nil)} {
+		if have, want := allIDs(vdb), allIDs(exact); !slices.Equal(have, want) {
+			t.Fatalf("All() after compaction has %d IDs, want %d", len(have), len(want))
+		}
+		if m := vdb.Meta(ids[3]); m["n"] != "3" {
+			t.Errorf("Meta(%s) = %v after compaction", ids[3], m)
+		}
+		for _, q := range queries {
+			want, _ := exact.Search(q, 10)
+			have, err := vdb.Search(q, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(have, want) {
+				t.Fatalf("Search after compaction:\nhave %v\nwant %v", have, want)
+			}
+		}
+	}
+}
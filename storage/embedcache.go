@@ -0,0 +1,116 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+
+	"github.com/superryanguo/ryai/llm"
+	"rsc.io/ordered"
+)
+
+// An embedCache is an [llm.Embedder] that caches
+// the vectors computed by another Embedder in a DB.
+type embedCache struct {
+	db    DB
+	slog  *slog.Logger
+	model string
+	e     llm.Embedder
+}
+
+// CachedEmbedder returns an [llm.Embedder] that caches the vectors
+// computed by e in db, so that documents embedded before,
+// even by an earlier process using the same db, are not sent to e again.
+// Only the documents missing from the cache are passed to e,
+// in a single call to e.EmbedDocs; a document that appears
+// more than once in a call is embedded only once.
+//
+// The model names the embedding model used by e.
+// If model is empty and e has a method Model() string,
+// as the embedders created by [llm.NewBackend] do,
+// the cache uses the name it returns.
+// Vectors computed by different models are not comparable,
+// so the model is incorporated into the cache keys, which have the form
+//
+//	ordered.Encode("llm.EmbedCache", model, hash)
+//
+// where hash is the SHA-256 of the document's title and text.
+//
+// If e returns an error along with the vectors for a prefix of the
+// missing documents, the vectors it did return are cached, and
+// EmbedDocs returns the error along with the vectors for the longest
+// prefix of docs that it could compute.
+// If e returns too few vectors with no error, EmbedDocs does the same
+// but returns an error of its own.
+func CachedEmbedder(db DB, lg *slog.Logger, model string, e llm.Embedder) llm.Embedder {
+	if m, ok := e.(interface{ Model() string }); ok && model == "" {
+		model = m.Model()
+	}
+	return &embedCache{db: db, slog: lg, model: model, e: e}
+}
+
+// key returns the db key for the vector of doc.
+func (c *embedCache) key(doc llm.EmbedDoc) []byte {
+	h := sha256.Sum256(ordered.Encode(doc.Title, doc.Text))
+	return ordered.Encode("llm.EmbedCache", c.model, h[:])
+}
+
+func (c *embedCache) EmbedDocs(ctx context.Context, docs []llm.EmbedDoc) ([]llm.Vector, error) {
+	vecs := make([]llm.Vector, len(docs))
+	keys := make([][]byte, len(docs))
+	var misses []llm.EmbedDoc
+	missIndex := make(map[string]int) // key -> index in misses
+	for i, doc := range docs {
+		keys[i] = c.key(doc)
+		if enc, ok := c.db.Get(keys[i]); ok {
+			if len(enc)%4 != 0 {
+				// unreachable except data corruption
+				panic(fmt.Errorf("CachedEmbedder decode key=%v bad len(val)=%d", Fmt(keys[i]), len(enc)))
+			}
+			vecs[i].Decode(enc)
+			continue
+		}
+		if _, ok := missIndex[string(keys[i])]; !ok {
+			missIndex[string(keys[i])] = len(misses)
+			misses = append(misses, doc)
+		}
+	}
+	c.slog.Debug("embed cache", "model", c.model, "docs", len(docs), "misses", len(misses))
+	if len(misses) == 0 {
+		return vecs, nil
+	}
+
+	computed, err := c.e.EmbedDocs(ctx, misses)
+	if len(computed) > len(misses) {
+		computed = computed[:len(misses)]
+	}
+	if err == nil && len(computed) < len(misses) {
+		err = fmt.Errorf("CachedEmbedder: embedded %d docs, got %d vectors", len(misses), len(computed))
+	}
+	b := c.db.Batch()
+	for i, doc := range misses {
+		if i >= len(computed) {
+			break
+		}
+		b.Set(c.key(doc), computed[i].Encode())
+		b.MaybeApply()
+	}
+	b.Apply()
+
+	for i := range docs {
+		j, ok := missIndex[string(keys[i])]
+		if !ok {
+			continue // cached
+		}
+		if j >= len(computed) {
+			return vecs[:i], err
+		}
+		vecs[i] = computed[j]
+	}
+	return vecs, err
+}
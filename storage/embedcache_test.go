@@ -0,0 +1,123 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/testutil"
+)
+
+// countEmbedder is an [llm.QuoteEmbedder] that records
+// the documents it is asked to embed and can be made to fail
+// after embedding a given number of documents.
+type countEmbedder struct {
+	docs  []llm.EmbedDoc // documents embedded so far
+	fail  int            // if > 0, fail after embedding this many documents in a call
+	short bool           // with fail, return the embedded documents without an error
+}
+
+var errEmbed = errors.New("embed failed")
+
+func (e *countEmbedder) EmbedDocs(ctx context.Context, docs []llm.EmbedDoc) ([]llm.Vector, error) {
+	var err error
+	if e.fail > 0 && len(docs) > e.fail {
+		docs, err = docs[:e.fail], errEmbed
+		if e.short {
+			err = nil
+		}
+	}
+	e.docs = append(e.docs, docs...)
+	vecs, _ := llm.QuoteEmbedder().EmbedDocs(ctx, docs)
+	return vecs, err
+}
+
+func TestCachedEmbedder(t *testing.T) {
+	ctx := context.Background()
+	lg := testutil.Slogger(t)
+	db := MemDB()
+	base := new(countEmbedder)
+
+	docs := []llm.EmbedDoc{
+		{Text: "for loops"},
+		{Title: "t", Text: "for all time, always"},
+		{Text: "for loops"},
+		{Text: "t"},
+	}
+	want, _ := llm.QuoteEmbedder().EmbedDocs(ctx, docs)
+
+	embed := func(e llm.Embedder, docs []llm.EmbedDoc, wantSent ...llm.EmbedDoc) []llm.Vector {
+		t.Helper()
+		base.docs = nil
+		vecs, err := e.EmbedDocs(ctx, docs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(base.docs, wantSent) {
+			t.Errorf("sent %q, want %q", base.docs, wantSent)
+		}
+		return vecs
+	}
+	check := func(vecs, want []llm.Vector) {
+		t.Helper()
+		if !slices.EqualFunc(vecs, want, slices.Equal) {
+			t.Errorf("EmbedDocs = %v, want %v", vecs, want)
+		}
+	}
+
+	e := CachedEmbedder(db, lg, "m1", base)
+	check(embed(e, docs, docs[0], docs[1], docs[3]), want)
+	check(embed(e, docs), want)
+
+	// A new embedder over the same db uses the stored vectors,
+	// and only the new document is sent.
+	more := append(slices.Clip(docs), llm.EmbedDoc{Text: "new"})
+	e = CachedEmbedder(db, lg, "m1", base)
+	wantMore, _ := llm.QuoteEmbedder().EmbedDocs(ctx, more)
+	check(embed(e, more, more[4]), wantMore)
+
+	// A different model does not share the cache.
+	e = CachedEmbedder(db, lg, "m2", base)
+	check(embed(e, docs[:2], docs[:2]...), want[:2])
+
+	// On a partial failure, the computed prefix is returned and cached.
+	base.fail = 1
+	e = CachedEmbedder(db, lg, "m3", base)
+	base.docs = nil
+	vecs, err := e.EmbedDocs(ctx, docs)
+	if !errors.Is(err, errEmbed) {
+		t.Fatalf("EmbedDocs err = %v, want %v", err, errEmbed)
+	}
+	check(vecs, want[:1])
+	base.fail = 0
+	check(embed(e, docs, docs[1], docs[3]), want)
+
+	// Too few vectors without an error is an error.
+	base.fail, base.short = 1, true
+	e = CachedEmbedder(db, lg, "m4", base)
+	vecs, err = e.EmbedDocs(ctx, docs)
+	if err == nil || len(vecs) != 1 {
+		t.Fatalf("EmbedDocs with short result = %d vectors, %v, want 1 vector and error", len(vecs), err)
+	}
+	base.fail, base.short = 0, false
+
+	// With no model, the embedder's own model names the cache.
+	e = CachedEmbedder(db, lg, "", &modelEmbedder{base, "m1"})
+	check(embed(e, docs), want)
+	e = CachedEmbedder(db, lg, "", &modelEmbedder{base, "m5"})
+	check(embed(e, docs[:2], docs[:2]...), want[:2])
+}
+
+// A modelEmbedder is an embedder reporting its model name.
+type modelEmbedder struct {
+	*countEmbedder
+	model string
+}
+
+func (e *modelEmbedder) Model() string { return e.model }
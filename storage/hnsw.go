@@ -0,0 +1,958 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/superryanguo/ryai/llm"
+	"rsc.io/omap"
+	"rsc.io/ordered"
+	"rsc.io/top"
+)
+
+// HNSWOptions are the parameters of an [HNSWVectorDB].
+// A zero field means the default value for that field.
+type HNSWOptions struct {
+	// M is the number of neighbors each vector is linked to
+	// in each layer of the graph above the lowest,
+	// which links to 2×M neighbors (default 16).
+	// Larger values improve recall at the cost of memory and insert time.
+	M int
+
+	// EfConstruction is the number of candidate neighbors
+	// considered when inserting a vector (default 200).
+	// Larger values build a better graph, improving recall,
+	// at the cost of insert time.
+	EfConstruction int
+
+	// EfSearch is the number of candidates considered
+	// by Search (default 64). Search always considers at least
+	// as many candidates as the number of results requested.
+	// Larger values improve recall at the cost of search time.
+	// Use [Recall] to measure the effect of a setting.
+	EfSearch int
+
+	// Metric is the similarity metric used by Search,
+	// and to build the graph.
+	Metric Metric
+
+	// Dim, if non-zero, is the length of every vector in the database.
+	// Set and Search reject vectors of any other length.
+	Dim int
+}
+
+func (o *HNSWOptions) m() int {
+	if o.M > 0 {
+		return o.M
+	}
+	return 16
+}
+
+func (o *HNSWOptions) efConstruction() int {
+	if o.EfConstruction > 0 {
+		return o.EfConstruction
+	}
+	return 200
+}
+
+func (o *HNSWOptions) efSearch() int {
+	if o.EfSearch > 0 {
+		return o.EfSearch
+	}
+	return 64
+}
+
+// An hnswDB is a VectorDB implementing approximate nearest-neighbor
+// search with a hierarchical navigable small world graph,
+// storing its vectors and graph in an underlying DB.
+type hnswDB struct {
+	storage   DB
+	slog      *slog.Logger
+	namespace string
+	opts      HNSWOptions
+	cfg       vectorConfig // stored Metric and Dim
+
+	mu     sync.RWMutex
+	ids    omap.Map[string, *hnswNode] // all vectors, indexed by id
+	graphs map[int]*hnswGraph          // graphs, indexed by vector length
+	meta   map[string]VectorMeta       // all metadata, indexed by id
+	dirty  map[*hnswNode]bool          // nodes with links not yet written to storage
+	rand   *rand.Rand                  // source of node levels
+}
+
+// An hnswGraph is the graph of all vectors of a single length.
+type hnswGraph struct {
+	nodes   []*hnswNode // nodes by index; nil for deleted nodes
+	entry   int32       // index of entry point (highest level node), or -1
+	deleted int         // number of nil entries in nodes
+}
+
+// An hnswNode is a single vector in an hnswGraph.
+// Searches also use an hnswNode, not in any graph, for the target vector.
+type hnswNode struct {
+	id    string
+	vec   llm.Vector
+	norm2 float64   // vec.Dot(vec)
+	index int32     // index in graph nodes
+	links [][]int32 // links[l] lists the neighbors in layer l
+}
+
+// HNSWVectorDB returns a VectorDB that stores its vectors in db
+// and implements Search using a hierarchical navigable small world
+// (HNSW) graph, as described by Malkov and Yashunin in
+// “Efficient and robust approximate nearest neighbor search using
+// Hierarchical Navigable Small World graphs” (2016).
+//
+// Unlike [MemVectorDB], whose Search time grows linearly with the number
+// of stored vectors, HNSWVectorDB's Search time grows logarithmically.
+// The price is that the search is approximate:
+// Search may miss some of the most similar vectors.
+// The options (which may be nil) trade off the recall of Search
+// against memory and time; see [HNSWOptions].
+//
+// The graph is stored in db along with the vectors,
+// so that it need not be rebuilt when HNSWVectorDB is called again.
+// HNSWVectorDB reads all previously stored vectors and links from db;
+// after that, changes must be made using the HNSWVectorDB methods.
+// Each Set or Delete, or each [VectorBatch] Apply, updates the graph
+// and writes the changed links to db in a single atomic batch.
+//
+// Vectors of different lengths are kept in separate graphs,
+// so that Search can ignore vectors with a different length
+// than the target.
+//
+// The options' Metric and Dim are recorded in db for later calls
+// to HNSWVectorDB with nil options, like [NewMemVectorDB] does.
+// Changing the metric of an existing database rebuilds the graph.
+//
+// Deleting a vector reconnects its neighbors to each other,
+// but a graph that has seen many deletions may find
+// fewer of the nearest neighbors than one built from scratch.
+//
+// The db keys used by an HNSWVectorDB have the forms
+//
+//	ordered.Encode("llm.HNSW", namespace, "vec", id)
+//	ordered.Encode("llm.HNSW", namespace, "link", id)
+//	ordered.Encode("llm.HNSW", namespace, "meta", id)
+//	ordered.Encode("llm.HNSW", namespace, "config")
+//
+// where id is the document ID passed to Set or SetMeta.
+// The "vec" key holds the vector, the "link" key
+// holds the IDs of the vector's neighbors in each layer of the graph,
+// the "meta" key holds the JSON-encoded metadata,
+// and the "config" key holds the metric and dimension.
+func HNSWVectorDB(db DB, lg *slog.Logger, namespace string, opts *HNSWOptions) VectorDB {
+	vdb := &hnswDB{
+		storage:   db,
+		slog:      lg,
+		namespace: namespace,
+		graphs:    make(map[int]*hnswGraph),
+		meta:      make(map[string]VectorMeta),
+		dirty:     make(map[*hnswNode]bool),
+		rand:      rand.New(rand.NewPCG(1, 2)),
+	}
+	cfg, stored := loadVectorConfig(db, vdb.key("config"))
+	vdb.cfg = *cfg
+	if opts != nil {
+		vdb.opts = *opts
+		vdb.cfg = vectorConfig{Metric: opts.Metric, Dim: opts.Dim}
+		if !stored || vdb.cfg != *cfg {
+			db.Set(vdb.key("config"), JSON(&vdb.cfg))
+		}
+	}
+
+	// Load all the previously-stored vectors.
+	n := 0
+	for key, getVal := range db.Scan(vdb.key("vec"), vdb.key("vec", ordered.Inf)) {
+		var id string
+		if err := ordered.Decode(key, nil, nil, nil, &id); err != nil {
+			// unreachable except data corruption
+			panic(fmt.Errorf("HNSWVectorDB decode key=%v: %v", Fmt(key), err))
+		}
+		val := getVal()
+		if len(val)%4 != 0 {
+			// unreachable except data corruption
+			panic(fmt.Errorf("HNSWVectorDB decode key=%v bad len(val)=%d", Fmt(key), len(val)))
+		}
+		var vec llm.Vector
+		vec.Decode(val)
+		vec = vdb.cfg.Metric.prepare(vec)
+		g := vdb.graph(len(vec))
+		node := &hnswNode{id: id, vec: vec, norm2: vec.Dot(vec), index: int32(len(g.nodes))}
+		g.nodes = append(g.nodes, node)
+		vdb.ids.Set(id, node)
+		n++
+	}
+
+	// Load the links between them,
+	// unless the graph must be rebuilt for a new metric.
+	links := db.Scan(vdb.key("link"), vdb.key("link", ordered.Inf))
+	if vdb.cfg.Metric != cfg.Metric {
+		links = func(func([]byte, func() []byte) bool) {}
+	}
+	for key, getVal := range links {
+		var id string
+		if err := ordered.Decode(key, nil, nil, nil, &id); err != nil {
+			// unreachable except data corruption
+			panic(fmt.Errorf("HNSWVectorDB decode key=%v: %v", Fmt(key), err))
+		}
+		node, ok := vdb.ids.Get(id)
+		if !ok {
+			continue
+		}
+		links, err := decodeLinks(getVal())
+		if err != nil {
+			// unreachable except data corruption
+			panic(fmt.Errorf("HNSWVectorDB decode key=%v: %v", Fmt(key), err))
+		}
+		node.links = make([][]int32, len(links))
+		for l, ids := range links {
+			node.links[l] = []int32{}
+			for _, id := range ids {
+				if nb, ok := vdb.ids.Get(id); ok && len(nb.vec) == len(node.vec) {
+					node.links[l] = append(node.links[l], nb.index)
+				}
+			}
+		}
+	}
+
+	// Load the metadata.
+	for key, getVal := range db.Scan(vdb.key("meta"), vdb.key("meta", ordered.Inf)) {
+		var id string
+		if err := ordered.Decode(key, nil, nil, nil, &id); err != nil {
+			// unreachable except data corruption
+			panic(fmt.Errorf("HNSWVectorDB decode key=%v: %v", Fmt(key), err))
+		}
+		var meta VectorMeta
+		if err := json.Unmarshal(getVal(), &meta); err != nil {
+			// unreachable except data corruption
+			panic(fmt.Errorf("HNSWVectorDB decode key=%v: %v", Fmt(key), err))
+		}
+		vdb.meta[id] = meta
+	}
+
+	// Find each graph's entry point, and link any vectors
+	// that were stored without links.
+	var unlinked []*hnswNode
+	for _, g := range vdb.graphs {
+		for _, node := range g.nodes {
+			if node.links == nil {
+				unlinked = append(unlinked, node)
+			} else if g.entry < 0 || len(node.links) > len(g.nodes[g.entry].links) {
+				g.entry = node.index
+			}
+		}
+	}
+	if len(unlinked) > 0 {
+		slices.SortFunc(unlinked, func(x, y *hnswNode) int { return strings.Compare(x.id, y.id) })
+		for _, node := range unlinked {
+			vdb.insert(vdb.graphs[len(node.vec)], node)
+		}
+		b := db.Batch()
+		vdb.writeDirty(b)
+		b.Apply()
+		vdb.slog.Info("linked hnsw vectors", "n", len(unlinked), "namespace", namespace)
+	}
+
+	vdb.slog.Info("loaded hnsw vectordb", "n", n, "namespace", namespace)
+	return vdb
+}
+
+// key returns the db key for the given key elements in vdb's namespace.
+func (vdb *hnswDB) key(list ...any) []byte {
+	return ordered.Encode(append([]any{"llm.HNSW", vdb.namespace}, list...)...)
+}
+
+// graph returns the graph for vectors of length n, creating it if needed.
+func (vdb *hnswDB) graph(n int) *hnswGraph {
+	g := vdb.graphs[n]
+	if g == nil {
+		g = &hnswGraph{entry: -1}
+		vdb.graphs[n] = g
+	}
+	return g
+}
+
+// maxLinks returns the maximum number of links from a node in layer l.
+func (vdb *hnswDB) maxLinks(l int) int {
+	if l == 0 {
+		return 2 * vdb.opts.m()
+	}
+	return vdb.opts.m()
+}
+
+// randomLevel returns a random level for a new node,
+// following an exponentially decaying distribution.
+func (vdb *hnswDB) randomLevel() int {
+	mL := 1 / math.Log(float64(vdb.opts.m()))
+	return min(int(-math.Log(1-vdb.rand.Float64())*mL), 32)
+}
+
+func (vdb *hnswDB) Set(id string, vec llm.Vector) error {
+	if len(id) == 0 {
+		vdb.storage.Panic("hnswVectorDB set: empty ID")
+	}
+	vec, err := vdb.prepare(vec)
+	if err != nil {
+		return err
+	}
+	vdb.mu.Lock()
+	defer vdb.mu.Unlock()
+
+	b := vdb.storage.Batch()
+	vdb.set(b, id, vec)
+	vdb.writeDirty(b)
+	b.Apply()
+	return nil
+}
+
+// prepare checks that vec has the right dimension and returns
+// a copy prepared for vdb's metric.
+func (vdb *hnswDB) prepare(vec llm.Vector) (llm.Vector, error) {
+	if err := checkDim(vdb.namespace, vdb.cfg.Dim, vec); err != nil {
+		return nil, err
+	}
+	vec = slices.Clone(vdb.cfg.Metric.prepare(vec))
+	if vec == nil {
+		vec = llm.Vector{}
+	}
+	return vec, nil
+}
+
+// sim returns the similarity of the vectors of nodes x and y.
+func (vdb *hnswDB) sim(x, y *hnswNode) float64 {
+	return vdb.cfg.Metric.sim(x.vec.Dot(y.vec), x.norm2, y.norm2)
+}
+
+func (vdb *hnswDB) Delete(id string) {
+	vdb.mu.Lock()
+	defer vdb.mu.Unlock()
+
+	b := vdb.storage.Batch()
+	vdb.delete(b, id)
+	vdb.setMeta(b, id, nil)
+	vdb.writeDirty(b)
+	b.Apply()
+}
+
+func (vdb *hnswDB) SetMeta(id string, meta VectorMeta) {
+	if len(id) == 0 {
+		vdb.storage.Panic("hnswVectorDB set meta: empty ID")
+	}
+	vdb.mu.Lock()
+	defer vdb.mu.Unlock()
+
+	b := vdb.storage.Batch()
+	vdb.setMeta(b, id, maps.Clone(meta))
+	b.Apply()
+}
+
+// setMeta sets the metadata for id and records the change in b.
+// The caller must hold vdb.mu.
+func (vdb *hnswDB) setMeta(b Batch, id string, meta VectorMeta) {
+	if len(meta) == 0 {
+		delete(vdb.meta, id)
+		b.Delete(vdb.key("meta", id))
+		return
+	}
+	vdb.meta[id] = meta
+	b.Set(vdb.key("meta", id), JSON(meta))
+}
+
+func (vdb *hnswDB) Meta(id string) VectorMeta {
+	vdb.mu.RLock()
+	defer vdb.mu.RUnlock()
+	return vdb.meta[id]
+}
+
+// set adds vec to the graph, replacing any existing vector for id,
+// and records the change in b.
+// The caller must hold vdb.mu and call writeDirty before applying b.
+func (vdb *hnswDB) set(b Batch, id string, vec llm.Vector) {
+	vdb.delete(b, id)
+	g := vdb.graph(len(vec))
+	node := &hnswNode{id: id, vec: vec, norm2: vec.Dot(vec), index: int32(len(g.nodes))}
+	g.nodes = append(g.nodes, node)
+	vdb.ids.Set(id, node)
+	vdb.insert(g, node)
+	b.Set(vdb.key("vec", id), vec.Encode())
+}
+
+// delete deletes any vector (but not metadata) for id from the graph
+// and records the change in b.
+// The caller must hold vdb.mu and call writeDirty before applying b.
+func (vdb *hnswDB) delete(b Batch, id string) {
+	node, ok := vdb.ids.Get(id)
+	if !ok {
+		return
+	}
+	vdb.ids.Delete(id)
+	delete(vdb.dirty, node)
+	b.Delete(vdb.key("vec", id))
+	b.Delete(vdb.key("link", id))
+
+	g := vdb.graphs[len(node.vec)]
+	g.nodes[node.index] = nil
+	g.deleted++
+
+	// Reconnect the node's neighbors, which most likely linked back to it,
+	// to the node's other neighbors.
+	for l, links := range node.links {
+		for _, i := range links {
+			nb := g.nodes[i]
+			if nb == nil || !slices.Contains(nb.links[l], node.index) {
+				continue
+			}
+			cands := make(map[int32]bool)
+			for _, j := range slices.Concat(nb.links[l], links) {
+				if j != nb.index && g.nodes[j] != nil {
+					cands[j] = true
+				}
+			}
+			nb.links[l] = vdb.selectLinks(g, vdb.scored(g, nb, cands), vdb.maxLinks(l))
+			vdb.dirty[nb] = true
+		}
+	}
+
+	if g.entry == node.index {
+		g.entry = -1
+		for _, n := range g.nodes {
+			if n != nil && (g.entry < 0 || len(n.links) > len(g.nodes[g.entry].links)) {
+				g.entry = n.index
+			}
+		}
+	}
+	if g.entry < 0 {
+		delete(vdb.graphs, len(node.vec))
+	} else if g.deleted >= minCompact && 2*g.deleted > len(g.nodes) {
+		g.compact()
+	}
+}
+
+// minCompact is the minimum number of deleted nodes
+// that causes a graph to be compacted.
+const minCompact = 64
+
+// compact removes the deleted nodes from g.nodes,
+// renumbering the remaining nodes and their links.
+// Without compaction, a graph with many replaced or deleted
+// vectors would grow without bound, and so would the
+// visited sets allocated by searchLayer.
+// The links are stored by ID, so compaction changes nothing in storage.
+// The caller must hold vdb.mu.
+func (g *hnswGraph) compact() {
+	index := make([]int32, len(g.nodes)) // new index of each node, or -1
+	var nodes []*hnswNode
+	for i, n := range g.nodes {
+		if n == nil {
+			index[i] = -1
+			continue
+		}
+		index[i] = int32(len(nodes))
+		n.index = index[i]
+		nodes = append(nodes, n)
+	}
+	for _, n := range nodes {
+		for l, links := range n.links {
+			// Drop links to deleted nodes that did not link back.
+			links = slices.DeleteFunc(links, func(i int32) bool { return index[i] < 0 })
+			for k, i := range links {
+				links[k] = index[i]
+			}
+			n.links[l] = links
+		}
+	}
+	g.nodes, g.entry, g.deleted = nodes, index[g.entry], 0
+}
+
+// insert links node, which must already be in g.nodes, into the graph.
+// The caller must hold vdb.mu.
+func (vdb *hnswDB) insert(g *hnswGraph, node *hnswNode) {
+	level := vdb.randomLevel()
+	node.links = make([][]int32, level+1)
+	for l := range node.links {
+		node.links[l] = []int32{}
+	}
+	vdb.dirty[node] = true
+	if g.entry < 0 {
+		g.entry = node.index
+		return
+	}
+
+	entry := g.nodes[g.entry]
+	maxLevel := len(entry.links) - 1
+	eps := []hnswCand{{entry.index, vdb.sim(node, entry)}}
+	for l := maxLevel; l > level; l-- {
+		eps = vdb.searchLayer(g, node, eps, 1, l, nil)
+	}
+	for l := min(level, maxLevel); l >= 0; l-- {
+		eps = vdb.searchLayer(g, node, eps, vdb.opts.efConstruction(), l, nil)
+		node.links[l] = vdb.selectLinks(g, eps, vdb.opts.m())
+		for _, i := range node.links[l] {
+			nb := g.nodes[i]
+			nb.links[l] = append(nb.links[l], node.index)
+			if len(nb.links[l]) > vdb.maxLinks(l) {
+				cands := make(map[int32]bool)
+				for _, j := range nb.links[l] {
+					if g.nodes[j] != nil {
+						cands[j] = true
+					}
+				}
+				nb.links[l] = vdb.selectLinks(g, vdb.scored(g, nb, cands), vdb.maxLinks(l))
+			}
+			vdb.dirty[nb] = true
+		}
+	}
+	if level > maxLevel {
+		g.entry = node.index
+	}
+}
+
+// selectLinks returns up to m of the candidates, which must be sorted
+// by decreasing similarity to a node, to link to that node.
+// It uses the heuristic from the HNSW paper, preferring candidates
+// that are more similar to the node than to any already-selected candidate,
+// so that the links spread out in different directions,
+// and then filling any remaining slots with the best remaining candidates.
+func (vdb *hnswDB) selectLinks(g *hnswGraph, cands []hnswCand, m int) []int32 {
+	var links, pruned []int32
+	for _, c := range cands {
+		if len(links) >= m {
+			break
+		}
+		cnode := g.nodes[c.i]
+		good := true
+		for _, i := range links {
+			if vdb.sim(cnode, g.nodes[i]) > c.score {
+				good = false
+				break
+			}
+		}
+		if good {
+			links = append(links, c.i)
+		} else {
+			pruned = append(pruned, c.i)
+		}
+	}
+	for _, i := range pruned {
+		if len(links) >= m {
+			break
+		}
+		links = append(links, i)
+	}
+	if links == nil {
+		links = []int32{}
+	}
+	return links
+}
+
+// writeDirty records the links of all dirty nodes in b.
+// The caller must hold vdb.mu.
+func (vdb *hnswDB) writeDirty(b Batch) {
+	for node := range vdb.dirty {
+		g := vdb.graphs[len(node.vec)]
+		links := make([][]string, len(node.links))
+		for l, ls := range node.links {
+			for _, i := range ls {
+				if nb := g.nodes[i]; nb != nil {
+					links[l] = append(links[l], nb.id)
+				}
+			}
+		}
+		b.Set(vdb.key("link", node.id), encodeLinks(links))
+	}
+	clear(vdb.dirty)
+}
+
+func (vdb *hnswDB) Get(id string) (llm.Vector, bool) {
+	vdb.mu.RLock()
+	node, ok := vdb.ids.Get(id)
+	vdb.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return node.vec, true
+}
+
+// All returns all ID-vector pairs in lexicographic order of IDs.
+func (vdb *hnswDB) All() iter.Seq2[string, func() llm.Vector] {
+	return func(yield func(key string, val func() llm.Vector) bool) {
+		vdb.mu.RLock()
+		locked := true
+		defer func() {
+			if locked {
+				vdb.mu.RUnlock()
+			}
+		}()
+		for id, node := range vdb.ids.All() {
+			val := func() llm.Vector { return node.vec }
+			vdb.mu.RUnlock()
+			locked = false
+			if !yield(id, val) {
+				return
+			}
+			vdb.mu.RLock()
+			locked = true
+		}
+	}
+}
+
+// Search returns approximately the n vectors most similar to target.
+// It considers max(n, EfSearch) candidates; see [HNSWOptions].
+func (vdb *hnswDB) Search(target llm.Vector, n int) ([]VectorResult, error) {
+	return vdb.SearchFilter(target, n, nil)
+}
+
+// hnswScanLimit is the maximum number of vectors
+// that SearchFilter compares directly against the target
+// instead of searching the graph.
+const hnswScanLimit = 4096
+
+// SearchFilter returns approximately the n vectors matching f
+// that are most similar to target.
+//
+// When f lists IDs, or when f's prefix matches at most a few thousand IDs,
+// SearchFilter compares the matching vectors directly against
+// the target, returning exact results.
+// Otherwise, it searches the graph as usual, passing through vectors
+// that do not match f but only collecting ones that do.
+// Very selective filters can make that search visit most of the graph.
+func (vdb *hnswDB) SearchFilter(target llm.Vector, n int, f *VectorFilter) ([]VectorResult, error) {
+	if err := checkDim(vdb.namespace, vdb.cfg.Dim, target); err != nil {
+		return nil, err
+	}
+	vec := vdb.cfg.Metric.prepare(target)
+	t := &hnswNode{vec: vec, norm2: vec.Dot(vec)}
+
+	vdb.mu.RLock()
+	defer vdb.mu.RUnlock()
+
+	g := vdb.graphs[len(target)]
+	if g == nil || n <= 0 {
+		return nil, nil
+	}
+	match := f.matcher()
+	best := top.New(n, VectorResult.cmp)
+
+	if f != nil && (f.IDs != nil || f.Prefix != "") {
+		var nodes []*hnswNode
+		for id, node := range scanIDs(&vdb.ids, f) {
+			if len(nodes) > hnswScanLimit {
+				break
+			}
+			if len(node.vec) == len(target) && match(id, vdb.meta[id]) {
+				nodes = append(nodes, node)
+			}
+		}
+		if len(nodes) <= hnswScanLimit {
+			for _, node := range nodes {
+				best.Add(VectorResult{node.id, vdb.sim(t, node)})
+			}
+			return scoreResults(vdb.cfg.Metric, best.Take()), nil
+		}
+	}
+
+	var keep func(*hnswNode) bool
+	if f != nil {
+		keep = func(node *hnswNode) bool { return match(node.id, vdb.meta[node.id]) }
+	}
+	entry := g.nodes[g.entry]
+	eps := []hnswCand{{entry.index, vdb.sim(t, entry)}}
+	for l := len(entry.links) - 1; l > 0; l-- {
+		eps = vdb.searchLayer(g, t, eps, 1, l, nil)
+	}
+	eps = vdb.searchLayer(g, t, eps, max(n, vdb.opts.efSearch()), 0, keep)
+	for _, c := range eps {
+		best.Add(VectorResult{g.nodes[c.i].id, c.score})
+	}
+	return scoreResults(vdb.cfg.Metric, best.Take()), nil
+}
+
+func (vdb *hnswDB) Flush() {
+	vdb.storage.Flush()
+}
+
+// Recall returns the fraction of the results of exact.Search(q, n)
+// that are also returned by approx.Search(q, n), averaged over the queries.
+// It measures how well an approximate VectorDB, such as one returned by
+// [HNSWVectorDB], matches an exact one, such as one returned by [MemVectorDB],
+// holding the same vectors.
+func Recall(approx, exact VectorDB, queries []llm.Vector, n int) (float64, error) {
+	found, total := 0, 0
+	for _, q := range queries {
+		have := make(map[string]bool)
+		rs, err := approx.Search(q, n)
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range rs {
+			have[r.ID] = true
+		}
+		if rs, err = exact.Search(q, n); err != nil {
+			return 0, err
+		}
+		for _, r := range rs {
+			if have[r.ID] {
+				found++
+			}
+			total++
+		}
+	}
+	if total == 0 {
+		return 1, nil
+	}
+	return float64(found) / float64(total), nil
+}
+
+// An hnswCand is a candidate node and its similarity to a target vector.
+type hnswCand struct {
+	i     int32
+	score float64
+}
+
+// scored returns the candidate nodes sorted by decreasing similarity to node.
+func (vdb *hnswDB) scored(g *hnswGraph, node *hnswNode, cands map[int32]bool) []hnswCand {
+	var list []hnswCand
+	for i := range cands {
+		list = append(list, hnswCand{i, vdb.sim(node, g.nodes[i])})
+	}
+	slices.SortFunc(list, hnswCand.cmp)
+	return list
+}
+
+// cmp orders candidates by decreasing score, breaking ties by index.
+func (x hnswCand) cmp(y hnswCand) int {
+	if x.score != y.score {
+		if x.score > y.score {
+			return -1
+		}
+		return +1
+	}
+	return int(x.i - y.i)
+}
+
+// searchLayer searches layer l of g for the ef nodes most similar to t,
+// starting at the entry points eps, and returns them
+// sorted by decreasing similarity.
+// If keep is non-nil, searchLayer only returns nodes for which keep
+// returns true, although it follows links through all nodes.
+func (vdb *hnswDB) searchLayer(g *hnswGraph, t *hnswNode, eps []hnswCand, ef, l int, keep func(*hnswNode) bool) []hnswCand {
+	visited := make([]uint64, (len(g.nodes)+63)/64)
+	var cands bestFirst
+	var found worstFirst
+	for _, c := range eps {
+		visited[c.i/64] |= 1 << (c.i % 64)
+		heap.Push(&cands, c)
+		if keep == nil || keep(g.nodes[c.i]) {
+			heap.Push(&found, c)
+			if found.Len() > ef {
+				heap.Pop(&found)
+			}
+		}
+	}
+	for cands.Len() > 0 {
+		c := heap.Pop(&cands).(hnswCand)
+		if found.Len() >= ef && c.score < found[0].score {
+			break
+		}
+		node := g.nodes[c.i]
+		if node == nil || l >= len(node.links) {
+			continue
+		}
+		for _, i := range node.links[l] {
+			if visited[i/64]&(1<<(i%64)) != 0 {
+				continue
+			}
+			visited[i/64] |= 1 << (i % 64)
+			nb := g.nodes[i]
+			if nb == nil {
+				continue
+			}
+			score := vdb.sim(t, nb)
+			if found.Len() < ef || score > found[0].score {
+				heap.Push(&cands, hnswCand{i, score})
+				if keep == nil || keep(nb) {
+					heap.Push(&found, hnswCand{i, score})
+					if found.Len() > ef {
+						heap.Pop(&found)
+					}
+				}
+			}
+		}
+	}
+	slices.SortFunc(found, hnswCand.cmp)
+	return found
+}
+
+// bestFirst is a heap of candidates yielding the most similar first.
+type bestFirst []hnswCand
+
+func (h bestFirst) Len() int           { return len(h) }
+func (h bestFirst) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h bestFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *bestFirst) Push(x any)        { *h = append(*h, x.(hnswCand)) }
+func (h *bestFirst) Pop() any {
+	x := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return x
+}
+
+// worstFirst is a heap of candidates yielding the least similar first.
+type worstFirst []hnswCand
+
+func (h worstFirst) Len() int           { return len(h) }
+func (h worstFirst) Less(i, j int) bool { return h[i].score < h[j].score }
+func (h worstFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *worstFirst) Push(x any)        { *h = append(*h, x.(hnswCand)) }
+func (h *worstFirst) Pop() any {
+	x := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return x
+}
+
+// encodeLinks encodes the neighbor IDs in each layer as
+// the number of layers followed by, for each layer,
+// the number of IDs and then each length-prefixed ID,
+// all counts and lengths written as uvarints.
+func encodeLinks(links [][]string) []byte {
+	enc := binary.AppendUvarint(nil, uint64(len(links)))
+	for _, ids := range links {
+		enc = binary.AppendUvarint(enc, uint64(len(ids)))
+		for _, id := range ids {
+			enc = binary.AppendUvarint(enc, uint64(len(id)))
+			enc = append(enc, id...)
+		}
+	}
+	return enc
+}
+
+// decodeLinks decodes an encoding returned by encodeLinks.
+func decodeLinks(enc []byte) ([][]string, error) {
+	errCorrupt := errors.New("corrupt links")
+	next := func() (int, bool) {
+		n, k := binary.Uvarint(enc)
+		if k <= 0 || n > uint64(len(enc)) {
+			return 0, false
+		}
+		enc = enc[k:]
+		return int(n), true
+	}
+	nl, ok := next()
+	if !ok {
+		return nil, errCorrupt
+	}
+	links := make([][]string, nl)
+	for l := range links {
+		n, ok := next()
+		if !ok {
+			return nil, errCorrupt
+		}
+		for range n {
+			k, ok := next()
+			if !ok || k > len(enc) {
+				return nil, errCorrupt
+			}
+			links[l] = append(links[l], string(enc[:k]))
+			enc = enc[k:]
+		}
+	}
+	if len(enc) != 0 {
+		return nil, errCorrupt
+	}
+	return links, nil
+}
+
+// hnswBatchLimit is the number of pending operations
+// at which hnswBatch.MaybeApply applies the batch.
+const hnswBatchLimit = 1000
+
+// hnswBatch implements VectorBatch for an hnswDB.
+// Since each operation changes the graph, which can depend
+// on the previous operations, the operations are saved
+// and carried out in order by Apply.
+type hnswBatch struct {
+	db  *hnswDB
+	ops []hnswOp
+}
+
+// An hnswOp is a single batched operation.
+type hnswOp struct {
+	id   string
+	vec  llm.Vector // vector for Set; nil for Delete and SetMeta
+	meta VectorMeta // metadata for SetMeta
+	op   byte       // 's' for Set, 'd' for Delete, 'm' for SetMeta
+}
+
+func (vdb *hnswDB) Batch() VectorBatch {
+	return &hnswBatch{db: vdb}
+}
+
+func (b *hnswBatch) Set(id string, vec llm.Vector) error {
+	if len(id) == 0 {
+		b.db.storage.Panic("hnswVectorDB batch set: empty ID")
+	}
+	vec, err := b.db.prepare(vec)
+	if err != nil {
+		return err
+	}
+	b.ops = append(b.ops, hnswOp{id: id, vec: vec, op: 's'})
+	return nil
+}
+
+func (b *hnswBatch) Delete(id string) {
+	b.ops = append(b.ops, hnswOp{id: id, op: 'd'})
+}
+
+func (b *hnswBatch) SetMeta(id string, meta VectorMeta) {
+	if len(id) == 0 {
+		b.db.storage.Panic("hnswVectorDB batch set meta: empty ID")
+	}
+	b.ops = append(b.ops, hnswOp{id: id, meta: maps.Clone(meta), op: 'm'})
+}
+
+func (b *hnswBatch) MaybeApply() bool {
+	if len(b.ops) < hnswBatchLimit {
+		return false
+	}
+	b.Apply()
+	return true
+}
+
+func (b *hnswBatch) Apply() {
+	if len(b.ops) == 0 {
+		return
+	}
+	b.db.mu.Lock()
+	defer b.db.mu.Unlock()
+
+	sb := b.db.storage.Batch()
+	for _, op := range b.ops {
+		switch op.op {
+		case 's':
+			b.db.set(sb, op.id, op.vec)
+		case 'd':
+			b.db.delete(sb, op.id)
+			b.db.setMeta(sb, op.id, nil)
+		case 'm':
+			b.db.setMeta(sb, op.id, op.meta)
+		}
+	}
+	b.db.writeDirty(sb)
+	sb.Apply()
+	b.ops = nil
+}
@@ -0,0 +1,326 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/superryanguo/ryai/llm"
+	"github.com/superryanguo/ryai/testutil"
+	"rsc.io/ordered"
+)
+
+var hnswEf = flag.Int("hnsw.ef", 0, "also report HNSW recall at this EfSearch `setting`")
+
+func TestHNSWVectorDB(t *testing.T) {
+	db := MemDB()
+	TestVectorDB(t, func() VectorDB { return HNSWVectorDB(db, testutil.Slogger(t), "", nil) })
+}
+
+// hnswTestData returns n random unit vectors of length dim and their IDs,
+// along with 50 random queries.
+func hnswTestData(n, dim int) (ids []string, vecs, queries []llm.Vector) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range n {
+		ids = append(ids, fmt.Sprintf("doc%d", i))
+		vecs = append(vecs, randUnit(r, dim))
+	}
+	for range 50 {
+		queries = append(queries, randUnit(r, dim))
+	}
+	return ids, vecs, queries
+}
+
+func TestHNSWRecall(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	lg := testutil.Slogger(t)
+	ids, vecs, queries := hnswTestData(2000, 32)
+
+	db := MemDB()
+	exact := MemVectorDB(MemDB(), lg, "")
+	vdb := HNSWVectorDB(db, lg, "", &HNSWOptions{EfConstruction: 100})
+	b := vdb.Batch()
+	eb := exact.Batch()
+	for i, id := range ids {
+		b.Set(id, vecs[i])
+		eb.Set(id, vecs[i])
+		b.MaybeApply()
+	}
+	b.Apply()
+	eb.Apply()
+
+	const n = 10
+	efs := []int{10, 64, 256}
+	if *hnswEf > 0 {
+		efs = append(efs, *hnswEf)
+	}
+	for _, ef := range efs {
+		// Reopening the database reuses the stored graph.
+		vdb := HNSWVectorDB(db, lg, "", &HNSWOptions{EfSearch: ef})
+		recall, err := Recall(vdb, exact, queries, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("ef=%d recall@%d=%.3f", ef, n, recall)
+		if ef >= 64 && recall < 0.95 {
+			t.Errorf("ef=%d recall@%d=%.3f, want ≥ 0.95", ef, n, recall)
+		}
+	}
+	if r, err := Recall(vdb, exact, queries, n); err != nil || r < 0.95 {
+		t.Errorf("recall@%d=%.3f, %v before reopening, want ≥ 0.95", n, r, err)
+	}
+}
+
+func TestHNSWDelete(t *testing.T) {
+	lg := testutil.Slogger(t)
+	ids, vecs, queries := hnswTestData(600, 16)
+
+	db := MemDB()
+	exact := MemVectorDB(MemDB(), lg, "")
+	vdb := HNSWVectorDB(db, lg, "", &HNSWOptions{EfConstruction: 100})
+	b := vdb.Batch()
+	for i, id := range ids {
+		b.Set(id, vecs[i])
+	}
+	b.Apply()
+
+	// Delete every other vector, and replace every third one.
+	for i, id := range ids {
+		switch {
+		case i%2 == 0:
+			vdb.Delete(id)
+		case i%3 == 0:
+			vdb.Set(id, vecs[i-1])
+			exact.Set(id, vecs[i-1])
+		default:
+			exact.Set(id, vecs[i])
+		}
+	}
+
+	for _, vdb := range []VectorDB{vdb, HNSWVectorDB(db, lg, "", nil)} {
+		if have, want := allIDs(vdb), allIDs(exact); !slices.Equal(have, want) {
+			t.Fatalf("All() after deletes has %d IDs, want %d", len(have), len(want))
+		}
+		if r, err := Recall(vdb, exact, queries, 10); err != nil || r < 0.95 {
+			t.Errorf("recall@10=%.3f, %v after deletes, want ≥ 0.95", r, err)
+		}
+	}
+
+	for _, id := range ids {
+		vdb.Delete(id)
+	}
+	if r, err := vdb.Search(queries[0], 10); len(r) != 0 || err != nil {
+		t.Errorf("Search after deleting all = %v, %v, want none", r, err)
+	}
+	if have := allIDs(HNSWVectorDB(db, lg, "", nil)); len(have) != 0 {
+		t.Errorf("All() in fresh database after deleting all = %v, want none", have)
+	}
+	// Only the configuration remains.
+	n := 0
+	for key := range db.Scan(nil, []byte("\xff")) {
+		if !slices.Equal(key, ordered.Encode("llm.HNSW", "", "config")) {
+			n++
+		}
+	}
+	if n != 0 {
+		t.Errorf("database has %d keys after deleting all, want 0", n)
+	}
+}
+
+// Test that replacing and deleting vectors
+// does not grow the graph without bound.
+func TestHNSWChurn(t *testing.T) {
+	lg := testutil.Slogger(t)
+	// Three rounds of replacing most vectors are enough
+	// to compact the graph more than once.
+	ids, vecs, queries := hnswTestData(200, 16)
+	rounds := 3
+	if testing.Short() {
+		rounds = 2
+	}
+
+	db := MemDB()
+	exact := MemVectorDB(MemDB(), lg, "")
+	vdb := HNSWVectorDB(db, lg, "", &HNSWOptions{EfConstruction: 50})
+	for round := range rounds {
+		for i, id := range ids {
+			v := vecs[(i+round)%len(vecs)]
+			if (i+round)%7 == 0 {
+				vdb.Delete(id)
+				exact.Delete(id)
+				continue
+			}
+			vdb.Set(id, v)
+			exact.Set(id, v)
+		}
+	}
+	g := vdb.(*hnswDB).graphs[16]
+	if len(g.nodes) > 2*len(ids) {
+		t.Errorf("graph has %d node slots for %d vectors", len(g.nodes), len(ids))
+	}
+	for i, n := range g.nodes {
+		if n != nil && n.index != int32(i) {
+			t.Fatalf("node %s at index %d has index %d", n.id, i, n.index)
+		}
+	}
+	for _, vdb := range []VectorDB{vdb, HNSWVectorDB(db, lg, "", nil)} {
+		if have, want := allIDs(vdb), allIDs(exact); !slices.Equal(have, want) {
+			t.Fatalf("All() after churn has %d IDs, want %d", len(have), len(want))
+		}
+		if r, err := Recall(vdb, exact, queries, 10); err != nil || r < 0.95 {
+			t.Errorf("recall@10=%.3f, %v after churn, want ≥ 0.95", r, err)
+		}
+	}
+}
+
+// Test that vectors stored without links,
+// for example by an interrupted migration, are linked on load.
+func TestHNSWUnlinked(t *testing.T) {
+	lg := testutil.Slogger(t)
+	ids, vecs, queries := hnswTestData(1000, 16)
+
+	db := MemDB()
+	for i, id := range ids {
+		db.Set(ordered.Encode("llm.HNSW", "", "vec", id), vecs[i].Encode())
+	}
+	exact := MemVectorDB(MemDB(), lg, "")
+	for i, id := range ids {
+		exact.Set(id, vecs[i])
+	}
+	vdb := HNSWVectorDB(db, lg, "", nil)
+	if r, err := Recall(vdb, exact, queries, 10); err != nil || r < 0.95 {
+		t.Errorf("recall@10=%.3f, %v, want ≥ 0.95", r, err)
+	}
+	if _, ok := db.Get(ordered.Encode("llm.HNSW", "", "link", ids[0])); !ok {
+		t.Errorf("links not stored")
+	}
+}
+
+func TestHNSWLinksEncoding(t *testing.T) {
+	for _, links := range [][][]string{
+		{nil},
+		{{"a", "bc"}, {""}, nil, {"a"}},
+	} {
+		dec, err := decodeLinks(encodeLinks(links))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.EqualFunc(dec, links, slices.Equal) {
+			t.Errorf("decodeLinks(encodeLinks(%q)) = %q", links, dec)
+		}
+	}
+	for _, enc := range []string{"", "\x01", "\x01\x01\x05ab", "\x00x"} {
+		if _, err := decodeLinks([]byte(enc)); err == nil {
+			t.Errorf("decodeLinks(%q) succeeded", enc)
+		}
+	}
+}
+
+func BenchmarkHNSWSearch(b *testing.B) {
+	lg := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ids, vecs, queries := hnswTestData(20000, 256)
+	vdb := HNSWVectorDB(MemDB(), lg, "", &HNSWOptions{EfSearch: *hnswEf})
+	exact := MemVectorDB(MemDB(), lg, "")
+	vb := vdb.Batch()
+	for i, id := range ids {
+		vb.Set(id, vecs[i])
+		exact.Set(id, vecs[i])
+		vb.MaybeApply()
+	}
+	vb.Apply()
+	b.ResetTimer()
+	for i := range b.N {
+		vdb.Search(queries[i%len(queries)], 10)
+	}
+	b.StopTimer()
+	recall, err := Recall(vdb, exact, queries, 10)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(recall, "recall@10")
+}
+
+func TestHNSWFilter(t *testing.T) {
+	lg := testutil.Slogger(t)
+	ids, vecs, queries := hnswTestData(2000, 16)
+
+	exact := MemVectorDB(MemDB(), lg, "")
+	vdb := HNSWVectorDB(MemDB(), lg, "", &HNSWOptions{EfConstruction: 100})
+	for _, db := range []VectorDB{exact, vdb} {
+		b := db.Batch()
+		for i, id := range ids {
+			b.Set(id, vecs[i])
+			b.SetMeta(id, VectorMeta{"bucket": fmt.Sprint(i % 20), "rare": fmt.Sprint(i%500 == 0)})
+			b.MaybeApply()
+		}
+		b.Apply()
+	}
+
+	bucket := func(m VectorMeta) bool { return m["bucket"] == "3" }
+	rare := func(m VectorMeta) bool { return m["rare"] == "true" }
+	for _, tt := range []struct {
+		name      string
+		f         *VectorFilter
+		minRecall float64
+	}{
+		{"bucket", &VectorFilter{Match: bucket}, 0.95},
+		{"rare", &VectorFilter{Match: rare}, 1},
+		{"prefix", &VectorFilter{Prefix: "doc1"}, 1}, // few enough to scan
+		{"prefix-bucket", &VectorFilter{Prefix: "doc", Match: bucket}, 0.95},
+		{"ids", &VectorFilter{IDs: ids[:100]}, 1},
+	} {
+		found, total := 0, 0
+		for _, q := range queries {
+			want, err := exact.SearchFilter(q, 10, tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			have, err := vdb.SearchFilter(q, 10, tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(have) != len(want) {
+				t.Fatalf("%s: SearchFilter returned %d results, want %d", tt.name, len(have), len(want))
+			}
+			match := tt.f.matcher()
+			haveIDs := make(map[string]bool)
+			for _, r := range have {
+				if !match(r.ID, vdb.Meta(r.ID)) {
+					t.Fatalf("%s: SearchFilter returned non-matching %s", tt.name, r.ID)
+				}
+				haveIDs[r.ID] = true
+			}
+			for _, r := range want {
+				if haveIDs[r.ID] {
+					found++
+				}
+				total++
+			}
+		}
+		recall := float64(found) / float64(total)
+		t.Logf("%s: recall@10=%.3f", tt.name, recall)
+		if recall < tt.minRecall {
+			t.Errorf("%s: recall@10=%.3f, want ≥ %.3f", tt.name, recall, tt.minRecall)
+		}
+	}
+}
+
+func TestHNSWMetric(t *testing.T) {
+	lg := testutil.Slogger(t)
+	testMetric(t, func(db DB, opts *VectorOptions) VectorDB {
+		if opts == nil {
+			return HNSWVectorDB(db, lg, "", nil)
+		}
+		return HNSWVectorDB(db, lg, "", &HNSWOptions{Metric: opts.Metric, Dim: opts.Dim})
+	})
+}
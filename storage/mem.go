@@ -6,16 +6,18 @@ package storage
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"iter"
 	"log/slog"
+	"maps"
+	"runtime"
 	"slices"
 	"sync"
 
 	"github.com/superryanguo/ryai/llm"
 	"rsc.io/omap"
 	"rsc.io/ordered"
-	"rsc.io/top"
 )
 
 // A MemLocker is a single-process implementation
@@ -197,9 +199,38 @@ type memVectorDB struct {
 	storage   DB
 	slog      *slog.Logger
 	namespace string
+	cfg       vectorConfig
 
 	mu    sync.RWMutex
-	cache omap.Map[string, []float32] // in-memory cache of all vectors, indexed by id
+	cache omap.Map[string, memVector] // in-memory cache of all vectors, indexed by id
+	meta  map[string]VectorMeta       // in-memory cache of all metadata, indexed by id
+	slots map[string]int              // index in vecs of each cached vector
+	vecs  []memEntry                  // cached vectors in no particular order, for sharding Search
+}
+
+// A memVector is a stored vector along with its squared length.
+type memVector struct {
+	q     llm.QuantizedVector
+	norm2 float64
+}
+
+func newMemVector(q llm.QuantizedVector) memVector {
+	return memVector{q, q.Dot(q.Vector())}
+}
+
+// dot returns the dot product of v and w.
+// For float32 vectors, it uses the faster [llm.Vector.Dot32].
+func (v memVector) dot(w llm.Vector) float64 {
+	if v.q.Precision() == llm.Float32 {
+		return float64(v.q.Vector().Dot32(w))
+	}
+	return v.q.Dot(w)
+}
+
+// A memEntry is a single cached vector in memVectorDB.vecs.
+type memEntry struct {
+	id string
+	v  memVector
 }
 
 // MemVectorDB returns a VectorDB that stores its vectors in db
@@ -213,47 +244,56 @@ type memVectorDB struct {
 // from db; after that, changes must be made using the MemVectorDB
 // Set method.
 //
-// A MemVectorDB requires approximately 3kB of memory per stored vector.
+// MemVectorDB stores and compares vectors as previously configured
+// for the namespace by [NewMemVectorDB], or else as float32 vectors
+// compared by the [Cosine] metric, with no declared dimension.
+// A MemVectorDB storing float32 vectors requires approximately 3kB of memory
+// per stored 768-entry vector; int16 halves that, and int8 halves it again.
 //
-// The db keys used by a MemVectorDB have the form
+// The db keys used by a MemVectorDB have the forms
 //
 //	ordered.Encode("llm.Vector", namespace, id)
+//	ordered.Encode("llm.VectorMeta", namespace, id)
 //
-// where id is the document ID passed to Set.
+// where id is the document ID passed to Set or SetMeta.
+// The first holds the vector and the second the JSON-encoded metadata.
 func MemVectorDB(db DB, lg *slog.Logger, namespace string) VectorDB {
-	// NOTE: We could cut the memory per stored vector in half by quantizing to int16.
-	//
-	// The worst case score error in a dot product over 768 entries
-	// caused by quantization error of e is approximately 55.4 e:
-	//
-	// For a unit vector v of length N, the way to maximize Σ v[i] is to make
-	// all the vector entries the same value x, such that sqrt(N x²) = 1,
-	// so x = 1/sqrt(N). The maximum of Σ v[i] is therefore N/sqrt(N).
-	//
-	// Looking at the dot product error for v₁ · v₂ caused by adding
-	// quantization error vectors e₁ and e₂:
-	//
-	// 	|Σ v₁[i]*v₂[i] - Σ (v₁[i]+e₁[i])*(v₂[i]+e₂[i])| =
-	//	|Σ v₁[i]*v₂[i] - Σ (v₁[i]*v₂[i] + e₁[i]*v₂[i] + e₂[i]*v₁[i] + e₁[i]*e₂[i])| =
-	//	|Σ (e₁[i]*v₂[i] + e₂[i]*v₁[i] + e₁[i]*e₂[i])| ≤
-	//	Σ |e₁[i]*v₂[i]| + Σ |e₂[i]*v₁[i]| + Σ |e₁[i]*e₂[i]| ≤
-	//	e × (Σ v₁[i] + Σ v₂[i]) + N e² ≤
-	//	e × 2 × N/sqrt(N) + N e² =
-	//	e × (2 × N/sqrt(N) + e) ~= 55.4 e for N=768.
-	//
-	// Quantizing the float32 range [-1,+1] to int16 range [-32768,32767]
-	// would introduce a maximum quantization error e of
-	// ½ × (+1 - -1) / (32767 - -32768) = 1/65535 = 0.000015259,
-	// resulting in a maximum dot product error of approximately 0.00846,
-	// which would not change the result order significantly.
+	return NewMemVectorDB(db, lg, namespace, nil)
+}
 
+// NewMemVectorDB is like [MemVectorDB] but stores and compares vectors
+// as configured by opts, recording the configuration in db
+// for later calls to MemVectorDB.
+// If opts is nil, NewMemVectorDB is the same as MemVectorDB.
+//
+// If the namespace already holds vectors stored with a different precision,
+// or not scaled to unit length for the Cosine metric,
+// NewMemVectorDB converts them in a single atomic batch.
+// Converting to a lower precision loses information, so converting back
+// to a higher precision does not restore the original vectors.
+// Stored vectors with a length other than a newly declared dimension
+// are kept but ignored by Search.
+func NewMemVectorDB(db DB, lg *slog.Logger, namespace string, opts *VectorOptions) VectorDB {
+	cfg, stored := loadVectorConfig(db, vectorConfigKey(namespace))
 	vdb := &memVectorDB{
 		storage:   db,
 		slog:      lg,
 		namespace: namespace,
+		cfg:       *cfg,
+		meta:      make(map[string]VectorMeta),
+		slots:     make(map[string]int),
+	}
+	if opts != nil {
+		vdb.cfg = vectorConfig{Precision: opts.Precision, Metric: opts.Metric, Dim: opts.Dim}
 	}
 
-	// Load all the previously-stored vectors.
+	// Load all the previously-stored vectors,
+	// converting them to the new configuration if needed.
+	var b Batch
+	if vdb.cfg.Precision != cfg.Precision || vdb.cfg.Metric == Cosine && cfg.Metric != Cosine {
+		b = db.Batch()
+	}
+	bad := 0
 	clen := 0
 	for key, getVal := range vdb.storage.Scan(
 		ordered.Encode("llm.Vector", namespace),
@@ -264,22 +304,63 @@ func MemVectorDB(db DB, lg *slog.Logger, namespace string) VectorDB {
 			// unreachable except data corruption
 			panic(fmt.Errorf("MemVectorDB decode key=%v: %v", Fmt(key), err))
 		}
-		val := getVal()
-		if len(val)%4 != 0 {
+		q, err := cfg.Precision.Decode(getVal())
+		if err != nil {
 			// unreachable except data corruption
-			panic(fmt.Errorf("MemVectorDB decode key=%v bad len(val)=%d", Fmt(key), len(val)))
+			panic(fmt.Errorf("MemVectorDB decode key=%v: %v", Fmt(key), err))
+		}
+		if b != nil {
+			q = vdb.quantize(q.Vector())
+			b.Set(key, q.Encode())
 		}
-		var vec llm.Vector
-		vec.Decode(val)
-		vdb.cache.Set(id, vec)
+		if checkDim(namespace, vdb.cfg.Dim, q.Vector()) != nil {
+			bad++
+		}
+		vdb.put(id, newMemVector(q))
 		clen++
 	}
+	if opts != nil && (!stored || vdb.cfg != *cfg) {
+		if b == nil {
+			b = db.Batch()
+		}
+		b.Set(vectorConfigKey(namespace), JSON(&vdb.cfg))
+	}
+	if b != nil {
+		b.Apply()
+		if vdb.cfg != *cfg {
+			vdb.slog.Info("reconfigured vectordb", "n", clen, "namespace", namespace,
+				"from", cfg, "to", vdb.cfg)
+		}
+	}
+	if bad > 0 {
+		vdb.slog.Warn("vectordb has vectors of the wrong dimension; Search ignores them",
+			"n", bad, "namespace", namespace, "dim", vdb.cfg.Dim)
+	}
 
-	vdb.slog.Info("loaded vectordb", "n", clen, "namespace", namespace)
+	// Load all the previously-stored metadata.
+	for key, getVal := range vdb.storage.Scan(
+		ordered.Encode("llm.VectorMeta", namespace),
+		ordered.Encode("llm.VectorMeta", namespace, ordered.Inf)) {
+
+		var id string
+		if err := ordered.Decode(key, nil, nil, &id); err != nil {
+			// unreachable except data corruption
+			panic(fmt.Errorf("MemVectorDB decode key=%v: %v", Fmt(key), err))
+		}
+		var meta VectorMeta
+		if err := json.Unmarshal(getVal(), &meta); err != nil {
+			// unreachable except data corruption
+			panic(fmt.Errorf("MemVectorDB decode key=%v: %v", Fmt(key), err))
+		}
+		vdb.meta[id] = meta
+	}
+
+	vdb.slog.Info("loaded vectordb", "n", clen, "namespace", namespace,
+		"precision", vdb.cfg.Precision, "metric", vdb.cfg.Metric, "dim", vdb.cfg.Dim)
 	return vdb
 }
 
-func (db *memVectorDB) Set(id string, vec llm.Vector) {
+func (db *memVectorDB) Set(id string, vec llm.Vector) error {
 	// No need to put db.storage.Set under db.mu.Lock() since
 	// it does its own locking. The other potentially problematic
 	// contention is between what is in db.storage and db.cache
@@ -289,26 +370,114 @@ func (db *memVectorDB) Set(id string, vec llm.Vector) {
 	if len(id) == 0 {
 		db.storage.Panic("memVectorDB set: empty ID")
 	}
-	db.storage.Set(ordered.Encode("llm.Vector", db.namespace, id), vec.Encode())
+	if err := checkDim(db.namespace, db.cfg.Dim, vec); err != nil {
+		return err
+	}
+	q := db.quantize(vec)
+	db.storage.Set(ordered.Encode("llm.Vector", db.namespace, id), q.Encode())
 
 	db.mu.Lock()
-	db.cache.Set(id, slices.Clone(vec))
+	db.put(id, newMemVector(q))
 	db.mu.Unlock()
+	return nil
+}
+
+// put sets the cached vector for id.
+// The caller must hold db.mu.
+func (db *memVectorDB) put(id string, v memVector) {
+	db.cache.Set(id, v)
+	if i, ok := db.slots[id]; ok {
+		db.vecs[i].v = v
+		return
+	}
+	db.slots[id] = len(db.vecs)
+	db.vecs = append(db.vecs, memEntry{id, v})
+}
+
+// remove deletes any cached vector for id,
+// moving the last entry of db.vecs into its slot.
+// The caller must hold db.mu.
+func (db *memVectorDB) remove(id string) {
+	i, ok := db.slots[id]
+	if !ok {
+		return
+	}
+	db.cache.Delete(id)
+	delete(db.slots, id)
+	last := len(db.vecs) - 1
+	if i != last {
+		db.vecs[i] = db.vecs[last]
+		db.slots[db.vecs[i].id] = i
+	}
+	db.vecs[last] = memEntry{}
+	db.vecs = db.vecs[:last]
+}
+
+// quantize returns a copy of vec prepared for db's metric
+// and stored with db's precision.
+func (db *memVectorDB) quantize(vec llm.Vector) llm.QuantizedVector {
+	vec = db.cfg.Metric.prepare(vec)
+	if db.cfg.Precision == llm.Float32 {
+		vec = slices.Clone(vec)
+	}
+	return db.cfg.Precision.Quantize(vec)
 }
 
 func (db *memVectorDB) Delete(id string) {
-	db.storage.Delete(ordered.Encode("llm.Vector", db.namespace, id))
+	b := db.storage.Batch()
+	b.Delete(ordered.Encode("llm.Vector", db.namespace, id))
+	b.Delete(ordered.Encode("llm.VectorMeta", db.namespace, id))
+	b.Apply()
 
 	db.mu.Lock()
-	db.cache.Delete(id)
+	db.remove(id)
+	delete(db.meta, id)
 	db.mu.Unlock()
 }
 
+func (db *memVectorDB) SetMeta(id string, meta VectorMeta) {
+	if len(id) == 0 {
+		db.storage.Panic("memVectorDB set meta: empty ID")
+	}
+	key := ordered.Encode("llm.VectorMeta", db.namespace, id)
+	if len(meta) == 0 {
+		db.storage.Delete(key)
+	} else {
+		db.storage.Set(key, JSON(meta))
+	}
+
+	db.mu.Lock()
+	db.setMeta(id, maps.Clone(meta))
+	db.mu.Unlock()
+}
+
+// setMeta sets the in-memory copy of the metadata for id.
+// The caller must hold db.mu.
+func (db *memVectorDB) setMeta(id string, meta VectorMeta) {
+	if len(meta) == 0 {
+		delete(db.meta, id)
+	} else {
+		db.meta[id] = meta
+	}
+}
+
+func (db *memVectorDB) Meta(id string) VectorMeta {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.meta[id]
+}
+
+// Get returns the vector for name.
+// If db stores vectors with reduced precision, the result
+// is the stored approximation of the vector passed to Set.
 func (db *memVectorDB) Get(name string) (llm.Vector, bool) {
 	db.mu.RLock()
-	vec, ok := db.cache.Get(name)
+	v, ok := db.cache.Get(name)
 	db.mu.RUnlock()
-	return vec, ok
+	if !ok {
+		return nil, false
+	}
+	return v.q.Vector(), true
 }
 
 // All returns all ID-vector pairs in lexicographic order of IDs.
@@ -323,8 +492,8 @@ func (db *memVectorDB) All() iter.Seq2[string, func() llm.Vector] {
 		}()
 		// Iterate through the cache since we have an invariant that
 		// both the cache and the underlying storage are synced.
-		for id, vec := range db.cache.All() {
-			val := func() llm.Vector { return vec }
+		for id, v := range db.cache.All() {
+			val := func() llm.Vector { return v.q.Vector() }
 			db.mu.RUnlock()
 			locked = false
 			if !yield(id, val) {
@@ -336,19 +505,110 @@ func (db *memVectorDB) All() iter.Seq2[string, func() llm.Vector] {
 	}
 }
 
-func (db *memVectorDB) Search(target llm.Vector, n int) []VectorResult {
+// Search computes the similarity scores directly on the stored vectors,
+// without converting quantized vectors back to float32.
+// Float32 vectors are compared using the faster but slightly less precise
+// [llm.Vector.Dot32], and the best are then rescored exactly.
+//
+// A large database is split into shards, each searched by its own goroutine,
+// up to GOMAXPROCS at a time.
+// The shard results are merged using the same ordering as a single search
+// (score, then ID), so the results do not depend on the sharding.
+func (db *memVectorDB) Search(target llm.Vector, n int) ([]VectorResult, error) {
+	return db.SearchFilter(target, n, nil)
+}
+
+func (db *memVectorDB) SearchFilter(target llm.Vector, n int, f *VectorFilter) ([]VectorResult, error) {
+	if err := checkDim(db.namespace, db.cfg.Dim, target); err != nil {
+		return nil, err
+	}
+	m := db.cfg.Metric
+	target = m.prepare(target)
+	norm2 := target.Dot(target)
+
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	best := top.New(n, VectorResult.cmp)
-	for name, vec := range db.cache.All() {
-		if len(vec) != len(target) {
-			continue
+
+	// An ID list or prefix selects a range of the ordered cache;
+	// scan just that range.
+	if f != nil && (f.IDs != nil || f.Prefix != "") {
+		match := f.matcher()
+		best := newResultHeap(n)
+		for name, v := range scanIDs(&db.cache, f) {
+			if v.q.Len() != len(target) || !match(name, db.meta[name]) {
+				continue
+			}
+			best.add(VectorResult{name, m.sim(v.dot(target), v.norm2, norm2)})
 		}
-		best.Add(VectorResult{name, target.Dot(vec)})
+		return db.results(m, target, norm2, best), nil
 	}
-	return best.Take()
+
+	var match func(VectorMeta) bool
+	if f != nil {
+		match = f.Match
+	}
+	search := func(vecs []memEntry) resultHeap {
+		best := newResultHeap(n)
+		for i := range vecs {
+			e := &vecs[i]
+			if e.v.q.Len() != len(target) || match != nil && !match(db.meta[e.id]) {
+				continue
+			}
+			best.add(VectorResult{e.id, m.sim(e.v.dot(target), e.v.norm2, norm2)})
+		}
+		return best
+	}
+
+	shards := memSearchWorkers
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	shards = min(shards, len(db.vecs)/memSearchShardMin)
+	if shards <= 1 {
+		return db.results(m, target, norm2, search(db.vecs)), nil
+	}
+	results := make([]resultHeap, shards)
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = search(db.vecs[i*len(db.vecs)/shards : (i+1)*len(db.vecs)/shards])
+		}()
+	}
+	wg.Wait()
+	best := newResultHeap(n)
+	for _, rs := range results {
+		for _, r := range rs {
+			best.add(r)
+		}
+	}
+	return db.results(m, target, norm2, best), nil
+}
+
+// results returns the search results in best, best first.
+// Float32 results were selected using the approximate [llm.Vector.Dot32],
+// so results recomputes their scores exactly,
+// making them the same as those of other VectorDBs.
+// The caller must hold db.mu.
+func (db *memVectorDB) results(m Metric, target llm.Vector, norm2 float64, best resultHeap) []VectorResult {
+	for i := range best {
+		if v, _ := db.cache.Get(best[i].ID); v.q.Precision() == llm.Float32 {
+			best[i].Score = m.sim(v.q.Dot(target), v.norm2, norm2)
+		}
+	}
+	return scoreResults(m, best.take())
 }
 
+// memSearchShardMin is the minimum number of vectors
+// in each shard of a parallel Search.
+const memSearchShardMin = 4096
+
+// memSearchWorkers, if positive, overrides GOMAXPROCS
+// as the maximum number of shards in a Search.
+// It is a variable for testing and benchmarking.
+var memSearchWorkers = 0
+
 func (db *memVectorDB) Flush() {
 	db.storage.Flush()
 }
@@ -357,29 +617,52 @@ func (db *memVectorDB) Flush() {
 type memVectorBatch struct {
 	db *memVectorDB          // underlying memVectorDB
 	sb Batch                 // batch for underlying DB
-	w  map[string]llm.Vector // vectors to write
+	w  map[string]memVector  // vectors to write
 	d  map[string]bool       // vectors to delete
+	m  map[string]VectorMeta // metadata to write (nil to delete)
 }
 
 func (db *memVectorDB) Batch() VectorBatch {
-	return &memVectorBatch{db, db.storage.Batch(), make(map[string]llm.Vector), make(map[string]bool)}
+	return &memVectorBatch{db, db.storage.Batch(), make(map[string]memVector),
+		make(map[string]bool), make(map[string]VectorMeta)}
 }
 
-func (b *memVectorBatch) Set(name string, vec llm.Vector) {
+func (b *memVectorBatch) Set(name string, vec llm.Vector) error {
 	if len(name) == 0 {
 		b.db.storage.Panic("memVectorDB batch set: empty ID")
 	}
-	b.sb.Set(ordered.Encode("llm.Vector", b.db.namespace, name), vec.Encode())
+	if err := checkDim(b.db.namespace, b.db.cfg.Dim, vec); err != nil {
+		return err
+	}
+	q := b.db.quantize(vec)
+	b.sb.Set(ordered.Encode("llm.Vector", b.db.namespace, name), q.Encode())
 
 	delete(b.d, name)
-	b.w[name] = slices.Clone(vec)
+	b.w[name] = newMemVector(q)
+	return nil
 }
 
 func (b *memVectorBatch) Delete(name string) {
 	b.sb.Delete(ordered.Encode("llm.Vector", b.db.namespace, name))
+	b.sb.Delete(ordered.Encode("llm.VectorMeta", b.db.namespace, name))
 
 	delete(b.w, name)
 	b.d[name] = true
+	b.m[name] = nil
+}
+
+func (b *memVectorBatch) SetMeta(name string, meta VectorMeta) {
+	if len(name) == 0 {
+		b.db.storage.Panic("memVectorDB batch set meta: empty ID")
+	}
+	key := ordered.Encode("llm.VectorMeta", b.db.namespace, name)
+	if len(meta) == 0 {
+		b.sb.Delete(key)
+		b.m[name] = nil
+	} else {
+		b.sb.Set(key, JSON(meta))
+		b.m[name] = maps.Clone(meta)
+	}
 }
 
 func (b *memVectorBatch) MaybeApply() bool {
@@ -397,12 +680,17 @@ func (b *memVectorBatch) Apply() {
 	defer b.db.mu.Unlock()
 
 	for name, vec := range b.w {
-		b.db.cache.Set(name, vec)
+		b.db.put(name, vec)
 	}
 	clear(b.w)
 
 	for name := range b.d {
-		b.db.cache.Delete(name)
+		b.db.remove(name)
 	}
 	clear(b.d)
+
+	for name, meta := range b.m {
+		b.db.setMeta(name, meta)
+	}
+	clear(b.m)
 }
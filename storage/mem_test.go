@@ -5,9 +5,20 @@
 package storage
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"slices"
 	"testing"
 
+	"github.com/superryanguo/ryai/llm"
 	"github.com/superryanguo/ryai/testutil"
+	"rsc.io/ordered"
+	"rsc.io/top"
 )
 
 func TestMemDB(t *testing.T) {
@@ -59,3 +70,369 @@ func TestMemVectorBatchMaybeApply(t *testing.T) {
 		t.Errorf("Get(apple3) failed after MaybeApply that did apply")
 	}
 }
+
+func TestMemVectorDBPrecision(t *testing.T) {
+	lg := testutil.Slogger(t)
+	r := rand.New(rand.NewPCG(1, 2))
+	const N = 256
+	var ids []string
+	var vecs []llm.Vector
+	for i := range 1000 {
+		ids = append(ids, fmt.Sprintf("doc%d", i))
+		vecs = append(vecs, randUnit(r, N))
+	}
+	var queries []llm.Vector
+	for range 20 {
+		queries = append(queries, randUnit(r, N))
+	}
+	base := MemVectorDB(MemDB(), lg, "")
+	for i, id := range ids {
+		base.Set(id, vecs[i])
+	}
+
+	for _, tt := range []struct {
+		p      llm.Precision
+		maxErr float64 // maximum score error
+	}{
+		{llm.Float32, 0},
+		{llm.Int16, 1e-4},
+		{llm.Int8, 0.01},
+	} {
+		t.Run(tt.p.String(), func(t *testing.T) {
+			// Small vectors from TestVectorDB keep their exact order.
+			vdb := NewMemVectorDB(MemDB(), lg, "", &VectorOptions{Precision: tt.p})
+			for _, name := range []string{"apple3", "apple4", "orange1", "orange2", "orange4"} {
+				vdb.Set(name, embed(name))
+			}
+			var have []string
+			rs, err := vdb.Search(embed("apple5"), 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range rs {
+				have = append(have, r.ID)
+			}
+			if want := []string{"apple4", "apple3", "orange1", "orange2", "orange4"}; !slices.Equal(have, want) {
+				t.Errorf("Search(apple5) = %v, want %v", have, want)
+			}
+
+			vdb = NewMemVectorDB(MemDB(), lg, "", &VectorOptions{Precision: tt.p})
+			b := vdb.Batch()
+			for i, id := range ids {
+				b.Set(id, vecs[i])
+			}
+			b.Apply()
+			checkRanking(t, base, vdb, queries, 10, tt.maxErr)
+		})
+	}
+}
+
+// checkRanking checks that searches of vdb for the queries
+// return scores within maxErr of the scores in the baseline database,
+// and that vdb's results include every baseline result
+// that scores clearly (by more than 2×maxErr) better than
+// the worst result returned by vdb.
+func checkRanking(t *testing.T, base, vdb VectorDB, queries []llm.Vector, n int, maxErr float64) {
+	t.Helper()
+	worst := 0.0
+	for _, q := range queries {
+		want, err := base.Search(q, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := vdb.Search(q, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(have) != len(want) {
+			t.Fatalf("Search returned %d results, want %d", len(have), len(want))
+		}
+		found := make(map[string]bool)
+		for _, r := range have {
+			found[r.ID] = true
+			v, _ := base.Get(r.ID)
+			worst = max(worst, math.Abs(r.Score-q.Dot(v)))
+		}
+		for _, r := range want {
+			if !found[r.ID] && r.Score > have[len(have)-1].Score+2*maxErr {
+				t.Errorf("Search missed %s (score %v); results %v", r.ID, r.Score, have)
+			}
+		}
+	}
+	if worst > maxErr {
+		t.Errorf("worst score error %v > %v", worst, maxErr)
+	}
+}
+
+func TestMemVectorDBConvert(t *testing.T) {
+	lg := testutil.Slogger(t)
+	db := MemDB()
+	vdb := MemVectorDB(db, lg, "ns")
+	vdb.Set("apple3", embed("apple3"))
+	vdb.Set("orange1", embed("orange1"))
+	MemVectorDB(db, lg, "other").Set("apple3", embed("apple3"))
+
+	check := func(p llm.Precision, size int, maxErr float64) {
+		t.Helper()
+		for _, vdb := range []VectorDB{
+			NewMemVectorDB(db, lg, "ns", &VectorOptions{Precision: p}),
+			MemVectorDB(db, lg, "ns"), // remembers precision
+		} {
+			if enc, _ := db.Get(ordered.Encode("llm.Vector", "ns", "apple3")); len(enc) != size {
+				t.Errorf("%v: stored vector has %d bytes, want %d", p, len(enc), size)
+			}
+			for _, name := range []string{"apple3", "orange1"} {
+				v, ok := vdb.Get(name)
+				if !ok {
+					t.Fatalf("%v: Get(%s) failed", p, name)
+				}
+				for i, f := range embed(name) {
+					if math.Abs(float64(v[i]-f)) > maxErr {
+						t.Fatalf("%v: Get(%s) = %v, want %v", p, name, v, embed(name))
+					}
+				}
+			}
+		}
+	}
+	check(llm.Float32, 64, 0)
+	check(llm.Int16, 36, 1e-4)
+	check(llm.Int8, 20, 0.01)
+	check(llm.Float32, 64, 0.01)
+
+	// Other namespaces are unaffected.
+	if enc, _ := db.Get(ordered.Encode("llm.Vector", "other", "apple3")); len(enc) != 64 {
+		t.Errorf("other namespace: stored vector has %d bytes, want 64", len(enc))
+	}
+}
+
+// randUnit returns a random unit vector of length n.
+func randUnit(r *rand.Rand, n int) llm.Vector {
+	v := make(llm.Vector, n)
+	d := 0.0
+	for i := range v {
+		v[i] = float32(r.NormFloat64())
+		d += float64(v[i]) * float64(v[i])
+	}
+	d = 1 / math.Sqrt(d)
+	for i := range v {
+		v[i] = float32(float64(v[i]) * d)
+	}
+	return v
+}
+
+func TestMemVectorDBMetric(t *testing.T) {
+	lg := testutil.Slogger(t)
+	testMetric(t, func(db DB, opts *VectorOptions) VectorDB {
+		if opts == nil {
+			return MemVectorDB(db, lg, "")
+		}
+		return NewMemVectorDB(db, lg, "", opts)
+	})
+}
+
+// testMetric tests the Metric and Dim options of a VectorDB.
+// The open function returns a VectorDB using db with the given options,
+// or with the stored options if opts is nil.
+func testMetric(t *testing.T, open func(db DB, opts *VectorOptions) VectorDB) {
+	search := func(vdb VectorDB, vec llm.Vector) []VectorResult {
+		t.Helper()
+		rs, err := vdb.Search(vec, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range rs {
+			rs[i].Score = math.Round(rs[i].Score*1e4) / 1e4
+		}
+		return rs
+	}
+	check := func(name string, have, want []VectorResult) {
+		t.Helper()
+		if !slices.Equal(have, want) {
+			t.Errorf("%s: Search = %v, want %v", name, have, want)
+		}
+	}
+
+	// Cosine normalizes vectors and enforces the dimension.
+	db := MemDB()
+	vdb := open(db, &VectorOptions{Metric: Cosine, Dim: 3})
+	if err := vdb.Set("a", llm.Vector{3, 4, 0}); err != nil {
+		t.Fatal(err)
+	}
+	vdb.Set("b", llm.Vector{0, 0, -2})
+	for _, vdb := range []VectorDB{vdb, open(db, nil)} {
+		if v, _ := vdb.Get("a"); !slices.Equal(v, llm.Vector{0.6, 0.8, 0}) {
+			t.Errorf("cosine: Get(a) = %v, want [0.6 0.8 0]", v)
+		}
+		check("cosine", search(vdb, llm.Vector{0, 2, 0}), []VectorResult{{"a", 0.8}, {"b", 0}})
+
+		var derr *DimensionError
+		if err := vdb.Set("c", llm.Vector{1, 2}); !errors.As(err, &derr) || derr.Dim != 3 || derr.Len != 2 {
+			t.Errorf("Set(short) = %v, want DimensionError", err)
+		}
+		if err := vdb.Batch().Set("c", llm.Vector{1, 2, 3, 4}); !errors.As(err, &derr) {
+			t.Errorf("Batch.Set(long) = %v, want DimensionError", err)
+		}
+		if _, err := vdb.Search(llm.Vector{1}, 1); !errors.As(err, &derr) {
+			t.Errorf("Search(short) = %v, want DimensionError", err)
+		}
+		if _, ok := vdb.Get("c"); ok {
+			t.Errorf("Get(c) succeeded after rejected Set")
+		}
+	}
+
+	// Dot keeps magnitudes, and L2 ranks by distance.
+	for _, tt := range []struct {
+		m    Metric
+		want []VectorResult
+	}{
+		{Dot, []VectorResult{{"x2", 0.8808}, {"x1", 0.7311}, {"y", 0.5}, {"neg", 0.2689}}},
+		{L2, []VectorResult{{"x1", 1}, {"x2", 0.5}, {"y", 0.4142}, {"neg", 0.3333}}},
+	} {
+		db := MemDB()
+		vdb := open(db, &VectorOptions{Metric: tt.m})
+		b := vdb.Batch()
+		b.Set("x1", llm.Vector{1, 0})
+		b.Set("x2", llm.Vector{2, 0})
+		b.Set("y", llm.Vector{0, 1})
+		b.Set("neg", llm.Vector{-1, 0})
+		b.Apply()
+		check(tt.m.String(), search(vdb, llm.Vector{1, 0}), tt.want)
+		check(tt.m.String()+" reopened", search(open(db, nil), llm.Vector{1, 0}), tt.want)
+
+		// Switching to Cosine normalizes the stored vectors.
+		vdb = open(db, &VectorOptions{Metric: Cosine})
+		if v, _ := vdb.Get("x2"); !slices.Equal(v, llm.Vector{1, 0}) {
+			t.Errorf("%v to cosine: Get(x2) = %v, want [1 0]", tt.m, v)
+		}
+		check(tt.m.String()+" to cosine", search(vdb, llm.Vector{0, 3}), []VectorResult{{"y", 1}, {"x2", 0}, {"x1", 0}, {"neg", 0}})
+	}
+
+	for _, m := range []Metric{Cosine, Dot, L2} {
+		if m2, err := ParseMetric(m.String()); m2 != m || err != nil {
+			t.Errorf("ParseMetric(%q) = %v, %v, want %v, nil", m.String(), m2, err, m)
+		}
+	}
+	if _, err := ParseMetric("hamming"); err == nil {
+		t.Errorf(`ParseMetric("hamming") succeeded`)
+	}
+}
+
+// refSearch is the reference implementation of memVectorDB.SearchFilter
+// for filters without IDs or prefix: a sequential scan of the ordered cache,
+// computing dot products in float64.
+func refSearch(db *memVectorDB, target llm.Vector, n int, match func(VectorMeta) bool) []VectorResult {
+	m := db.cfg.Metric
+	target = m.prepare(target)
+	norm2 := target.Dot(target)
+	best := top.New(n, VectorResult.cmp)
+	for id, v := range db.cache.All() {
+		if v.q.Len() == len(target) && (match == nil || match(db.meta[id])) {
+			best.Add(VectorResult{id, m.sim(v.q.Dot(target), v.norm2, norm2)})
+		}
+	}
+	return scoreResults(m, best.Take())
+}
+
+func TestMemVectorDBShards(t *testing.T) {
+	defer func(n int) { memSearchWorkers = n }(memSearchWorkers)
+
+	// Enough vectors for three uneven shards.
+	ids, vecs, queries := hnswTestData(3*memSearchShardMin+100, 16)
+	queries = queries[:10]
+	vdb := MemVectorDB(MemDB(), testutil.Slogger(t), "").(*memVectorDB)
+	b := vdb.Batch()
+	for i, id := range ids {
+		b.Set(id, vecs[i])
+		b.SetMeta(id, VectorMeta{"odd": fmt.Sprint(i%2 == 1)})
+	}
+	b.Apply()
+	// Delete and replace some vectors, moving entries between shards.
+	for i, id := range ids[:1000] {
+		if i%3 == 0 {
+			vdb.Delete(id)
+		} else {
+			vdb.Set(id, vecs[len(vecs)-1-i])
+		}
+	}
+	if n := len(allIDs(vdb)); len(vdb.vecs) != n || len(vdb.slots) != n {
+		t.Fatalf("have %d vecs, %d slots, want %d", len(vdb.vecs), len(vdb.slots), n)
+	}
+
+	odd := &VectorFilter{Match: func(m VectorMeta) bool { return m["odd"] == "true" }}
+	for _, q := range queries {
+		for _, f := range []*VectorFilter{nil, odd} {
+			var match func(VectorMeta) bool
+			if f != nil {
+				match = f.Match
+			}
+			want := refSearch(vdb, q, 10, match)
+			var first []VectorResult
+			for _, workers := range []int{1, 2, 3} {
+				memSearchWorkers = workers
+				have, err := vdb.SearchFilter(q, 10, f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !sameResults(have, want) {
+					t.Fatalf("workers=%d: Search:\nhave %v\nwant %v", workers, have, want)
+				}
+				// The float32 results must not depend on the sharding.
+				if first == nil {
+					first = have
+				} else if !slices.Equal(have, first) {
+					t.Fatalf("workers=%d: Search:\nhave %v\nwant %v", workers, have, first)
+				}
+			}
+		}
+	}
+}
+
+// sameResults reports whether have and want list the same IDs
+// with the same scores, up to the rounding error of [llm.Vector.Dot32],
+// which can reorder or swap results with nearly equal scores.
+func sameResults(have, want []VectorResult) bool {
+	return slices.EqualFunc(have, want, func(x, y VectorResult) bool {
+		return x.ID == y.ID && math.Abs(x.Score-y.Score) <= 1e-6
+	})
+}
+
+// BenchmarkMemVectorDBSearch compares the reference sequential float64
+// search with the float32 search, serial and sharded across GOMAXPROCS,
+// on 100,000 1024-dimensional vectors.
+func BenchmarkMemVectorDBSearch(b *testing.B) {
+	defer func(n int) { memSearchWorkers = n }(memSearchWorkers)
+
+	const N, dim = 100_000, 1024
+	r := rand.New(rand.NewPCG(1, 2))
+	vdb := MemVectorDB(MemDB(), slog.New(slog.NewTextHandler(io.Discard, nil)), "").(*memVectorDB)
+	vb := vdb.Batch()
+	for i := range N {
+		vb.Set(fmt.Sprintf("doc%d", i), randUnit(r, dim))
+		vb.MaybeApply()
+	}
+	vb.Apply()
+	var queries []llm.Vector
+	for range 16 {
+		queries = append(queries, randUnit(r, dim))
+	}
+
+	b.Run("reference", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := range b.N {
+			refSearch(vdb, queries[i%len(queries)], 10, nil)
+		}
+	})
+	for _, workers := range []int{1, 0} {
+		name := "serial"
+		if workers == 0 {
+			name = fmt.Sprintf("parallel-%d", runtime.GOMAXPROCS(0))
+		}
+		b.Run(name, func(b *testing.B) {
+			memSearchWorkers = workers
+			b.ReportAllocs()
+			for i := range b.N {
+				vdb.Search(queries[i%len(queries)], 10)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package storage
+
+import "os"
+
+// mapFile returns a copy of at least the first size bytes of f.
+// If old, the result of an earlier mapFile call, is already large enough,
+// mapFile returns old; otherwise it reads only the bytes past the end of old.
+// Without memory mapping, the copy is held in memory,
+// but the file is only ever appended to, so the copy stays accurate.
+func mapFile(f *os.File, old []byte, size int64) ([]byte, error) {
+	if size <= int64(len(old)) {
+		return old, nil
+	}
+	data := make([]byte, size)
+	copy(data, old)
+	if _, err := f.ReadAt(data[len(old):], int64(len(old))); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// unmapFile releases data, which was returned by mapFile.
+func unmapFile(data []byte) error {
+	return nil
+}
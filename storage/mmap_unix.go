@@ -0,0 +1,42 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile returns a read-only memory mapping of
+// at least the first size bytes of f.
+// If old, the result of an earlier mapFile call, is already large enough,
+// mapFile returns old; otherwise it unmaps old and returns a new mapping.
+// The mapping may extend past the end of f;
+// the caller must not access those bytes.
+func mapFile(f *os.File, old []byte, size int64) ([]byte, error) {
+	if size <= int64(len(old)) {
+		return old, nil
+	}
+	if err := unmapFile(old); err != nil {
+		return nil, err
+	}
+	// Grow by doubling, so that a file being appended to is remapped
+	// only a logarithmic number of times.
+	n := int64(64 << 10)
+	for n < size {
+		n *= 2
+	}
+	return syscall.Mmap(int(f.Fd()), 0, int(n), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile unmaps data, which was returned by mapFile.
+func unmapFile(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
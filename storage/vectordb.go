@@ -6,9 +6,17 @@ package storage
 
 import (
 	"cmp"
+	"container/heap"
+	"encoding/json"
+	"fmt"
 	"iter"
+	"math"
+	"slices"
+	"strings"
 
 	"github.com/superryanguo/ryai/llm"
+	"rsc.io/omap"
+	"rsc.io/ordered"
 )
 
 // A VectorDB is a vector database that implements
@@ -17,9 +25,13 @@ import (
 type VectorDB interface {
 	// Set sets the vector associated with the given document ID to vec.
 	// The id argument must not be empty.
-	Set(id string, vec llm.Vector)
+	// If the database uses the [Cosine] metric, Set stores vec
+	// scaled to unit length.
+	// If the database declares a dimension (see [VectorOptions])
+	// and vec has a different length, Set returns a [*DimensionError].
+	Set(id string, vec llm.Vector) error
 
-	// Delete deletes any vector associated with document ID key.
+	// Delete deletes any vector and metadata associated with document ID key.
 	// Delete of an unset key is a no-op.
 	Delete(id string)
 
@@ -48,12 +60,32 @@ type VectorDB interface {
 	Batch() VectorBatch
 
 	// Search searches the database for the n vectors
-	// most similar to vec, returning the document IDs
-	// and similarity scores.
+	// most similar to vec, according to the database's [Metric],
+	// returning the document IDs and similarity scores.
 	//
 	// Normally a VectorDB is used entirely with vectors of a single length.
-	// Search ignores stored vectors with a different length than vec.
-	Search(vec llm.Vector, n int) []VectorResult
+	// If the database declares a dimension (see [VectorOptions])
+	// and vec has a different length, Search returns a [*DimensionError].
+	// Otherwise, Search ignores stored vectors with a different length than vec.
+	Search(vec llm.Vector, n int) ([]VectorResult, error)
+
+	// SearchFilter is like Search but considers only documents matching f.
+	// The filtering happens during the search, so SearchFilter
+	// returns n results if at least n stored vectors match.
+	SearchFilter(vec llm.Vector, n int, f *VectorFilter) ([]VectorResult, error)
+
+	// SetMeta sets the metadata associated with the given document ID
+	// to meta, replacing any previous metadata.
+	// An empty meta deletes the metadata.
+	// The metadata is kept separately from the vector,
+	// so Set does not change it, but Delete deletes it.
+	// The id argument must not be empty.
+	SetMeta(id string, meta VectorMeta)
+
+	// Meta returns the metadata associated with the given document ID,
+	// or nil if there is none.
+	// The caller must not modify the result.
+	Meta(id string) VectorMeta
 
 	// Flush flushes storage to disk.
 	Flush()
@@ -66,12 +98,18 @@ type VectorDB interface {
 // The batched operations apply in the order they are made.
 type VectorBatch interface {
 	// Set sets the vector associated with the given document ID to vec.
-	Set(id string, vec llm.Vector)
+	// Like VectorDB.Set, it returns a [*DimensionError]
+	// for a vector of the wrong length.
+	Set(id string, vec llm.Vector) error
 
-	// Delete deletes any vector associated with document ID key.
+	// Delete deletes any vector and metadata associated with document ID key.
 	// Delete of an unset key is a no-op.
 	Delete(id string)
 
+	// SetMeta sets the metadata associated with the given document ID to meta.
+	// An empty meta deletes the metadata.
+	SetMeta(id string, meta VectorMeta)
+
 	// MaybeApply calls Apply if the VectorBatch is getting close to full.
 	// Every VectorBatch has a limit to how many operations can be batched,
 	// so in a bulk operation where atomicity of the entire batch is not a concern,
@@ -92,7 +130,7 @@ type VectorBatch interface {
 // A VectorResult is a single document returned by a VectorDB search.
 type VectorResult struct {
 	ID    string  // document ID
-	Score float64 // similarity score in range [0, 1]; 1 is exact match
+	Score float64 // similarity score in range [0, 1]; 1 is exact match (see [Metric])
 }
 
 func (x VectorResult) cmp(y VectorResult) int {
@@ -101,3 +139,326 @@ func (x VectorResult) cmp(y VectorResult) int {
 	}
 	return cmp.Compare(x.ID, y.ID)
 }
+
+// A resultHeap collects the best n VectorResults added to it,
+// for n = cap(h). Once full, it is a min-heap with the worst result at h[0].
+// Unlike a [top.TopN], adding to a resultHeap does not allocate.
+type resultHeap []VectorResult
+
+func newResultHeap(n int) resultHeap {
+	return make(resultHeap, 0, max(n, 0))
+}
+
+// full reports whether h holds n results,
+// so that add discards results worse than h[0].
+func (h resultHeap) full() bool {
+	return len(h) == cap(h)
+}
+
+// add adds r to h, discarding the worst result if h is already full.
+func (h *resultHeap) add(r VectorResult) {
+	if !h.full() {
+		*h = append(*h, r)
+		if h.full() {
+			heap.Init(h)
+		}
+		return
+	}
+	if len(*h) == 0 || r.cmp((*h)[0]) <= 0 {
+		return
+	}
+	(*h)[0] = r
+	heap.Fix(h, 0)
+}
+
+// take returns the results in h, best first.
+func (h resultHeap) take() []VectorResult {
+	slices.SortFunc(h, func(x, y VectorResult) int { return y.cmp(x) })
+	return h
+}
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h[i].cmp(h[j]) < 0 }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x any)        { *h = append(*h, x.(VectorResult)) }
+func (h *resultHeap) Pop() any {
+	x := (*h)[len(*h)-1]
+	*h = (*h)[:len(*h)-1]
+	return x
+}
+
+// VectorOptions configures how a [VectorDB] stores and compares its vectors.
+type VectorOptions struct {
+	// Precision is the precision of the stored vectors.
+	// Lower precisions use less space at the cost of
+	// slightly less accurate search scores (see [llm.Precision]).
+	Precision llm.Precision
+
+	// Metric is the similarity metric used by Search.
+	Metric Metric
+
+	// Dim, if non-zero, is the length of every vector in the database.
+	// Set and Search reject vectors of any other length.
+	Dim int
+}
+
+// A Metric is a way to measure the similarity of two vectors.
+// Each metric maps its measure to a [VectorResult] score in the range [0, 1],
+// with higher scores for more similar vectors.
+type Metric int
+
+const (
+	// Cosine measures the cosine of the angle between the vectors.
+	// Vectors are scaled to unit length when stored and searched for,
+	// so that the cosine is their dot product.
+	// The score is the cosine, with negative cosines
+	// (vectors pointing in opposite directions) reported as 0.
+	Cosine Metric = iota
+
+	// Dot measures the dot product of the vectors as given,
+	// for embedding models whose vector lengths carry meaning.
+	// Since the dot product is unbounded, the score is the
+	// logistic function of the dot product, 1/(1+e^-dot).
+	Dot
+
+	// L2 measures the Euclidean distance between the vectors.
+	// The score is 1/(1+distance).
+	L2
+)
+
+var metricNames = []string{
+	Cosine: "cosine",
+	Dot:    "dot",
+	L2:     "l2",
+}
+
+func (m Metric) String() string {
+	if 0 <= m && int(m) < len(metricNames) {
+		return metricNames[m]
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// ParseMetric returns the metric with the given name:
+// "cosine", "dot", or "l2".
+// The empty string means Cosine.
+func ParseMetric(name string) (Metric, error) {
+	if name == "" {
+		return Cosine, nil
+	}
+	for m, n := range metricNames {
+		if n == name {
+			return Metric(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown vector metric %q", name)
+}
+
+// prepare returns vec as it should be stored or searched for with metric m.
+// For Cosine, that is vec scaled to unit length.
+// Vectors already of unit length, up to float32 rounding error,
+// are returned unchanged.
+// prepare never modifies vec itself.
+func (m Metric) prepare(vec llm.Vector) llm.Vector {
+	if m != Cosine {
+		return vec
+	}
+	n := length(vec.Dot(vec))
+	if n == 1 {
+		return vec
+	}
+	unit := make(llm.Vector, len(vec))
+	for i, f := range vec {
+		unit[i] = float32(float64(f) / n)
+	}
+	return unit
+}
+
+// length returns the length of a vector with squared length norm2.
+// It returns exactly 1 for vectors of unit length up to float32
+// rounding error, as well as for zero vectors,
+// so that dividing by the length is a no-op for them.
+func length(norm2 float64) float64 {
+	if norm2 == 0 || math.Abs(norm2-1) <= 1e-6 {
+		return 1
+	}
+	return math.Sqrt(norm2)
+}
+
+// sim returns the similarity under metric m of two vectors
+// with dot product dot and squared lengths norm2v and norm2w:
+// the cosine for Cosine, the dot product for Dot, and the negated
+// squared distance for L2, so that larger is always more similar.
+// (Cosine vectors are normally stored at unit length,
+// but quantization and older databases can leave them slightly off.)
+func (m Metric) sim(dot, norm2v, norm2w float64) float64 {
+	switch m {
+	case Cosine:
+		return dot / (length(norm2v) * length(norm2w))
+	case L2:
+		return -max(0, norm2v+norm2w-2*dot)
+	}
+	return dot
+}
+
+// score maps the similarity sim returned by m.sim
+// to a score in the range [0, 1].
+// Since score can map different similarities to the same score
+// (for example, large dot products all map to 1),
+// searches rank results by similarity and only then map them to scores.
+func (m Metric) score(sim float64) float64 {
+	switch m {
+	case Dot:
+		return 1 / (1 + math.Exp(-sim))
+	case L2:
+		return 1 / (1 + math.Sqrt(-sim))
+	}
+	return min(1, max(0, sim))
+}
+
+// scoreResults replaces the similarities in results,
+// as returned by m.sim, with scores, as returned by m.score.
+func scoreResults(m Metric, results []VectorResult) []VectorResult {
+	for i := range results {
+		results[i].Score = m.score(results[i].Score)
+	}
+	return results
+}
+
+// A DimensionError reports a vector with a length different from
+// the dimension declared for its [VectorDB] (see [VectorOptions]).
+type DimensionError struct {
+	Namespace string // namespace of the VectorDB
+	Dim       int    // declared dimension
+	Len       int    // length of the rejected vector
+}
+
+func (e *DimensionError) Error() string {
+	return fmt.Sprintf("vectordb %q: vector length %d does not match dimension %d", e.Namespace, e.Len, e.Dim)
+}
+
+// checkDim returns a *DimensionError if dim is non-zero
+// and vec does not have length dim.
+func checkDim(namespace string, dim int, vec llm.Vector) error {
+	if dim != 0 && len(vec) != dim {
+		return &DimensionError{Namespace: namespace, Dim: dim, Len: len(vec)}
+	}
+	return nil
+}
+
+// A vectorConfig records the configuration of a vector database namespace.
+// [MemVectorDB] stores it in the underlying DB under the key
+//
+//	ordered.Encode("llm.VectorConfig", namespace)
+//
+// A namespace with no vectorConfig stores float32 vectors,
+// compares them with the Cosine metric, and has no declared dimension.
+type vectorConfig struct {
+	Precision llm.Precision
+	Metric    Metric
+	Dim       int
+}
+
+func vectorConfigKey(namespace string) []byte {
+	return ordered.Encode("llm.VectorConfig", namespace)
+}
+
+// loadVectorConfig returns the configuration stored in db under key.
+// If there is none, it returns the default configuration and false.
+func loadVectorConfig(db DB, key []byte) (*vectorConfig, bool) {
+	c := new(vectorConfig)
+	enc, ok := db.Get(key)
+	if !ok {
+		return c, false
+	}
+	if err := json.Unmarshal(enc, c); err != nil {
+		// unreachable except data corruption
+		db.Panic("vectordb decode config", "key", Fmt(key), "err", err)
+	}
+	return c, true
+}
+
+// VectorMeta is metadata stored with a vector,
+// such as the source or last update time of the document,
+// for use by [VectorFilter].
+type VectorMeta map[string]string
+
+// A VectorFilter restricts a [VectorDB] search to matching documents.
+// A document matches if it satisfies all the set fields.
+// A nil *VectorFilter matches every document.
+type VectorFilter struct {
+	// Prefix, if non-empty, matches documents with IDs having this prefix.
+	Prefix string
+
+	// IDs, if non-nil, matches documents with IDs in this list.
+	IDs []string
+
+	// Match, if non-nil, matches documents for which Match returns true.
+	// Its argument is the document's metadata (see [VectorDB.SetMeta]),
+	// which is nil for documents with no metadata.
+	// Match must not modify the metadata or call methods of the VectorDB.
+	// A search may call Match from multiple goroutines at once.
+	Match func(VectorMeta) bool
+}
+
+// matcher returns a function reporting whether the
+// document with the given ID and metadata matches f.
+func (f *VectorFilter) matcher() func(id string, meta VectorMeta) bool {
+	if f == nil {
+		return func(string, VectorMeta) bool { return true }
+	}
+	var ids map[string]bool
+	if f.IDs != nil {
+		ids = make(map[string]bool)
+		for _, id := range f.IDs {
+			ids[id] = true
+		}
+	}
+	return func(id string, meta VectorMeta) bool {
+		return strings.HasPrefix(id, f.Prefix) &&
+			(ids == nil || ids[id]) &&
+			(f.Match == nil || f.Match(meta))
+	}
+}
+
+// scanIDs returns an iterator over the entries in m
+// with IDs that may match f, in lexicographic order of IDs.
+// If f has an ID list, only those IDs are visited;
+// if f has a prefix, only IDs with that prefix are visited.
+// The caller must still check each entry using f.matcher.
+func scanIDs[V any](m *omap.Map[string, V], f *VectorFilter) iter.Seq2[string, V] {
+	switch {
+	case f != nil && f.IDs != nil:
+		return func(yield func(string, V) bool) {
+			ids := slices.Clone(f.IDs)
+			slices.Sort(ids)
+			for _, id := range slices.Compact(ids) {
+				if v, ok := m.Get(id); ok && !yield(id, v) {
+					return
+				}
+			}
+		}
+	case f != nil && f.Prefix != "":
+		end, ok := prefixEnd(f.Prefix)
+		if !ok {
+			return m.All()
+		}
+		return m.Scan(f.Prefix, end)
+	}
+	return m.All()
+}
+
+// prefixEnd returns the smallest string greater than
+// every string with the given prefix.
+// If there is no such string (the prefix is all 0xFF bytes),
+// prefixEnd returns "", false.
+func prefixEnd(prefix string) (string, bool) {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xFF {
+			b[i]++
+			return string(b[:i+1]), true
+		}
+	}
+	return "", false
+}
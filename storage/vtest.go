@@ -86,21 +86,94 @@ func TestVectorDB(t *testing.T, opendb func() VectorDB) {
 		{"orange2", 0.3785152783773009},
 		{"orange4", 0.37429777504303363},
 	}
-	have := vdb.Search(embed("apple5"), 5)
+	have, err := vdb.Search(embed("apple5"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !reflect.DeepEqual(have, want) {
 		// unreachable except bad vectordb
 		t.Fatalf("Search(apple5, 5):\nhave %v\nwant %v", have, want)
 	}
 
+	vdb.SetMeta("apple3", VectorMeta{"kind": "apple"})
+	vdb.SetMeta("orange1", VectorMeta{"kind": "orange", "month": "2024-10"})
+	vdb.SetMeta("temp", VectorMeta{"kind": "temp"})
+	b = vdb.Batch()
+	b.SetMeta("orange2", VectorMeta{"kind": "orange"})
+	b.SetMeta("apple4", VectorMeta{"kind": "apple"})
+	b.SetMeta("apple4", nil)
+	b.Set("temp", embed("apple5"))
+	b.Delete("temp")
+	b.Apply()
+
+	testutil.StopPanic(func() {
+		vdb.SetMeta("", VectorMeta{"kind": "none"})
+		t.Fatalf("SetMeta with empty key did not panic")
+	})
+
+	if m := vdb.Meta("orange1"); !reflect.DeepEqual(m, VectorMeta{"kind": "orange", "month": "2024-10"}) {
+		t.Errorf("Meta(orange1) = %v", m)
+	}
+	for _, id := range []string{"apple4", "temp", "orange4"} {
+		if m := vdb.Meta(id); m != nil {
+			t.Errorf("Meta(%s) = %v, want nil", id, m)
+		}
+	}
+	testFilter(t, vdb)
+
 	vdb.Flush()
 
 	vdb = opendb()
-	have = vdb.Search(embed("apple5"), 3)
+	have, err = vdb.Search(embed("apple5"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
 	want = want[:3]
 	if !reflect.DeepEqual(have, want) {
 		// unreachable except bad vectordb
 		t.Errorf("Search(apple5, 3) in fresh database:\nhave %v\nwant %v", have, want)
 	}
+	testFilter(t, vdb)
+}
+
+// testFilter tests SearchFilter on the database built by TestVectorDB.
+func testFilter(t *testing.T, vdb VectorDB) {
+	t.Helper()
+	const (
+		apple3  = 0.9999843342970269
+		orange1 = 0.38062230442542155
+		orange2 = 0.3785152783773009
+		orange4 = 0.37429777504303363
+	)
+	kind := func(k string) func(VectorMeta) bool {
+		return func(m VectorMeta) bool { return m["kind"] == k }
+	}
+	for _, tt := range []struct {
+		f    *VectorFilter
+		n    int
+		want []VectorResult
+	}{
+		{nil, 1, []VectorResult{{"apple4", 0.9999961187341375}}},
+		{&VectorFilter{Prefix: "orange"}, 2, []VectorResult{{"orange1", orange1}, {"orange2", orange2}}},
+		{&VectorFilter{IDs: []string{"orange4", "apple3", "missing", "orange4"}}, 5, []VectorResult{{"apple3", apple3}, {"orange4", orange4}}},
+		{&VectorFilter{IDs: []string{}}, 5, nil},
+		{&VectorFilter{Match: kind("orange")}, 5, []VectorResult{{"orange1", orange1}, {"orange2", orange2}}},
+		{&VectorFilter{Match: kind("apple")}, 5, []VectorResult{{"apple3", apple3}}},
+		{&VectorFilter{Prefix: "orange", Match: func(m VectorMeta) bool { return m["month"] >= "2024-10" }}, 5, []VectorResult{{"orange1", orange1}}},
+		{&VectorFilter{Prefix: "orange", IDs: []string{"apple3", "orange2"}}, 5, []VectorResult{{"orange2", orange2}}},
+		{&VectorFilter{Match: func(m VectorMeta) bool { return m == nil }}, 2, []VectorResult{{"apple4", 0.9999961187341375}, {"orange4", orange4}}},
+	} {
+		have, err := vdb.SearchFilter(embed("apple5"), tt.n, tt.f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(have) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("SearchFilter(apple5, %d, %+v):\nhave %v\nwant %v", tt.n, tt.f, have, tt.want)
+		}
+	}
 }
 
 func allIDs(vdb VectorDB) []string {